@@ -8,8 +8,19 @@ import (
 )
 
 var (
-	ErrMissingServerField = errors.New("missing server (consider configuring a default server)")
-	ErrNoSuchServer       = errors.New("server configuration does not exist (was it deleted?)")
+	ErrMissingServerField      = errors.New("missing server (consider configuring a default server)")
+	ErrNoSuchServer            = errors.New("server configuration does not exist (was it deleted?)")
+	ErrMissingClientPrivateKey = errors.New("server does not have a client private key to sign assertions with")
+	ErrNotDPoPBound            = errors.New("credential is not bound to a DPoP key")
+	ErrInvalidHTU              = errors.New("htu must be an absolute URL")
+	ErrNoAuthCodeSession       = errors.New("state does not match any pending authorization request (has it expired?)")
+	ErrAuthCodeSessionExists   = errors.New("an authorization request with this state is already pending")
+	ErrExchangedTokenExpired   = errors.New("token expired")
+	ErrExchangedTokenTooLong   = errors.New("authorization server issued a token that is valid for longer than the maximum lifetime of the profile")
+	ErrActorAndActorToken      = errors.New("actor and actor_token cannot both be specified")
+	ErrActorTokenInQuery       = errors.New("actor_token must be provided in the request body (write to this path instead of reading it)")
+	ErrLogoutTokenNotCurrent   = errors.New("logout token was not issued recently")
+	ErrLogoutTokenReplayed     = errors.New("logout token has already been used")
 )
 
 func errorResponse(err error) (*logical.Response, error) {
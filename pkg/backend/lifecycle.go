@@ -31,11 +31,19 @@ func (b *backend) initialize(ctx context.Context, req *logical.InitializationReq
 	}
 
 	deviceCodeExchange := &deviceCodeExchangeDescriptor{backend: b, storage: req.Storage}
+	backchannelAuthExchange := &backchannelAuthExchangeDescriptor{backend: b, storage: req.Storage}
+	authCodeSessionReap := &authCodeSessionReapDescriptor{backend: b, storage: req.Storage}
+	logoutTokenReap := &logoutTokenReapDescriptor{backend: b, storage: req.Storage}
+	revocationRetry := &revocationRetryDescriptor{backend: b, storage: req.Storage}
 	refresh, restartRefresh := scheduler.NewRestartableDescriptor(&refreshDescriptor{backend: b, storage: req.Storage})
 	reap, restartReap := scheduler.NewRestartableDescriptor(&reapDescriptor{backend: b, storage: req.Storage})
 
 	b.scheduler = scheduler.NewSegment(16, []scheduler.Descriptor{
 		scheduler.NewRecoveryDescriptor(deviceCodeExchange, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(backchannelAuthExchange, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(authCodeSessionReap, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(logoutTokenReap, scheduler.RecoveryDescriptorWithClock(b.clock)),
+		scheduler.NewRecoveryDescriptor(revocationRetry, scheduler.RecoveryDescriptorWithClock(b.clock)),
 		scheduler.NewRecoveryDescriptor(refresh, scheduler.RecoveryDescriptorWithClock(b.clock)),
 		scheduler.NewRecoveryDescriptor(reap, scheduler.RecoveryDescriptorWithClock(b.clock)),
 	}).WithErrorBehavior(scheduler.ErrorBehaviorDrop).Start(scheduler.LifecycleStartOptions{})
@@ -3,6 +3,7 @@ package backend
 import (
 	"fmt"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
 	"github.com/openbao/openbao/sdk/v2/framework"
 	"github.com/openbao/openbao/sdk/v2/logical"
 )
@@ -16,11 +17,21 @@ func nameRegex(name string) string {
 
 func pathsSpecial() *logical.Paths {
 	return &logical.Paths{
+		Unauthenticated: []string{
+			BackchannelLogoutPathPrefix + "*",
+			CallbackPathPrefix + "*",
+		},
 		SealWrapStorage: []string{
+			persistence.AuthCodeSessionKeyPrefix,
+			persistence.BackchannelAuthKeyPrefix,
+			persistence.RevocationKeyPrefix,
 			CredsPathPrefix,
+			OBOPathPrefix,
 			SelfPathPrefix,
+			SelfSTSPathPrefix,
 			ServersPathPrefix,
 			STSPathPrefix,
+			STSProfilesPathPrefix,
 		},
 	}
 }
@@ -28,11 +39,21 @@ func pathsSpecial() *logical.Paths {
 func paths(b *backend) []*framework.Path {
 	return []*framework.Path{
 		pathAuthCodeURL(b),
+		pathBackchannelLogout(b),
+		pathCallback(b),
 		pathConfig(b),
 		pathCreds(b),
+		pathCredsDevice(b),
+		pathIntrospect(b),
+		pathLogoutURL(b),
+		pathOBO(b),
 		pathSelf(b),
+		pathSelfSTS(b),
 		pathServersList(b),
 		pathServers(b),
 		pathSTS(b),
+		pathSTSProfilesList(b),
+		pathSTSProfiles(b),
+		pathSTSProfilesCreds(b),
 	}
 }
@@ -2,7 +2,6 @@ package backend
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strings"
 
@@ -10,6 +9,10 @@ import (
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao/sdk/v2/framework"
 	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"golang.org/x/oauth2"
 )
 
 func (b *backend) authCodeURLUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -24,30 +27,118 @@ func (b *backend) authCodeURLUpdateOperation(ctx context.Context, req *logical.R
 	}
 	defer put()
 
+	credentialName := data.Get("credential_name").(string)
+	if credentialName != "" && !callbackCredentialAllowed(ops.entry, credentialName) {
+		return logical.ErrorResponse("credential %q cannot be written by the callback endpoint of server %q (check the server's callback_credential_prefix)", credentialName, serverName), nil
+	}
+
 	resp := &logical.Response{
 		Data: make(map[string]interface{}),
 	}
 
 	state, ok := data.GetOk("state")
 	if !ok {
-		rd := make([]byte, 32)
-		if _, err := b.GetRandomReader().Read(rd); err != nil {
+		state, err = b.randomString(32)
+		if err != nil {
 			return nil, err
 		}
 
-		state = base64.RawURLEncoding.EncodeToString(rd)
 		resp.Data["state"] = state
 	}
 
-	url, ok := ops.AuthCodeURL(
-		state.(string),
-		provider.WithRedirectURL(data.Get("redirect_url").(string)),
-		provider.WithScopes(data.Get("scopes").([]string)),
-		provider.WithURLParams(data.Get("auth_url_params").(map[string]string)),
-		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
-	)
-	if !ok {
-		return logical.ErrorResponse("authorization code URL not available"), nil
+	// Record the parameters of this request so that the exchange can verify
+	// the state and bind the resulting tokens to it.
+	session := &persistence.AuthCodeSessionEntry{
+		AuthServerName:       serverName,
+		RedirectURL:          data.Get("redirect_url").(string),
+		Scopes:               data.Get("scopes").([]string),
+		ProviderOptions:      data.Get("provider_options").(map[string]string),
+		CredentialName:       credentialName,
+		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
+		ExpiryTime:           b.clock.Now().Add(authCodeSessionTTL),
+	}
+
+	authURLParams := data.Get("auth_url_params").(map[string]string)
+
+	// Honor a nonce explicitly requested by the caller so that existing
+	// integrations continue to validate the same value.
+	if nonce, found := authURLParams["nonce"]; found {
+		session.Nonce = nonce
+	} else if session.Nonce, err = b.randomString(32); err != nil {
+		return nil, err
+	}
+
+	opts := []provider.AuthCodeURLOption{
+		provider.WithRedirectURL(session.RedirectURL),
+		provider.WithScopes(session.Scopes),
+		provider.WithURLParams(authURLParams),
+		provider.WithProviderOptions(session.ProviderOptions),
+		provider.WithNonce(session.Nonce),
+	}
+
+	var pkce bool
+	if v, ok := data.GetOk("pkce"); ok {
+		pkce = v.(bool)
+	} else if ops.entry.PKCE != nil {
+		pkce = *ops.entry.PKCE
+	} else {
+		// Protect the request if the authorization server says it can verify
+		// the code challenge.
+		pkce = ops.PKCESupported(opts...)
+	}
+
+	if pkce {
+		// A verifier generated from 32 random octets satisfies the length
+		// requirements of RFC 7636 § 4.1.
+		if session.CodeVerifier, err = b.randomString(32); err != nil {
+			return nil, err
+		}
+		session.CodeChallenge = oauth2.S256ChallengeFromVerifier(session.CodeVerifier)
+
+		opts = append(opts, provider.WithCodeVerifier(session.CodeVerifier))
+	}
+
+	// Only push the authorization request to the server if the server
+	// configuration asks for it. Providers do not return a plain URL if the
+	// authorization server requires pushed requests.
+	var url string
+	pushed := ops.entry.PushedAuthRequests
+	if !pushed {
+		url, ok, err = ops.AuthCodeURL(state.(string), opts...)
+		if err != nil {
+			return errorResponse(fmt.Errorf("server %q has configuration problems: %w", serverName, err))
+		}
+
+		pushed = !ok
+	}
+	if pushed {
+		url, ok, err = ops.PushedAuthCodeURL(ctx, state.(string), opts...)
+		if errmark.MarkedUser(err) {
+			return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "pushed authorization request failed").Error()), nil
+		} else if err != nil {
+			return nil, err
+		} else if !ok {
+			return logical.ErrorResponse("authorization code URL not available"), nil
+		}
+	}
+
+	// A caller that knows the state of a pending request must not be able to
+	// replace its parameters, so never overwrite a session that is still in
+	// use.
+	err = b.data.AuthCodeSession.WithLock(persistence.AuthCodeSessionState(state.(string)), func(ch *persistence.LockedAuthCodeSessionHolder) error {
+		cm := ch.Manager(req.Storage)
+
+		prev, err := cm.ReadAuthCodeSessionEntry(ctx)
+		if err != nil {
+			return err
+		} else if prev != nil && !prev.Expired(clockctx.WithClock(ctx, b.clock)) {
+			return errmark.MarkUser(ErrAuthCodeSessionExists)
+		}
+
+		return cm.WriteAuthCodeSessionEntry(ctx, session)
+	})
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	resp.Data["url"] = url
@@ -85,6 +176,18 @@ var authCodeURLFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeKVPairs,
 		Description: "Specifies any provider-specific options.",
 	},
+	"credential_name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential to write when the authorization server redirects to the callback endpoint of this plugin. The name must start with the callback_credential_prefix of the server.",
+	},
+	"maximum_expiry_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Maximum number of seconds for the access token of the credential written by the callback endpoint to be considered valid.",
+	},
+	"pkce": {
+		Type:        framework.TypeBool,
+		Description: "Whether to protect the authorization request with an RFC 7636 (PKCE) code challenge. The code must then be exchanged by providing the state to the credential endpoint. Defaults to the pkce setting of the server, or to whether the authorization server advertises support for PKCE if the server does not set it.",
+	},
 }
 
 const authCodeURLHelpSynopsis = `
@@ -96,6 +199,12 @@ This endpoint merges server configuration data with requested
 parameters like a redirect URL and scopes to create an authorization
 code URL. The code returned in the response should be written to a
 credential endpoint to start managing authentication tokens.
+
+The parameters of each request are kept for a short time, keyed by
+state. If enabled for the request or the server, each request includes
+a PKCE code challenge. Providing the state along with the code to the
+credential endpoint verifies the state and reuses the PKCE code
+verifier, nonce, and redirect URL of the original request.
 `
 
 func pathAuthCodeURL(b *backend) *framework.Path {
@@ -2,16 +2,21 @@ package backend_test
 
 import (
 	"context"
+	"net/http"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
 	"github.com/openbao/openbao/sdk/v2/logical"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
 func TestAuthCodeURL(t *testing.T) {
@@ -80,4 +85,243 @@ func TestAuthCodeURL(t *testing.T) {
 	assert.Equal(t, "http://example.com/redirect", qs.Get("redirect_uri"))
 	assert.Equal(t, "geoff", qs.Get("foo")) // Configuration takes precedence!
 	assert.Equal(t, "quux", qs.Get("baz"))
+	assert.Empty(t, qs.Get("code_challenge")) // Not enabled for the server.
+
+	// The pending request cannot be replaced by another one with the same
+	// state.
+	req.Data["redirect_url"] = "http://example.com/attacker"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.EqualError(t, resp.Error(), backend.ErrAuthCodeSessionExists.Error())
+}
+
+func TestAuthCodeURLPKCESupported(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithPKCESupported()))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	tests := []struct {
+		Name              string
+		ServerPKCE        interface{}
+		ExpectedChallenge bool
+	}{
+		{
+			Name:              "Default",
+			ExpectedChallenge: true,
+		},
+		{
+			Name:              "Disabled",
+			ServerPKCE:        false,
+			ExpectedChallenge: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			// Write server configuration.
+			req := &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      backend.ServersPathPrefix + `mock`,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"client_id":     "abc",
+					"client_secret": "def",
+					"provider":      "mock",
+				},
+			}
+			if test.ServerPKCE != nil {
+				req.Data["pkce"] = test.ServerPKCE
+			}
+
+			resp, err := b.HandleRequest(ctx, req)
+			require.NoError(t, err)
+			require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+			// The server only reports the setting if it was specified.
+			req = &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      backend.ServersPathPrefix + `mock`,
+				Storage:   storage,
+			}
+
+			resp, err = b.HandleRequest(ctx, req)
+			require.NoError(t, err)
+			require.NotNil(t, resp)
+			require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+			pkce, found := resp.Data["pkce"]
+			if test.ServerPKCE != nil {
+				assert.Equal(t, test.ServerPKCE, pkce)
+			} else {
+				assert.False(t, found)
+			}
+
+			// Retrieve an auth code URL.
+			req = &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      backend.AuthCodeURLPath,
+				Storage:   storage,
+				Data: map[string]interface{}{
+					"server":       "mock",
+					"redirect_url": "http://example.com/redirect",
+				},
+			}
+
+			resp, err = b.HandleRequest(ctx, req)
+			require.NoError(t, err)
+			require.NotNil(t, resp)
+			require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+			u, err := url.Parse(resp.Data["url"].(string))
+			require.NoError(t, err)
+
+			qs := u.Query()
+			if test.ExpectedChallenge {
+				assert.Equal(t, "S256", qs.Get("code_challenge_method"))
+				assert.NotEmpty(t, qs.Get("code_challenge"))
+			} else {
+				assert.Empty(t, qs.Get("code_challenge"))
+			}
+		})
+	}
+}
+
+func TestAuthCodeURLSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID: "abc",
+	}
+
+	var challenge, nonce string
+	var unavailable int32 = 1
+	exchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		if atomic.CompareAndSwapInt32(&unavailable, 1, 0) {
+			return nil, semerr.Map(testutil.MockErrorResponse(http.StatusServiceUnavailable, &interop.JSONError{Error: "temporarily_unavailable"}))
+		}
+
+		assert.Equal(t, challenge, oauth2.S256ChallengeFromVerifier(opts.CodeVerifier))
+		assert.Equal(t, nonce, opts.Nonce)
+		assert.Equal(t, "http://example.com/redirect", opts.RedirectURL)
+		assert.Equal(t, map[string]string{"foo": "bar"}, opts.ProviderOptions)
+
+		return testutil.RandomMockAuthCodeExchange(code, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration for a public client.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": client.ID,
+			"provider":  "mock",
+			"pkce":      true,
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Retrieve an auth code URL.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.AuthCodeURLPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":           "mock",
+			"redirect_url":     "http://example.com/redirect",
+			"provider_options": map[string]string{"foo": "bar"},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	state, ok := resp.Data["state"].(string)
+	require.True(t, ok, "response `state` field is not a string")
+	require.NotEmpty(t, state)
+
+	u, err := url.Parse(resp.Data["url"].(string))
+	require.NoError(t, err)
+
+	qs := u.Query()
+	assert.Equal(t, state, qs.Get("state"))
+	assert.Equal(t, "S256", qs.Get("code_challenge_method"))
+	assert.NotEmpty(t, qs.Get("nonce"))
+
+	challenge = qs.Get("code_challenge")
+	nonce = qs.Get("nonce")
+
+	// Exchange the code using only the state. The first attempt fails
+	// because the authorization server is unavailable, but the session
+	// remains so that it can be retried.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code":  "123456",
+			"state": state,
+		},
+	}
+
+	_, err = b.HandleRequest(ctx, req)
+	require.Error(t, err)
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotEmpty(t, resp.Data["access_token"])
+	assert.Equal(t, "mock", resp.Data["server"])
+
+	// The session can only be used once.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code":  "123456",
+			"state": state,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
 }
@@ -0,0 +1,195 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const backchannelLogoutUserError = "end-user session ended by back-channel logout"
+
+// backchannelLogoutResponse builds a response as described by OpenID Connect
+// Back-Channel Logout 1.0 § 2.8.
+func backchannelLogoutResponse(status int, jerr *interop.JSONError) (*logical.Response, error) {
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode: status,
+		},
+		Headers: map[string][]string{
+			"Cache-Control": {"no-store"},
+		},
+	}
+
+	if jerr != nil {
+		body, err := json.Marshal(jerr)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Data[logical.HTTPContentType] = "application/json"
+		resp.Data[logical.HTTPRawBody] = body
+	} else {
+		resp.Data[logical.HTTPRawBody] = []byte{}
+	}
+
+	return resp, nil
+}
+
+// logoutTokenMatches determines whether the given token belongs to the
+// end-user session identified by a logout token.
+func logoutTokenMatches(lt *provider.LogoutToken, tok *provider.Token) bool {
+	if tok == nil {
+		return false
+	}
+
+	if lt.SessionID != "" && lt.SessionID != tok.SessionID {
+		return false
+	}
+	if lt.Subject != "" && lt.Subject != tok.Subject {
+		return false
+	}
+
+	return true
+}
+
+// endBackchannelLogoutSessions marks every credential of the given server that
+// belongs to the end-user session identified by a logout token as failed and
+// revokes its tokens.
+//
+// Credentials are not indexed by server or session, so this reads every
+// credential, taking each credential's lock in turn. Logout requests are rare
+// compared to credential reads, and only well-formed, signed, unused tokens get
+// this far, so we accept this cost rather than maintaining an index.
+func (b *backend) endBackchannelLogoutSessions(ctx context.Context, storage logical.Storage, serverName string, lt *provider.LogoutToken) error {
+	var revocations []*persistence.RevocationEntry
+
+	err := b.data.AuthCode.Manager(storage).ForEachAuthCodeKey(ctx, func(keyer persistence.AuthCodeKeyer) error {
+		return b.data.AuthCode.WithLock(keyer, func(ch *persistence.LockedAuthCodeHolder) error {
+			cm := ch.Manager(storage)
+
+			entry, err := cm.ReadAuthCodeEntry(ctx)
+			if err != nil || entry == nil || entry.AuthServerName != serverName || !logoutTokenMatches(lt, entry.Token) {
+				return err
+			}
+
+			revocations = append(revocations, credentialRevocations(entry.AuthServerName, entry.Token, entry.ExchangedTokens)...)
+
+			entry.Token = nil
+			entry.ExchangedTokens = nil
+			entry.SetUserError(ctx, backchannelLogoutUserError)
+
+			return cm.WriteAuthCodeEntry(ctx, entry)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	b.revokeTokens(ctx, storage, revocations)
+	return nil
+}
+
+func (b *backend) backchannelLogoutUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	serverName := data.Get("server").(string)
+
+	token, ok := data.GetOk("logout_token")
+	if !ok {
+		return backchannelLogoutResponse(http.StatusBadRequest, &interop.JSONError{
+			Error:            "invalid_request",
+			ErrorDescription: "missing logout_token",
+		})
+	}
+
+	ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(serverName), defaultExpiryDelta)
+	if errmark.MarkedUser(err) {
+		return backchannelLogoutResponse(http.StatusBadRequest, &interop.JSONError{
+			Error:            "invalid_request",
+			ErrorDescription: errmark.MarkShort(err).Error(),
+		})
+	} else if err != nil {
+		return nil, err
+	}
+	defer put()
+
+	lt, ok, err := ops.VerifyLogoutToken(ctx, token.(string))
+	if errmark.MarkedUser(err) {
+		return backchannelLogoutResponse(http.StatusBadRequest, &interop.JSONError{
+			Error:            "invalid_request",
+			ErrorDescription: errmark.MarkShort(err).Error(),
+		})
+	} else if err != nil {
+		return nil, err
+	} else if !ok {
+		return backchannelLogoutResponse(http.StatusBadRequest, &interop.JSONError{
+			Error:            "invalid_request",
+			ErrorDescription: "server does not support back-channel logout",
+		})
+	}
+
+	err = b.useLogoutToken(ctx, req.Storage, serverName, lt, func() error {
+		return b.endBackchannelLogoutSessions(ctx, req.Storage, serverName, lt)
+	})
+	if errmark.MarkedUser(err) {
+		return backchannelLogoutResponse(http.StatusBadRequest, &interop.JSONError{
+			Error:            "invalid_request",
+			ErrorDescription: errmark.MarkShort(err).Error(),
+		})
+	} else if err != nil {
+		return nil, err
+	}
+
+	return backchannelLogoutResponse(http.StatusOK, nil)
+}
+
+const (
+	BackchannelLogoutPathPrefix = "backchannel-logout/"
+)
+
+var backchannelLogoutFields = map[string]*framework.FieldSchema{
+	"server": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the server.",
+	},
+	"logout_token": {
+		Type:        framework.TypeString,
+		Description: "The logout token issued by the authorization server.",
+	},
+}
+
+const backchannelLogoutHelpSynopsis = `
+Receives OpenID Connect back-channel logout requests.
+`
+
+const backchannelLogoutHelpDescription = `
+This endpoint can be registered as the back-channel logout URI of
+a client with an OpenID Connect provider. It does not require
+authentication. When the provider sends a valid logout token, every
+credential for the server that belongs to the end-user session it
+identifies stops returning tokens, and its tokens are revoked.
+`
+
+func pathBackchannelLogout(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: BackchannelLogoutPathPrefix + nameRegex("server") + `$`,
+		Fields:  backchannelLogoutFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.backchannelLogoutUpdateOperation,
+				Summary:  "End the sessions identified by an OpenID Connect logout token.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(backchannelLogoutHelpSynopsis),
+		HelpDescription: strings.TrimSpace(backchannelLogoutHelpDescription),
+	}
+}
@@ -0,0 +1,154 @@
+package backend_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestBackchannelLogout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	rr := &revocationRecorder{}
+
+	exchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken: "token_" + code,
+			},
+			Subject:   code,
+			SessionID: "sid_" + code,
+		}, nil
+	}
+
+	verify := func(token string) (*provider.LogoutToken, error) {
+		switch token {
+		case "valid":
+			return &provider.LogoutToken{ID: "logout-1", IssuedAt: time.Now(), SessionID: "sid_alice"}, nil
+		case "stale":
+			return &provider.LogoutToken{ID: "logout-2", IssuedAt: time.Now().Add(-time.Hour), SessionID: "sid_bob"}, nil
+		default:
+			return nil, errors.New("invalid logout token")
+		}
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+		testutil.MockWithRevoke(client, rr.Revoke),
+		testutil.MockWithVerifyLogoutToken(client.ID, verify),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write credentials for two different users.
+	for _, code := range []string{"alice", "bob"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + code,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server": "mock",
+				"code":   code,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// An invalid logout token is rejected.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.BackchannelLogoutPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"logout_token": "invalid",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+
+	// So is one that was issued too long ago.
+	req.Data["logout_token"] = "stale"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Empty(t, rr.Revoked())
+
+	// A valid logout token ends the matching session.
+	req.Data["logout_token"] = "valid"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusOK, resp.Data[logical.HTTPStatusCode])
+	assert.Equal(t, []string{"access_token:token_alice"}, rr.Revoked())
+
+	// The same logout token cannot be used again.
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Contains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "already been used")
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `alice`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	req.Path = backend.CredsPathPrefix + `bob`
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "token_bob", resp.Data["access_token"])
+}
@@ -0,0 +1,257 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const defaultCallbackSuccessTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization complete</title></head>
+<body><p>Authorization complete. You may close this window.</p></body>
+</html>
+`
+
+const defaultCallbackFailureTemplate = `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorization failed</title></head>
+<body><p>Authorization failed: {{ .Error }}{{ with .ErrorDescription }}: {{ . }}{{ end }}</p></body>
+</html>
+`
+
+// callbackPageData is the data provided to the success and failure templates
+// of the callback endpoint.
+type callbackPageData struct {
+	Server           string
+	Credential       string
+	Error            string
+	ErrorDescription string
+}
+
+func parseCallbackTemplate(text string) (*template.Template, error) {
+	return template.New("callback").Parse(text)
+}
+
+func callbackPage(text string, status int, data *callbackPageData) (*logical.Response, error) {
+	tpl, err := parseCallbackTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "text/html; charset=utf-8",
+			logical.HTTPRawBody:     buf.Bytes(),
+			logical.HTTPStatusCode:  status,
+		},
+	}, nil
+}
+
+// callbackCredentialAllowed determines whether the callback endpoint of the
+// given server may write the credential with the given name. The callback
+// endpoint does not require authentication, so this prevents a user who can
+// request authorization code URLs from replacing arbitrary credentials.
+func callbackCredentialAllowed(server *persistence.AuthServerEntry, name string) bool {
+	return server.CallbackCredentialPrefix != "" && strings.HasPrefix(name, server.CallbackCredentialPrefix)
+}
+
+// callbackErrorCode returns the OAuth 2.0 error code to report for an
+// exchange that was rejected, as opposed to one that failed because of a
+// problem with the plugin.
+func callbackErrorCode(err error) string {
+	var serr *semerr.Error
+	if errors.As(err, &serr) && serr.Code != "" {
+		return serr.Code
+	}
+
+	return "invalid_grant"
+}
+
+func (b *backend) callbackReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	serverName := data.Get("server").(string)
+
+	successTemplate, failureTemplate := defaultCallbackSuccessTemplate, defaultCallbackFailureTemplate
+
+	page := &callbackPageData{Server: serverName}
+	fail := func(errorCode, errorDescription string) (*logical.Response, error) {
+		page.Error = errorCode
+		page.ErrorDescription = errorDescription
+		return callbackPage(failureTemplate, http.StatusBadRequest, page)
+	}
+
+	// The user is looking at a browser window, so internal errors are logged
+	// and reported generically instead of being returned as they are.
+	failInternal := func(err error) (*logical.Response, error) {
+		b.Logger().Error("failed to complete authorization code flow", "server", serverName, "error", err)
+		return fail("server_error", "an internal error occurred")
+	}
+
+	server, err := b.cache.AuthServer.Get(ctx, req.Storage, persistence.AuthServerName(serverName))
+	if err != nil {
+		return failInternal(err)
+	} else if server != nil {
+		defer server.Put()
+
+		if server.CallbackSuccessTemplate != "" {
+			successTemplate = server.CallbackSuccessTemplate
+		}
+		if server.CallbackFailureTemplate != "" {
+			failureTemplate = server.CallbackFailureTemplate
+		}
+	}
+
+	if server == nil {
+		return fail("invalid_request", "unknown server")
+	}
+
+	state, ok := data.GetOk("state")
+	if !ok {
+		return fail("invalid_request", "missing state")
+	}
+
+	code, hasCode := data.GetOk("code")
+	errorCode, hasError := data.GetOk("error")
+
+	keyer := persistence.AuthCodeSessionState(state.(string))
+
+	var session *persistence.AuthCodeSessionEntry
+	err = b.data.AuthCodeSession.WithLock(keyer, func(ch *persistence.LockedAuthCodeSessionHolder) error {
+		cm := ch.Manager(req.Storage)
+
+		entry, err := cm.ReadAuthCodeSessionEntry(ctx)
+		if err != nil || entry == nil || entry.Expired(ctx) || entry.AuthServerName != serverName || entry.Error != "" {
+			return err
+		}
+
+		session = entry
+		page.Credential = entry.CredentialName
+
+		// If the authorization server reports an error, keep it with the
+		// session so that the application can retrieve it by exchanging the
+		// state.
+		if hasError {
+			entry.Error = errorCode.(string)
+			entry.ErrorDescription = data.Get("error_description").(string)
+			return cm.WriteAuthCodeSessionEntry(ctx, entry)
+		}
+
+		// Without a credential name, the application is expected to exchange
+		// the code itself, so the session is left alone.
+		if !hasCode || entry.CredentialName == "" || !callbackCredentialAllowed(server.AuthServerEntry, entry.CredentialName) {
+			return nil
+		}
+
+		err = b.exchangeAuthCode(
+			ctx,
+			req.Storage,
+			persistence.AuthCodeName(entry.CredentialName),
+			&persistence.AuthCodeEntry{
+				AuthServerName:       serverName,
+				MaximumExpirySeconds: entry.MaximumExpirySeconds,
+			},
+			code.(string),
+			authCodeSessionExchangeOptions(entry)...,
+		)
+		if err != nil {
+			return err
+		}
+
+		return cm.DeleteAuthCodeSessionEntry(ctx)
+	})
+	switch {
+	case errmark.MarkedUser(err):
+		return fail(callbackErrorCode(err), errmark.MarkShort(err).Error())
+	case err != nil:
+		return failInternal(err)
+	case session == nil:
+		return fail("invalid_request", "state does not match any pending authorization request")
+	case session.Error != "":
+		return fail(session.Error, session.ErrorDescription)
+	case session.CredentialName == "":
+		return fail("invalid_request", "authorization request does not specify a credential name")
+	case !callbackCredentialAllowed(server.AuthServerEntry, session.CredentialName):
+		return fail("invalid_request", "authorization request specifies a credential that cannot be written by this endpoint")
+	case !hasCode:
+		return fail("invalid_request", "missing code")
+	}
+
+	return callbackPage(successTemplate, http.StatusOK, page)
+}
+
+const (
+	CallbackPathPrefix = "callback/"
+)
+
+var callbackFields = map[string]*framework.FieldSchema{
+	"server": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the server.",
+	},
+	"code": {
+		Type:        framework.TypeString,
+		Description: "The authorization code issued by the authorization server.",
+		Query:       true,
+	},
+	"state": {
+		Type:        framework.TypeString,
+		Description: "The state of the authorization request.",
+		Query:       true,
+	},
+	"error": {
+		Type:        framework.TypeString,
+		Description: "The error code returned by the authorization server.",
+		Query:       true,
+	},
+	"error_description": {
+		Type:        framework.TypeString,
+		Description: "The description of the error returned by the authorization server.",
+		Query:       true,
+	},
+}
+
+const callbackHelpSynopsis = `
+Receives authorization responses from an authorization server.
+`
+
+const callbackHelpDescription = `
+This endpoint can be used as the redirect URL of an authorization
+code URL. It does not require authentication. When the authorization
+server redirects to it, the code is exchanged using the parameters
+of the authorization request identified by the state and written to
+the credential named by that request, which must start with the
+callback_credential_prefix of the server. An HTML page indicating the
+result is returned to the user.
+`
+
+func pathCallback(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: CallbackPathPrefix + nameRegex("server") + `$`,
+		Fields:  callbackFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.callbackReadOperation,
+				Summary:  "Complete an authorization code flow.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(callbackHelpSynopsis),
+		HelpDescription: strings.TrimSpace(callbackHelpDescription),
+	}
+}
@@ -0,0 +1,189 @@
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"123456": testutil.RandomMockAuthCodeExchange,
+		})),
+	))
+
+	storage := &failingPutStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":                  client.ID,
+			"client_secret":              client.Secret,
+			"provider":                   "mock",
+			"callback_success_template":  `<p>{{ .Credential }} is ready</p>`,
+			"callback_credential_prefix": "web-",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	authCodeURLRequest := func(name string) *logical.Request {
+		return &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.AuthCodeURLPath,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server":                 "mock",
+				"credential_name":        name,
+				"maximum_expiry_seconds": "10m",
+			},
+		}
+	}
+
+	authCodeURL := func(name string) string {
+		resp, err := b.HandleRequest(ctx, authCodeURLRequest(name))
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+		return resp.Data["state"].(string)
+	}
+
+	callback := func(data map[string]interface{}) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.CallbackPathPrefix + `mock`,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+
+		return resp
+	}
+
+	// Credentials outside of the configured prefix cannot be written by the
+	// callback endpoint.
+	resp, err = b.HandleRequest(ctx, authCodeURLRequest("test"))
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// A redirect without a code does not use up the authorization request.
+	state := authCodeURL("web-test")
+
+	resp = callback(map[string]interface{}{
+		"state": state,
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Contains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "missing code")
+
+	// Neither does a code that cannot be exchanged. The rejection is reported
+	// as such rather than as a problem with the plugin.
+	resp = callback(map[string]interface{}{
+		"code":  "654321",
+		"state": state,
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Contains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "Authorization failed: unauthorized_client")
+	assert.NotContains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "server_error")
+
+	// Internal errors are reported without details and do not use up the
+	// authorization request either.
+	storage.fail = true
+
+	resp = callback(map[string]interface{}{
+		"code":  "123456",
+		"state": state,
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Contains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "server_error: an internal error occurred")
+	assert.NotContains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "storage unavailable")
+
+	storage.fail = false
+
+	// Successful redirect.
+	resp = callback(map[string]interface{}{
+		"code":  "123456",
+		"state": state,
+	})
+	assert.Equal(t, http.StatusOK, resp.Data[logical.HTTPStatusCode])
+	assert.Equal(t, "<p>web-test is ready</p>", string(resp.Data[logical.HTTPRawBody].([]byte)))
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `web-test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotEmpty(t, resp.Data["access_token"])
+	assert.Equal(t, 600, resp.Data["maximum_expiry_seconds"])
+
+	// Replaying the redirect fails.
+	resp = callback(map[string]interface{}{
+		"code":  "123456",
+		"state": state,
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+
+	// Error reported by the authorization server.
+	state = authCodeURL("web-denied")
+
+	resp = callback(map[string]interface{}{
+		"state":             state,
+		"error":             "access_denied",
+		"error_description": "User <declined>",
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.Data[logical.HTTPStatusCode])
+	assert.Contains(t, string(resp.Data[logical.HTTPRawBody].([]byte)), "access_denied: User &lt;declined&gt;")
+
+	// The error is available to the application using the state.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `web-denied`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"code":  "123456",
+			"state": state,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.EqualError(t, resp.Error(), "authorization request failed: access_denied: User <declined>")
+}
@@ -6,10 +6,13 @@ package backend
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/assertion"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
@@ -38,6 +41,11 @@ var credUpdateGrantHandlers = map[string]func(b *backend) framework.OperationFun
 	"authorization_code": func(b *backend) framework.OperationFunc { return b.credsUpdateAuthorizationCodeOperation },
 	"refresh_token":      func(b *backend) framework.OperationFunc { return b.credsUpdateRefreshTokenOperation },
 	devicecode.GrantType: func(b *backend) framework.OperationFunc { return b.credsUpdateDeviceCodeOperation },
+	"password":           func(b *backend) framework.OperationFunc { return b.credsUpdatePasswordOperation },
+	ciba.GrantType:       func(b *backend) framework.OperationFunc { return b.credsUpdateBackchannelAuthOperation },
+
+	assertion.GrantTypeJWTBearer:   func(b *backend) framework.OperationFunc { return b.credsUpdateJWTBearerOperation },
+	assertion.GrantTypeSAML2Bearer: func(b *backend) framework.OperationFunc { return b.credsUpdateSAML2BearerOperation },
 }
 
 // credGrantTypes returns the list of supported grant types for credentials for
@@ -103,6 +111,13 @@ func (b *backend) credsReadOperation(ctx context.Context, req *logical.Request,
 		rd["provider_options"] = entry.ProviderOptions
 	}
 
+	proof, err := b.dpopProof(ctx, data, entry.DPoPKey, entry.AccessToken)
+	if err != nil {
+		return errorResponse(err)
+	} else if proof != "" {
+		rd["dpop_proof"] = proof
+	}
+
 	resp := &logical.Response{
 		Data: rd,
 	}
@@ -123,17 +138,6 @@ func (b *backend) credsReadOperation(ctx context.Context, req *logical.Request,
 func (b *backend) credsUpdateAuthorizationCodeOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	ctx = clockctx.WithClock(ctx, b.clock)
 
-	serverName, err := b.getServerNameOrDefault(ctx, req.Storage, data.Get("server").(string))
-	if err != nil {
-		return errorResponse(err)
-	}
-
-	ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(serverName), defaultExpiryDelta)
-	if err != nil {
-		return errorResponse(fmt.Errorf("server %q has configuration problems: %w", serverName, err))
-	}
-	defer put()
-
 	code, ok := data.GetOk("code")
 	if !ok {
 		return logical.ErrorResponse("missing code"), nil
@@ -142,26 +146,83 @@ func (b *backend) credsUpdateAuthorizationCodeOperation(ctx context.Context, req
 		return logical.ErrorResponse("cannot use refresh_token with authorization_code grant type"), nil
 	}
 
-	tok, err := ops.AuthCodeExchange(
-		ctx,
-		code.(string),
-		provider.WithRedirectURL(data.Get("redirect_url").(string)),
+	// If the caller provides the state returned by the authorization code URL,
+	// the session recorded for it supplies the remaining exchange parameters.
+	state := data.Get("state").(string)
+
+	var session *persistence.AuthCodeSessionEntry
+	if state != "" {
+		var err error
+		session, err = b.getAuthCodeSession(ctx, req.Storage, state)
+		if err != nil {
+			return nil, err
+		} else if session == nil {
+			return errorResponse(errmark.MarkUser(ErrNoAuthCodeSession))
+		} else if session.Error != "" {
+			// The authorization request can never succeed, so we discard it.
+			if err := b.data.AuthCodeSession.Manager(req.Storage).DeleteAuthCodeSessionEntry(ctx, persistence.AuthCodeSessionState(state)); err != nil {
+				return nil, err
+			}
+
+			return logical.ErrorResponse("authorization request failed: %s", authCodeSessionErrorMessage(session)), nil
+		}
+	}
+
+	serverName := data.Get("server").(string)
+	if session != nil {
+		if serverName == "" {
+			serverName = session.AuthServerName
+		} else if serverName != session.AuthServerName {
+			return logical.ErrorResponse("server does not match the server of the authorization request"), nil
+		}
+	}
+
+	serverName, err := b.getServerNameOrDefault(ctx, req.Storage, serverName)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	redirectURL := data.Get("redirect_url").(string)
+
+	var opts []provider.AuthCodeExchangeOption
+	if session != nil {
+		if redirectURL == "" {
+			redirectURL = session.RedirectURL
+		} else if redirectURL != session.RedirectURL {
+			return logical.ErrorResponse("redirect_url does not match the redirect URL of the authorization request"), nil
+		}
+
+		opts = append(opts, authCodeSessionExchangeOptions(session)...)
+	}
+
+	opts = append(
+		opts,
+		provider.WithRedirectURL(redirectURL),
 		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
 	)
-	if errmark.MarkedUser(err) {
-		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "exchange failed").Error()), nil
-	} else if err != nil {
-		return nil, err
-	}
 
 	entry := &persistence.AuthCodeEntry{
 		AuthServerName:       serverName,
 		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
 	}
-	entry.SetToken(ctx, tok)
 
-	if err := b.data.AuthCode.Manager(req.Storage).WriteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
-		return nil, err
+	exchange := func() error {
+		return b.exchangeAuthCode(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string)), entry, code.(string), opts...)
+	}
+
+	if session != nil {
+		err = b.useAuthCodeSession(ctx, req.Storage, state, func(session *persistence.AuthCodeSessionEntry) error {
+			if session.Error != "" {
+				return errmark.MarkUser(fmt.Errorf("authorization request failed: %s", authCodeSessionErrorMessage(session)))
+			}
+
+			return exchange()
+		})
+	} else {
+		err = exchange()
+	}
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	return nil, nil
@@ -189,6 +250,16 @@ func (b *backend) credsUpdateRefreshTokenOperation(ctx context.Context, req *log
 		return logical.ErrorResponse("cannot use code with refresh_token grant type"), nil
 	}
 
+	entry := &persistence.AuthCodeEntry{
+		AuthServerName:       serverName,
+		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
+	}
+
+	ctx, err = ops.withDPoPKey(ctx, &entry.DPoPKey)
+	if err != nil {
+		return nil, err
+	}
+
 	tok := &provider.Token{
 		Token: &oauth2.Token{
 			RefreshToken: refreshToken.(string),
@@ -201,13 +272,9 @@ func (b *backend) credsUpdateRefreshTokenOperation(ctx context.Context, req *log
 		return nil, err
 	}
 
-	entry := &persistence.AuthCodeEntry{
-		AuthServerName:       serverName,
-		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
-	}
 	entry.SetToken(ctx, tok)
 
-	if err := b.data.AuthCode.Manager(req.Storage).WriteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
 		return nil, err
 	}
 
@@ -239,11 +306,15 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 	// start there.
 	interval := 5 * time.Second
 
+	now := b.clock.Now()
+
+	// If we request the device code ourselves, we keep the authorization
+	// details so they can be shown again later.
+	var auth *devicecode.Auth
+
 	deviceCode, ok := data.GetOk("device_code")
 	if !ok {
-		now := b.clock.Now()
-
-		auth, ok, err := ops.DeviceCodeAuth(
+		auth, ok, err = ops.DeviceCodeAuth(
 			ctx,
 			provider.WithScopes(data.Get("scopes").([]string)),
 			provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
@@ -282,22 +353,36 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 		Interval:        int32(interval.Round(time.Second) / time.Second),
 		ProviderOptions: data.Get("provider_options").(map[string]string),
 	}
+	if auth != nil {
+		dae.UserCode = auth.UserCode
+		dae.VerificationURI = auth.VerificationURI
+		dae.VerificationURIComplete = auth.VerificationURIComplete
+		if auth.ExpiresIn > 0 {
+			dae.ExpireTime = now.Add(time.Duration(auth.ExpiresIn) * time.Second)
+		}
+	}
 	ace := &persistence.AuthCodeEntry{
 		AuthServerName:       serverName,
 		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
 	}
 
+	exchangeCtx, err := ops.withDPoPKey(ctx, &ace.DPoPKey)
+	if err != nil {
+		return nil, err
+	}
+
 	// If we get this far, we're guaranteed to have a device code. We'll do
 	// one request to make sure that it's not completely broken. Then we'll
 	// submit it to be polled.
-	dae, ace, err = deviceAuthExchange(ctx, ops, dae, ace)
+	dae, ace, err = deviceAuthExchange(exchangeCtx, ops, dae, ace)
 	if err != nil {
 		return nil, err
 	} else if ace.UserError != "" {
 		return logical.ErrorResponse(ace.UserError), nil
 	}
 
-	err = b.data.AuthCode.WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(ach *persistence.LockedAuthCodeHolder) error {
+	var revocations []*persistence.RevocationEntry
+	err = b.data.AuthCode.WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(ach *persistence.LockedAuthCodeHolder) (err error) {
 		acm := ach.Manager(req.Storage)
 
 		if !ace.TokenIssued() {
@@ -313,17 +398,221 @@ func (b *backend) credsUpdateDeviceCodeOperation(ctx context.Context, req *logic
 			}
 		}
 
-		if err := acm.WriteAuthCodeEntry(ctx, ace); err != nil {
+		revocations, err = writeAuthCodeEntryRevocations(ctx, acm, ace)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.revokeTokens(ctx, req.Storage, revocations)
+
+	return resp, nil
+}
+
+func (b *backend) credsUpdateBackchannelAuthOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	loginHint, ok := data.GetOk("login_hint")
+	if !ok {
+		return logical.ErrorResponse("missing login hint"), nil
+	}
+
+	ops, ace, put, err := b.credsServerOperations(ctx, req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer put()
+
+	now := b.clock.Now()
+
+	auth, ok, err := ops.BackchannelAuth(
+		ctx,
+		provider.WithLoginHint(loginHint.(string)),
+		provider.WithBindingMessage(data.Get("binding_message").(string)),
+		provider.WithScopes(data.Get("scopes").([]string)),
+		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+	)
+	if errmark.MarkedUser(err) {
+		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "backchannel authentication request failed").Error()), nil
+	} else if err != nil {
+		return nil, err
+	} else if !ok {
+		return logical.ErrorResponse("backchannel authentication URL not available"), nil
+	}
+
+	// CIBA Core 1.0 § 7.3 provides for a default polling interval of 5
+	// seconds.
+	interval := int32(5)
+	if auth.Interval > 0 {
+		interval = auth.Interval
+	}
+
+	// The end-user has not had a chance to approve the request yet, so we
+	// wait for the poller to make the first exchange.
+	bae := &persistence.BackchannelAuthEntry{
+		AuthReqID:              auth.AuthReqID,
+		Interval:               interval,
+		LastAttemptedIssueTime: now,
+		ProviderOptions:        data.Get("provider_options").(map[string]string),
+		ExpireTime:             now.Add(time.Duration(auth.ExpiresIn) * time.Second),
+	}
+
+	if _, err := ops.withDPoPKey(ctx, &ace.DPoPKey); err != nil {
+		return nil, err
+	}
+
+	var revocations []*persistence.RevocationEntry
+	err = b.data.AuthCode.WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(ach *persistence.LockedAuthCodeHolder) (err error) {
+		acm := ach.Manager(req.Storage)
+
+		if err := acm.WriteBackchannelAuthEntry(ctx, bae); err != nil {
 			return err
 		}
 
-		return nil
+		revocations, err = writeAuthCodeEntryRevocations(ctx, acm, ace)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return resp, nil
+	b.revokeTokens(ctx, req.Storage, revocations)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"expire_time": bae.ExpireTime,
+		},
+	}, nil
+}
+
+func (b *backend) credsUpdateJWTBearerOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	return b.credsUpdateTokenGrant(ctx, req, data, "JWT bearer grant", func(ctx context.Context, ops *providerOperations, entry *persistence.AuthCodeEntry) (*provider.Token, error) {
+		entry.JWTBearer = &persistence.JWTBearerConfig{
+			Issuer:   data.Get("assertion_issuer").(string),
+			Subject:  data.Get("assertion_subject").(string),
+			Audience: data.Get("assertion_audience").(string),
+			Claims:   data.Get("assertion_claims").(map[string]interface{}),
+			Scopes:   data.Get("scopes").([]string),
+		}
+
+		return ops.JWTBearer(ctx, jwtBearerOptions(entry.JWTBearer, data.Get("provider_options").(map[string]string))...)
+	})
+}
+
+func (b *backend) credsUpdateSAML2BearerOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	a, ok := data.GetOk("assertion")
+	if !ok {
+		return logical.ErrorResponse("missing assertion"), nil
+	} else if _, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(a.(string), "=")); err != nil {
+		return logical.ErrorResponse("assertion must be base64url-encoded"), nil
+	}
+
+	return b.credsUpdateTokenGrant(ctx, req, data, "SAML 2.0 bearer grant", func(ctx context.Context, ops *providerOperations, _ *persistence.AuthCodeEntry) (*provider.Token, error) {
+		return ops.SAML2Bearer(
+			ctx,
+			a.(string),
+			provider.WithScopes(data.Get("scopes").([]string)),
+			provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+		)
+	})
+}
+
+func (b *backend) credsUpdatePasswordOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	username, ok := data.GetOk("username")
+	if !ok {
+		return logical.ErrorResponse("missing username"), nil
+	}
+	password, ok := data.GetOk("password")
+	if !ok {
+		return logical.ErrorResponse("missing password"), nil
+	}
+
+	// The password is only used for this exchange and is never stored.
+	var tok *provider.Token
+	resp, err := b.credsUpdateTokenGrant(ctx, req, data, "password grant", func(ctx context.Context, ops *providerOperations, _ *persistence.AuthCodeEntry) (*provider.Token, error) {
+		var err error
+		tok, err = ops.PasswordCredentials(
+			ctx,
+			username.(string),
+			password.(string),
+			provider.WithScopes(data.Get("scopes").([]string)),
+			provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+		)
+		return tok, err
+	})
+	if resp != nil || err != nil {
+		return resp, err
+	}
+
+	if tok.RefreshToken == "" {
+		resp := &logical.Response{}
+		resp.AddWarning("server did not issue a refresh token, so the password must be provided again when this token expires")
+		return resp, nil
+	}
+
+	return nil, nil
+}
+
+// credsServerOperations returns the provider operations for the server named
+// in the request and a new credential for that server. The returned function
+// releases the operations.
+func (b *backend) credsServerOperations(ctx context.Context, req *logical.Request, data *framework.FieldData) (*providerOperations, *persistence.AuthCodeEntry, func(), error) {
+	serverName, err := b.getServerNameOrDefault(ctx, req.Storage, data.Get("server").(string))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(serverName), defaultExpiryDelta)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("server %q has configuration problems: %w", serverName, err)
+	}
+
+	entry := &persistence.AuthCodeEntry{
+		AuthServerName:       serverName,
+		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
+	}
+
+	return ops, entry, put, nil
+}
+
+// credsUpdateTokenGrant writes a new credential for the server named in the
+// request using a token issued by the given grant, replacing any existing
+// credential with the same name. The grant may add its own configuration to
+// the credential. Errors from the authorization server are reported as a
+// failure of the named grant.
+func (b *backend) credsUpdateTokenGrant(ctx context.Context, req *logical.Request, data *framework.FieldData, grantName string, grant func(ctx context.Context, ops *providerOperations, entry *persistence.AuthCodeEntry) (*provider.Token, error)) (*logical.Response, error) {
+	ops, entry, put, err := b.credsServerOperations(ctx, req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+	defer put()
+
+	ctx, err = ops.withDPoPKey(ctx, &entry.DPoPKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := grant(ctx, ops, entry)
+	if errmark.MarkedUser(err) {
+		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), grantName+" failed").Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	entry.SetToken(ctx, tok)
+
+	if err := b.replaceAuthCodeEntry(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string)), entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
 }
 
 func (b *backend) credsUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -336,7 +625,7 @@ func (b *backend) credsUpdateOperation(ctx context.Context, req *logical.Request
 }
 
 func (b *backend) credsDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	if err := b.data.AuthCode.Manager(req.Storage).DeleteAuthCodeEntry(ctx, persistence.AuthCodeName(data.Get("name").(string))); err != nil {
+	if err := b.deleteAuthCodeEntry(ctx, req.Storage, persistence.AuthCodeName(data.Get("name").(string))); err != nil {
 		return nil, err
 	}
 
@@ -360,6 +649,22 @@ var credsFields = map[string]*framework.FieldSchema{
 		Default:     0,
 		Query:       true,
 	},
+	"htm": {
+		Type:        framework.TypeString,
+		Description: "The HTTP method of the request to generate a DPoP proof for.",
+		Default:     "GET",
+		Query:       true,
+	},
+	"htu": {
+		Type:        framework.TypeString,
+		Description: "The URL of the request to generate a DPoP proof for. If specified, a proof for the access token is returned in the dpop_proof field.",
+		Query:       true,
+	},
+	"dpop_nonce": {
+		Type:        framework.TypeString,
+		Description: "A nonce provided by the resource server to include in the DPoP proof.",
+		Query:       true,
+	},
 	// fields for write operation
 	"server": {
 		Type:        framework.TypeString,
@@ -378,6 +683,10 @@ var credsFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeString,
 		Description: "Specifies the response code to exchange for a full token.",
 	},
+	"state": {
+		Type:        framework.TypeString,
+		Description: "Specifies the state returned with the response code. If provided, it must match an authorization code URL generated by this plugin.",
+	},
 	"redirect_url": {
 		Type:        framework.TypeString,
 		Description: "Specifies the redirect URL to provide when exchanging (required by some services and must be equivalent to the redirect URL provided to the authorization code URL).",
@@ -392,7 +701,43 @@ var credsFields = map[string]*framework.FieldSchema{
 	},
 	"scopes": {
 		Type:        framework.TypeCommaStringSlice,
-		Description: "Specifies the scopes to provide for a device code authorization request.",
+		Description: "Specifies the scopes to provide for a device code authorization, backchannel authentication, assertion, or password grant request.",
+	},
+	"login_hint": {
+		Type:        framework.TypeString,
+		Description: "Specifies the end-user to authenticate for a backchannel authentication request.",
+	},
+	"binding_message": {
+		Type:        framework.TypeString,
+		Description: "Specifies a short message to display to the end-user for a backchannel authentication request.",
+	},
+	"username": {
+		Type:        framework.TypeString,
+		Description: "Specifies the resource owner username for a password grant request.",
+	},
+	"password": {
+		Type:        framework.TypeString,
+		Description: "Specifies the resource owner password for a password grant request. It is not stored.",
+	},
+	"assertion": {
+		Type:        framework.TypeString,
+		Description: "Specifies the base64url-encoded SAML 2.0 assertion to exchange for a token.",
+	},
+	"assertion_issuer": {
+		Type:        framework.TypeString,
+		Description: "Specifies the issuer of the JWT bearer assertion. Defaults to the client ID.",
+	},
+	"assertion_subject": {
+		Type:        framework.TypeString,
+		Description: "Specifies the subject of the JWT bearer assertion. Defaults to the client ID.",
+	},
+	"assertion_audience": {
+		Type:        framework.TypeString,
+		Description: "Specifies the audience of the JWT bearer assertion. Defaults to the token endpoint URL.",
+	},
+	"assertion_claims": {
+		Type:        framework.TypeMap,
+		Description: "Specifies additional claims to include in the JWT bearer assertion.",
 	},
 	"provider_options": {
 		Type:        framework.TypeKVPairs,
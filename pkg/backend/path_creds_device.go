@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"context"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+func (b *backend) credsDeviceReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.AuthCode.Manager(req.Storage).ReadDeviceAuthEntry(ctx, persistence.AuthCodeName(data.Get("name").(string)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	rd := map[string]interface{}{
+		"interval": entry.Interval,
+	}
+	if entry.UserCode != "" {
+		rd["user_code"] = entry.UserCode
+	}
+	if entry.VerificationURI != "" {
+		rd["verification_uri"] = entry.VerificationURI
+	}
+	if entry.VerificationURIComplete != "" {
+		rd["verification_uri_complete"] = entry.VerificationURIComplete
+	}
+	if !entry.ExpireTime.IsZero() {
+		rd["expire_time"] = entry.ExpireTime
+	}
+	if !entry.LastAttemptedIssueTime.IsZero() {
+		rd["last_poll_time"] = entry.LastAttemptedIssueTime
+	}
+	if entry.LastPollResult != "" {
+		rd["last_poll_result"] = entry.LastPollResult
+	}
+
+	return &logical.Response{Data: rd}, nil
+}
+
+func (b *backend) credsDeviceDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	err := b.data.AuthCode.WithLock(persistence.AuthCodeName(data.Get("name").(string)), func(ch *persistence.LockedAuthCodeHolder) error {
+		cm := ch.Manager(req.Storage)
+
+		auth, err := cm.ReadDeviceAuthEntry(ctx)
+		if err != nil || auth == nil {
+			return err
+		}
+
+		if err := cm.DeleteDeviceAuthEntry(ctx); err != nil {
+			return err
+		}
+
+		// Make sure the credential reports why it will never be issued
+		// instead of remaining pending forever.
+		ct, err := cm.ReadAuthCodeEntry(ctx)
+		if err != nil || ct == nil || ct.TokenIssued() || ct.UserError != "" {
+			return err
+		}
+
+		ct.SetUserError(ctx, deviceCodeCanceledUserError)
+		return cm.WriteAuthCodeEntry(ctx, ct)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+var credsDeviceFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+}
+
+const credsDeviceHelpSynopsis = `
+Manages pending device code authorizations for credentials.
+`
+
+const credsDeviceHelpDescription = `
+This endpoint reports the status of a credential created using the
+device code flow that is waiting for the end-user to authorize it,
+including the user code and verification URI to show to the user
+again. Deleting it stops polling the authorization server; the
+credential then reports that the authorization was canceled.
+`
+
+func pathCredsDevice(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: CredsPathPrefix + nameRegex("name") + `:/device$`,
+		Fields:  credsDeviceFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.credsDeviceReadOperation,
+				Summary:  "Get the status of a pending device code authorization.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.credsDeviceDeleteOperation,
+				Summary:  "Cancel a pending device code authorization.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(credsDeviceHelpSynopsis),
+		HelpDescription: strings.TrimSpace(credsDeviceHelpDescription),
+	}
+}
@@ -0,0 +1,357 @@
+package backend_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+func TestDeviceCodeStatusAndCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{ID: "abc"}
+
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:              "xyz123",
+		UserCode:                "ABCD-1234",
+		VerificationURI:         "http://localhost/verify",
+		VerificationURIComplete: "http://localhost/verify?user_code=ABCD-1234",
+		ExpiresIn:               300,
+		Interval:                5,
+	})
+
+	var exchanges int32
+	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		atomic.AddInt32(&exchanges, 1)
+		return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": client.ID,
+			"provider":  "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	for _, name := range []string{"canceled", "expired"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server":     "mock",
+				"grant_type": devicecode.GrantType,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&exchanges))
+
+	// The authorization details should be available again.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `canceled:/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "ABCD-1234", resp.Data["user_code"])
+	assert.Equal(t, "http://localhost/verify", resp.Data["verification_uri"])
+	assert.Equal(t, "http://localhost/verify?user_code=ABCD-1234", resp.Data["verification_uri_complete"])
+	assert.WithinDuration(t, clk.Now().Add(300*time.Second), resp.Data["expire_time"].(time.Time), 0)
+	assert.Equal(t, int32(5), resp.Data["interval"])
+	assert.Contains(t, resp.Data["last_poll_result"], "authorization_pending")
+
+	// Cancel the first authorization.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + `canceled:/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `canceled:/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `canceled`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "device authorization canceled")
+
+	// Let the second authorization expire. The poller should give up without
+	// contacting the authorization server again.
+	clk.Step(301 * time.Second)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `expired`,
+		Storage:   storage,
+	}
+
+	for {
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		if resp.Error().Error() != "token pending issuance" {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for device code expiry")
+		case <-time.After(10 * time.Millisecond):
+			clk.Step(time.Second)
+		}
+	}
+	require.EqualError(t, resp.Error(), "device code expired before authorization completed")
+	require.Equal(t, int32(2), atomic.LoadInt32(&exchanges))
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `expired:/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	// Credentials whose names end in /device are not confused with the
+	// device authorization of another credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `x/device`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":     "mock",
+			"grant_type": devicecode.GrantType,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `x/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "token pending issuance")
+
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + `x/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `x/device`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req.Path = backend.CredsPathPrefix + `x:/device`
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
+
+func TestDeviceCodeReplacedCredential(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:      "xyz123",
+		UserCode:        "ABCD-1234",
+		VerificationURI: "http://localhost/verify",
+		ExpiresIn:       300,
+		Interval:        5,
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, testutil.AuthorizationPendingErrorMockDeviceCodeExchange),
+		testutil.MockWithAuthCodeExchange(client, testutil.RandomMockAuthCodeExchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Start a device code authorization.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":     "mock",
+			"grant_type": devicecode.GrantType,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Replace the credential while the authorization is still pending.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "123456",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// The poller should only clean up the orphaned authorization.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test/device`,
+		Storage:   storage,
+	}
+
+	for {
+		clk.Step(5 * time.Second)
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		if resp == nil {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for device authorization to be removed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.NotEmpty(t, resp.Data["access_token"])
+}
@@ -2,20 +2,35 @@ package backend_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"math"
+	"net/http"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
 	"github.com/openbao/openbao/sdk/v2/logical"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
 	testclock "k8s.io/utils/clock/testing"
 )
 
@@ -92,7 +107,7 @@ func TestBasicAuthCodeExchange(t *testing.T) {
 	require.Empty(t, resp.Data["expire_time"])
 }
 
-func TestClientSecretsFallback(t *testing.T) {
+func TestDPoPAuthCodeExchange(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -104,6 +119,7 @@ func TestClientSecretsFallback(t *testing.T) {
 	token := &provider.Token{
 		Token: &oauth2.Token{
 			AccessToken: "valid",
+			TokenType:   dpop.TokenType,
 		},
 	}
 
@@ -122,10 +138,10 @@ func TestClientSecretsFallback(t *testing.T) {
 		Path:      backend.ServersPathPrefix + `mock`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"client_id":      client.ID,
-			"client_secret":  "hij",
-			"client_secrets": []string{"pqr", client.Secret},
-			"provider":       "mock",
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"dpop":          true,
 		},
 	}
 
@@ -149,9 +165,55 @@ func TestClientSecretsFallback(t *testing.T) {
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
 	require.Nil(t, resp)
+
+	// Read the access token along with a proof for a resource request.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"htm":        "POST",
+			"htu":        "https://api.example.com/resource?q=1",
+			"dpop_nonce": "nonce",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, token.AccessToken, resp.Data["access_token"])
+	require.Equal(t, dpop.TokenType, resp.Data["type"])
+
+	sig, err := jose.ParseSigned(resp.Data["dpop_proof"].(string))
+	require.NoError(t, err)
+	require.Len(t, sig.Signatures, 1)
+	require.Equal(t, dpop.ProofType, sig.Signatures[0].Protected.ExtraHeaders[jose.HeaderType])
+
+	payload, err := sig.Verify(sig.Signatures[0].Protected.JSONWebKey)
+	require.NoError(t, err)
+
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+
+	ath := sha256.Sum256([]byte(token.AccessToken))
+	require.Equal(t, "POST", claims["htm"])
+	require.Equal(t, "https://api.example.com/resource", claims["htu"])
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(ath[:]), claims["ath"])
+	require.Equal(t, "nonce", claims["nonce"])
+
+	// Subsequent proofs are signed by the same key.
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+
+	next, err := jose.ParseSigned(resp.Data["dpop_proof"].(string))
+	require.NoError(t, err)
+	_, err = next.Verify(sig.Signatures[0].Protected.JSONWebKey)
+	require.NoError(t, err)
 }
 
-func TestInvalidAuthCodeExchange(t *testing.T) {
+func TestJWTBearerReissue(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -160,56 +222,100 @@ func TestInvalidAuthCodeExchange(t *testing.T) {
 		Secret: "def",
 	}
 
-	exchange := testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
-		"valid": testutil.RandomMockAuthCodeExchange,
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(pk)
+	require.NoError(t, err)
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	var subjects []string
+	exchange := testutil.AmendTokenMockJWTBearer(testutil.IncrementMockJWTBearer("token_"), func(t *provider.Token) error {
+		t.Expiry = clk.Now().Add(2 * time.Minute)
+		return nil
 	})
 
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithJWTBearer(client, func(opts *provider.JWTBearerOptions) (*provider.Token, error) {
+		subjects = append(subjects, opts.Subject)
+		return exchange(opts)
+	})))
 
 	storage := &logical.InmemStorage{}
 
-	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
 	require.NoError(t, err)
 	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
 
-	// Write server configuration.
+	// Write server configuration without a key.
 	req := &logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      backend.ServersPathPrefix + `mock`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"client_id":      client.ID,
-			"client_secrets": []string{client.Secret, "totally_invalid"},
-			"provider":       "mock",
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
 		},
 	}
 
 	resp, err := b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
 
-	// Write an invalid credential.
-	req = &logical.Request{
+	credReq := &logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"server": "mock",
-			"code":   "invalid",
+			"server":            "mock",
+			"grant_type":        "urn:ietf:params:oauth:grant-type:jwt-bearer",
+			"assertion_subject": "user@example.com",
 		},
 	}
 
+	resp, err = b.HandleRequest(ctx, credReq)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+	require.Contains(t, resp.Error().Error(), backend.ErrMissingClientPrivateKey.Error())
+
+	// Add a key to the server.
+	req.Data["client_private_key"] = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.EqualError(t, resp.Error(), `provider request failed for all client secrets:
-		* exchange failed: server rejected request: unauthorized_client
-		* exchange failed: server rejected request: invalid_client`)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	resp, err = b.HandleRequest(ctx, credReq)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Read the corresponding access token.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_1", resp.Data["access_token"])
+
+	// Once the token expires, a new one is issued.
+	clk.Step(2 * time.Minute)
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
+	require.Equal(t, []string{"user@example.com", "user@example.com"}, subjects)
 }
 
-func TestRefreshableAuthCodeExchange(t *testing.T) {
+func TestSAML2BearerRefresh(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -218,32 +324,38 @@ func TestRefreshableAuthCodeExchange(t *testing.T) {
 		Secret: "def",
 	}
 
-	refresh := func(i int) (time.Duration, error) {
-		switch i {
-		case 1:
-			// Start with a short duration, which will force a refresh within
-			// the library's grace period (< 10 seconds to expiry).
-			return 2 * time.Second, nil
-		default:
-			return 10 * time.Minute, nil
-		}
+	a := base64.RawURLEncoding.EncodeToString([]byte(`<saml:Assertion/>`))
+
+	// The mock provider refreshes tokens by replaying the assertion through
+	// the authorization code exchange.
+	refresh := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, a, code)
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "token_2",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(10 * time.Minute),
+			},
+		}, nil
 	}
 
-	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
-
-	// Prepend the tenant provider option to the resulting token.
-	handler := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
-		t, err := exchange(code, opts)
-		if err != nil {
-			return nil, err
-		}
-
-		t.AccessToken = fmt.Sprintf("%s_%s", opts.ProviderOptions["tenant"], t.AccessToken)
-		return t, nil
+	exchange := func(candidate string, opts *provider.SAML2BearerOptions) (*provider.Token, error) {
+		require.Equal(t, []string{"read", "write"}, opts.Scopes)
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "token_1",
+				RefreshToken: "refresh",
+				// Force a refresh within the library's grace period.
+				Expiry: time.Now().Add(2 * time.Second),
+			},
+		}, nil
 	}
 
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, handler)))
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithSAML2Bearer(client, exchange),
+		testutil.MockWithAuthCodeExchange(client, refresh),
+	))
 
 	storage := &logical.InmemStorage{}
 
@@ -266,30 +378,32 @@ func TestRefreshableAuthCodeExchange(t *testing.T) {
 	resp, err := b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
 
-	// Write a valid credential.
+	// The assertion must be base64url-encoded.
 	req = &logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"server": "mock",
-			"code":   "test",
-			"provider_options": map[string]interface{}{
-				"tenant": "test",
-			},
+			"server":     "mock",
+			"grant_type": "urn:ietf:params:oauth:grant-type:saml2-bearer",
+			"assertion":  "<saml:Assertion/>",
+			"scopes":     "read,write",
 		},
 	}
 
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	req.Data["assertion"] = a
+
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
 
-	// Read the corresponding access token. This should force a refresh, meaning
-	// that our token value will be "token_2" (rather than the initial value of
-	// "token_1").
+	// Read the corresponding access token. This should force a refresh using
+	// the refresh token issued for the assertion.
 	req = &logical.Request{
 		Operation: logical.ReadOperation,
 		Path:      backend.CredsPathPrefix + `test`,
@@ -298,15 +412,11 @@ func TestRefreshableAuthCodeExchange(t *testing.T) {
 
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
-	require.Equal(t, "test_token_2", resp.Data["access_token"])
-	require.Equal(t, "Bearer", resp.Data["type"])
-	require.NotEmpty(t, resp.Data["expire_time"])
-	require.Equal(t, map[string]string{"tenant": "test"}, resp.Data["provider_options"])
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
 }
 
-func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
+func TestPasswordGrantRefresh(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -315,22 +425,46 @@ func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
 		Secret: "def",
 	}
 
-	refresh := func(i int) (time.Duration, error) {
-		switch i {
-		case 1:
-			// Start with a short duration, which will force a refresh within
-			// the library's grace period (< 10 seconds to expiry).
-			return 2 * time.Second, nil
-		default:
-			// Now we'll force a refresh failure.
-			return 0, fmt.Errorf("you are not welcome")
+	// The mock provider refreshes tokens by passing the username through the
+	// authorization code exchange.
+	refresh := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, "svc", code)
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "token_2",
+				RefreshToken: "refresh",
+				Expiry:       time.Now().Add(10 * time.Minute),
+			},
+		}, nil
+	}
+
+	exchange := func(username, password string, opts *provider.PasswordCredentialsOptions) (*provider.Token, error) {
+		if username != "svc" || password != "hunter2" {
+			return nil, testutil.MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: "invalid_grant"})
 		}
+
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "token_1",
+				RefreshToken: "refresh",
+				// Force a refresh within the library's grace period.
+				Expiry: time.Now().Add(2 * time.Second),
+			},
+		}, nil
 	}
 
-	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
+	// A rejected password must not be retried with another secret.
+	unusedExchange := func(username, password string, opts *provider.PasswordCredentialsOptions) (*provider.Token, error) {
+		assert.Fail(t, "unexpected exchange with unused secret")
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
 
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithPasswordCredentials(client, exchange),
+		testutil.MockWithPasswordCredentials(testutil.MockClient{ID: client.ID, Secret: "stu"}, unusedExchange),
+		testutil.MockWithAuthCodeExchange(client, refresh),
+	))
 
 	storage := &logical.InmemStorage{}
 
@@ -344,35 +478,49 @@ func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
 		Path:      backend.ServersPathPrefix + `mock`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"client_id":     client.ID,
-			"client_secret": client.Secret,
-			"provider":      "mock",
+			"client_id":      client.ID,
+			"client_secrets": []string{client.Secret, "stu"},
+			"provider":       "mock",
 		},
 	}
 
 	resp, err := b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
 
-	// Write a valid credential.
+	// Invalid credentials are reported to the user.
 	req = &logical.Request{
 		Operation: logical.UpdateOperation,
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"server": "mock",
-			"code":   "test",
+			"server":     "mock",
+			"grant_type": "password",
+			"username":   "svc",
+			"password":   "wrong",
 		},
 	}
 
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp != nil && resp.IsError())
+
+	req.Data["password"] = "hunter2"
+
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
 	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
-	require.Nil(t, resp)
 
-	// Read the corresponding access token. This should force a refresh, which
-	// should now return an invalidation from the server.
+	// The password must not be persisted anywhere.
+	keys, err := logical.CollectKeys(ctx, storage)
+	require.NoError(t, err)
+	for _, key := range keys {
+		se, err := storage.Get(ctx, key)
+		require.NoError(t, err)
+		require.NotContains(t, string(se.Value), "hunter2", "storage key %q", key)
+	}
+
+	// Read the corresponding access token. This should force a refresh.
 	req = &logical.Request{
 		Operation: logical.ReadOperation,
 		Path:      backend.CredsPathPrefix + `test`,
@@ -381,66 +529,33 @@ func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
 
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.EqualError(t, resp.Error(), "token expired")
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "token_2", resp.Data["access_token"])
 }
 
-func TestDeviceCodeAuthAndExchange(t *testing.T) {
+func TestClientSecretsFallback(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	client := testutil.MockClient{ID: "abc"}
-
-	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
-		DeviceCode:              "xyz123",
-		UserCode:                "ABCD-1234",
-		VerificationURI:         "http://localhost/verify",
-		VerificationURIComplete: "http://localhost/verify?user_code=ABCD-1234",
-		ExpiresIn:               300,
-		Interval:                5,
-	})
-
-	// This contains the state of the issuer: either 0 (pending), 1 (request to
-	// issue), or 2 (issued).
-	var issue int32
-	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
-		require.Equal(t, "xyz123", deviceCode)
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
 
-		switch {
-		case atomic.CompareAndSwapInt32(&issue, 1, 2) || atomic.LoadInt32(&issue) > 1:
-			atomic.AddInt32(&issue, 1)
-			return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
-		default:
-			return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
-		}
+	token := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken: "valid",
+		},
 	}
 
 	pr := provider.NewRegistry()
-	pr.MustRegister("mock", testutil.MockFactory(
-		testutil.MockWithDeviceCodeAuth(client, auth),
-		testutil.MockWithDeviceCodeExchange(client, exchange),
-	))
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, testutil.StaticMockAuthCodeExchange(token))))
 
 	storage := &logical.InmemStorage{}
 
-	clk := testclock.NewFakeClock(time.Now())
-
-	b, err := backend.New(backend.Options{
-		ProviderRegistry: pr,
-		Clock: clock.NewTimerCallbackClock(
-			k8sext.NewClock(clk),
-			func(d time.Duration) {
-				// Stepping the clock every time a timer is created or reset
-				// guarantees that the normally-delayed timer will immediately
-				// retry over and over.
-				clk.Step(d)
-			},
-		),
-	})
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
 	require.NoError(t, err)
 	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
-	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
-	defer b.Cleanup(ctx)
 
 	// Write server configuration.
 	req := &logical.Request{
@@ -448,8 +563,10 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 		Path:      backend.ServersPathPrefix + `mock`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"client_id": client.ID,
-			"provider":  "mock",
+			"client_id":      client.ID,
+			"client_secret":  "hij",
+			"client_secrets": []string{"pqr", client.Secret},
+			"provider":       "mock",
 		},
 	}
 
@@ -464,24 +581,680 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 		Data: map[string]interface{}{
-			"server":     "mock",
-			"grant_type": devicecode.GrantType,
-			"scopes":     []interface{}{"first", "second"},
+			"server": "mock",
+			"code":   "test",
 		},
 	}
 
 	resp, err = b.HandleRequest(ctx, req)
 	require.NoError(t, err)
-	require.NotNil(t, resp)
-	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
-	require.Equal(t, "ABCD-1234", resp.Data["user_code"])
-	require.Equal(t, "http://localhost/verify", resp.Data["verification_uri"])
-	require.Equal(t, "http://localhost/verify?user_code=ABCD-1234", resp.Data["verification_uri_complete"])
-	require.NotEmpty(t, resp.Data["expire_time"])
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+}
 
-	// Token should now be pending.
-	req = &logical.Request{
-		Operation: logical.ReadOperation,
+func TestInvalidAuthCodeExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+		"valid": testutil.RandomMockAuthCodeExchange,
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secrets": []string{client.Secret, "totally_invalid"},
+			"provider":       "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write an invalid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "invalid",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), `provider request failed for all client secrets:
+		* exchange failed: server rejected request: unauthorized_client
+		* exchange failed: server rejected request: invalid_client`)
+}
+
+func TestAuthCodeExchangeRejectedCode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		return nil, testutil.MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: "invalid_grant"})
+	}
+
+	// An authorization code can only be used once, so it must not be retried
+	// with another secret.
+	unusedExchange := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		assert.Fail(t, "unexpected exchange with unused secret")
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, exchange),
+		testutil.MockWithAuthCodeExchange(testutil.MockClient{ID: client.ID, Secret: "stu"}, unusedExchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secrets": []string{client.Secret, "stu"},
+			"provider":       "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a credential with a code the server rejects.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "used",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "exchange failed: server rejected request: invalid_grant")
+}
+
+func TestRefreshableAuthCodeExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			// Start with a short duration, which will force a refresh within
+			// the library's grace period (< 10 seconds to expiry).
+			return 2 * time.Second, nil
+		default:
+			return 10 * time.Minute, nil
+		}
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
+
+	// Prepend the tenant provider option to the resulting token.
+	handler := func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error) {
+		t, err := exchange(code, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		t.AccessToken = fmt.Sprintf("%s_%s", opts.ProviderOptions["tenant"], t.AccessToken)
+		return t, nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, handler)))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "test",
+			"provider_options": map[string]interface{}{
+				"tenant": "test",
+			},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Read the corresponding access token. This should force a refresh, meaning
+	// that our token value will be "token_2" (rather than the initial value of
+	// "token_1").
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "test_token_2", resp.Data["access_token"])
+	require.Equal(t, "Bearer", resp.Data["type"])
+	require.NotEmpty(t, resp.Data["expire_time"])
+	require.Equal(t, map[string]string{"tenant": "test"}, resp.Data["provider_options"])
+}
+
+func TestRefreshFailureReturnsNotConfigured(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	refresh := func(i int) (time.Duration, error) {
+		switch i {
+		case 1:
+			// Start with a short duration, which will force a refresh within
+			// the library's grace period (< 10 seconds to expiry).
+			return 2 * time.Second, nil
+		default:
+			// Now we'll force a refresh failure.
+			return 0, fmt.Errorf("you are not welcome")
+		}
+	}
+
+	exchange := testutil.RefreshableMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), refresh)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Read the corresponding access token. This should force a refresh, which
+	// should now return an invalidation from the server.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "token expired")
+}
+
+func TestDeviceCodeAuthAndExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{ID: "abc"}
+
+	// The clock below advances every time the poller waits, so the device code
+	// must not expire before the test completes.
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:              "xyz123",
+		UserCode:                "ABCD-1234",
+		VerificationURI:         "http://localhost/verify",
+		VerificationURIComplete: "http://localhost/verify?user_code=ABCD-1234",
+		ExpiresIn:               math.MaxInt32,
+		Interval:                5,
+	})
+
+	// This contains the state of the issuer: either 0 (pending), 1 (request to
+	// issue), or 2 (issued).
+	var issue int32
+	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, "xyz123", deviceCode)
+
+		switch {
+		case atomic.CompareAndSwapInt32(&issue, 1, 2) || atomic.LoadInt32(&issue) > 1:
+			atomic.AddInt32(&issue, 1)
+			return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
+		default:
+			return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
+		}
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				// Stepping the clock every time a timer is created or reset
+				// guarantees that the normally-delayed timer will immediately
+				// retry over and over.
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id": client.ID,
+			"provider":  "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":     "mock",
+			"grant_type": devicecode.GrantType,
+			"scopes":     []interface{}{"first", "second"},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "ABCD-1234", resp.Data["user_code"])
+	require.Equal(t, "http://localhost/verify", resp.Data["verification_uri"])
+	require.Equal(t, "http://localhost/verify?user_code=ABCD-1234", resp.Data["verification_uri_complete"])
+	require.NotEmpty(t, resp.Data["expire_time"])
+
+	// Token should now be pending.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "token pending issuance")
+
+	require.Equal(t, int32(1), atomic.AddInt32(&issue, 1))
+	for atomic.LoadInt32(&issue) == 1 {
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for token issuance")
+		default:
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "hello", resp.Data["access_token"])
+	require.Equal(t, "Bearer", resp.Data["type"])
+	require.Empty(t, resp.Data["expire_time"])
+}
+
+func TestDeviceCodeClientSecretsFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	invalidAuth := func(opts *provider.DeviceCodeAuthOptions) (*devicecode.Auth, error) {
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
+
+	auth := testutil.StaticMockDeviceCodeAuth(&devicecode.Auth{
+		DeviceCode:      "xyz123",
+		UserCode:        "ABCD-1234",
+		VerificationURI: "http://localhost/verify",
+		ExpiresIn:       300,
+		Interval:        5,
+	})
+
+	// The authorization is pending for the first few polls. A pending
+	// authorization must not be confused with the rejection of another secret.
+	var polls int32
+	exchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, "xyz123", deviceCode)
+		if atomic.AddInt32(&polls, 1) < 3 {
+			return testutil.AuthorizationPendingErrorMockDeviceCodeExchange(deviceCode, opts)
+		}
+
+		return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
+	}
+
+	// Once a secret works, the remaining secrets are not tried.
+	unusedExchange := func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error) {
+		assert.Fail(t, "unexpected exchange with unused secret")
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithDeviceCodeAuth(testutil.MockClient{ID: client.ID, Secret: "pqr"}, invalidAuth),
+		testutil.MockWithDeviceCodeAuth(client, auth),
+		testutil.MockWithDeviceCodeExchange(client, exchange),
+		testutil.MockWithDeviceCodeExchange(testutil.MockClient{ID: client.ID, Secret: "stu"}, unusedExchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secrets": []string{"pqr", client.Secret, "stu"},
+			"provider":       "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":     "mock",
+			"grant_type": devicecode.GrantType,
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "ABCD-1234", resp.Data["user_code"])
+
+	// The exchange should also fall back to the secret that works.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	for {
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		if !resp.IsError() {
+			break
+		}
+		require.EqualError(t, resp.Error(), "token pending issuance")
+
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for token issuance")
+		case <-time.After(10 * time.Millisecond):
+			clk.Step(time.Second)
+		}
+	}
+
+	require.Equal(t, "hello", resp.Data["access_token"])
+	require.Equal(t, int32(3), atomic.LoadInt32(&polls))
+}
+
+func TestBackchannelAuthAndExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	auth := func(opts *provider.BackchannelAuthOptions) (*ciba.Auth, error) {
+		require.Equal(t, "user@example.com", opts.LoginHint)
+		require.Equal(t, "W4SCT", opts.BindingMessage)
+		require.Equal(t, []string{"openid", "profile"}, opts.Scopes)
+
+		// The clock below advances every time the poller waits, so the
+		// request must not expire before the test completes.
+		return &ciba.Auth{
+			AuthReqID: "1c266114-a1be-4252-8ad1-04986c5b9ac1",
+			ExpiresIn: math.MaxInt32,
+			Interval:  2,
+		}, nil
+	}
+
+	// This contains the state of the issuer: either 0 (pending), 1 (request to
+	// issue), or 2 (issued).
+	var issue, pending int32
+	exchange := func(authReqID string, opts *provider.BackchannelAuthExchangeOptions) (*provider.Token, error) {
+		require.Equal(t, "1c266114-a1be-4252-8ad1-04986c5b9ac1", authReqID)
+
+		switch {
+		case atomic.CompareAndSwapInt32(&issue, 1, 2) || atomic.LoadInt32(&issue) > 1:
+			atomic.AddInt32(&issue, 1)
+			return &provider.Token{Token: &oauth2.Token{AccessToken: "hello"}}, nil
+		default:
+			defer atomic.AddInt32(&pending, 1)
+			return testutil.AuthorizationPendingErrorMockBackchannelAuthExchange(authReqID, opts)
+		}
+	}
+
+	// A pending request must not be confused with the rejection of another
+	// secret, and once a secret works, the remaining secrets are not tried.
+	unusedExchange := func(authReqID string, opts *provider.BackchannelAuthExchangeOptions) (*provider.Token, error) {
+		assert.Fail(t, "unexpected exchange with unused secret")
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
+
+	invalidAuth := func(opts *provider.BackchannelAuthOptions) (*ciba.Auth, error) {
+		return nil, testutil.MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"})
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithBackchannelAuth(testutil.MockClient{ID: client.ID, Secret: "pqr"}, invalidAuth),
+		testutil.MockWithBackchannelAuth(client, auth),
+		testutil.MockWithBackchannelAuthExchange(client, exchange),
+		testutil.MockWithBackchannelAuthExchange(testutil.MockClient{ID: client.ID, Secret: "stu"}, unusedExchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secrets": []string{"pqr", client.Secret, "stu"},
+			"provider":       "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Start the authentication request.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":          "mock",
+			"grant_type":      ciba.GrantType,
+			"login_hint":      "user@example.com",
+			"binding_message": "W4SCT",
+			"scopes":          []interface{}{"openid", "profile"},
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotEmpty(t, resp.Data["expire_time"])
+
+	// Token should now be pending.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
 		Path:      backend.CredsPathPrefix + `test`,
 		Storage:   storage,
 	}
@@ -491,6 +1264,22 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 	require.NotNil(t, resp)
 	require.EqualError(t, resp.Error(), "token pending issuance")
 
+	// Wait for the server to report that the request is pending. The
+	// credential must still be pending afterward.
+	for atomic.LoadInt32(&pending) == 0 {
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for pending exchange")
+		default:
+		}
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.EqualError(t, resp.Error(), "token pending issuance")
+
+	// The end-user approves the request.
 	require.Equal(t, int32(1), atomic.AddInt32(&issue, 1))
 	for atomic.LoadInt32(&issue) == 1 {
 		select {
@@ -505,8 +1294,112 @@ func TestDeviceCodeAuthAndExchange(t *testing.T) {
 	require.NotNil(t, resp)
 	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
 	require.Equal(t, "hello", resp.Data["access_token"])
-	require.Equal(t, "Bearer", resp.Data["type"])
-	require.Empty(t, resp.Data["expire_time"])
+}
+
+func TestBackchannelAuthExpiry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	auth := func(opts *provider.BackchannelAuthOptions) (*ciba.Auth, error) {
+		return &ciba.Auth{
+			AuthReqID: "1c266114-a1be-4252-8ad1-04986c5b9ac1",
+			ExpiresIn: 120,
+			Interval:  2,
+		}, nil
+	}
+
+	var exchanges int32
+	exchange := func(authReqID string, opts *provider.BackchannelAuthExchangeOptions) (*provider.Token, error) {
+		atomic.AddInt32(&exchanges, 1)
+		return testutil.AuthorizationPendingErrorMockBackchannelAuthExchange(authReqID, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithBackchannelAuth(client, auth),
+		testutil.MockWithBackchannelAuthExchange(client, exchange),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock:            k8sext.NewClock(clk),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Start the authentication request.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":     "mock",
+			"grant_type": ciba.GrantType,
+			"login_hint": "user@example.com",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.WithinDuration(t, clk.Now().Add(120*time.Second), resp.Data["expire_time"].(time.Time), 0)
+
+	// Let the request expire without the user responding. The poller should
+	// give up without contacting the authorization server again.
+	clk.Step(121 * time.Second)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	for {
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError())
+		if resp.Error().Error() != "token pending issuance" {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for backchannel authentication expiry")
+		case <-time.After(10 * time.Millisecond):
+			clk.Step(time.Second)
+		}
+	}
+	require.EqualError(t, resp.Error(), "backchannel authentication request expired before the user responded")
+	require.Equal(t, int32(0), atomic.LoadInt32(&exchanges))
 }
 
 func TestAuthCodeMaximumExpiry(t *testing.T) {
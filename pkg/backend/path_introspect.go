@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+)
+
+func (b *backend) introspectUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	token, ok := data.GetOk("token")
+	if !ok {
+		return logical.ErrorResponse("missing token"), nil
+	}
+
+	serverName, err := b.getServerNameOrDefault(ctx, req.Storage, data.Get("server").(string))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(serverName), defaultExpiryDelta)
+	if errmark.MarkedUser(err) {
+		return logical.ErrorResponse(fmt.Errorf("server %q has configuration problems: %w", serverName, errmark.MarkShort(err)).Error()), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer put()
+
+	r, ok, err := ops.Introspect(
+		ctx,
+		token.(string),
+		provider.WithTokenTypeHint(data.Get("token_type_hint").(string)),
+		provider.WithProviderOptions(data.Get("provider_options").(map[string]string)),
+	)
+	if errmark.MarkedUser(err) {
+		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "introspection failed").Error()), nil
+	} else if err != nil {
+		return nil, err
+	} else if !ok {
+		return logical.ErrorResponse("introspection URL not available"), nil
+	}
+
+	rd := map[string]interface{}{
+		"active": r.Active,
+	}
+
+	// RFC 7662 § 2.2: the authorization server should not disclose anything
+	// else about an inactive token, so neither do we.
+	if r.Active {
+		if scopes := strings.Fields(r.Scope); len(scopes) > 0 {
+			rd["scope"] = scopes
+		}
+		if r.Subject != "" {
+			rd["sub"] = r.Subject
+		}
+		if r.Expiry != 0 {
+			rd["exp"] = time.Unix(r.Expiry, 0)
+		}
+		if r.ClientID != "" {
+			rd["client_id"] = r.ClientID
+		}
+	}
+
+	return &logical.Response{Data: rd}, nil
+}
+
+const (
+	IntrospectPath = "introspect"
+)
+
+var introspectFields = map[string]*framework.FieldSchema{
+	// fields for write operations
+	"server": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the server.",
+	},
+	"token": {
+		Type:        framework.TypeString,
+		Description: "Specifies the token to introspect.",
+	},
+	"token_type_hint": {
+		Type:        framework.TypeString,
+		Description: "Specifies the type of the token, either access_token or refresh_token, to help the authorization server find it.",
+	},
+	"provider_options": {
+		Type:        framework.TypeKVPairs,
+		Description: "Specifies any provider-specific options.",
+	},
+}
+
+const introspectHelpSynopsis = `
+Performs RFC 7662 token introspection using a server configuration.
+`
+
+const introspectHelpDescription = `
+This endpoint asks the authorization server whether a token is active
+using the client credentials of a server configuration. Resource
+servers can use it to validate tokens presented to them without
+holding their own introspection credentials.
+
+The response always includes the active field. For active tokens,
+the scope, sub, exp, and client_id fields are included if the
+authorization server provides them.
+`
+
+func pathIntrospect(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: IntrospectPath + `$`,
+		Fields:  introspectFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.introspectUpdateOperation,
+				Summary:  "Introspect a token.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(introspectHelpSynopsis),
+		HelpDescription: strings.TrimSpace(introspectHelpDescription),
+	}
+}
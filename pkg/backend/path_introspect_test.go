@@ -0,0 +1,107 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	introspect := func(token string, opts *provider.IntrospectOptions) (*introspection.Result, error) {
+		assert.Equal(t, "access_token", opts.TokenTypeHint)
+
+		switch token {
+		case "good":
+			return &introspection.Result{
+				Active:   true,
+				Scope:    "read write",
+				ClientID: "svc",
+				Subject:  "alice",
+				Expiry:   exp.Unix(),
+				Username: "alice@example.com",
+			}, nil
+		default:
+			return &introspection.Result{Active: false}, nil
+		}
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithIntrospect(client, introspect),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration. The first secret is not valid, so the
+	// second must be used.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":      client.ID,
+			"client_secrets": []string{"totally_invalid", client.Secret},
+			"provider":       "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Introspect an active token.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.IntrospectPath,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":          "mock",
+			"token":           "good",
+			"token_type_hint": "access_token",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, map[string]interface{}{
+		"active":    true,
+		"scope":     []string{"read", "write"},
+		"sub":       "alice",
+		"exp":       time.Unix(exp.Unix(), 0),
+		"client_id": "svc",
+	}, resp.Data)
+
+	// Introspect an inactive token.
+	req.Data["token"] = "bad"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, map[string]interface{}{"active": false}, resp.Data)
+}
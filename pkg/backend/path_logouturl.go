@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+)
+
+func (b *backend) logoutURLUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyer := persistence.AuthCodeName(data.Get("name").(string))
+
+	entry, err := b.data.AuthCode.Manager(req.Storage).ReadAuthCodeEntry(ctx, keyer)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(entry.AuthServerName), defaultExpiryDelta)
+	if err != nil {
+		return errorResponse(fmt.Errorf("server %q has configuration problems: %w", entry.AuthServerName, err))
+	}
+	defer put()
+
+	opts := []provider.LogoutURLOption{
+		provider.WithRedirectURL(data.Get("redirect_url").(string)),
+		provider.WithState(data.Get("state").(string)),
+	}
+
+	var idToken string
+	if entry.Token != nil {
+		idToken, _ = entry.ExtraData["id_token"].(string)
+		opts = append(opts, provider.WithProviderOptions(entry.ProviderOptions))
+	}
+
+	url, ok := ops.LogoutURL(idToken, opts...)
+	if !ok {
+		return logical.ErrorResponse("logout URL not available"), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"url": url,
+		},
+	}
+	if idToken == "" {
+		resp.AddWarning("credential does not have an ID token to use as a hint; the server may ask the user to confirm the logout")
+	}
+
+	if data.Get("delete").(bool) {
+		if err := b.deleteAuthCodeEntry(ctx, req.Storage, keyer); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+const (
+	LogoutURLPathPrefix = "logout-url/"
+)
+
+var logoutURLFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"redirect_url": {
+		Type:        framework.TypeString,
+		Description: "The URL to redirect to after the logout completes. Must be registered with the authorization server.",
+	},
+	"state": {
+		Type:        framework.TypeString,
+		Description: "Specifies the state to pass to the redirect URL.",
+	},
+	"delete": {
+		Type:        framework.TypeBool,
+		Description: "Whether to also delete the credential.",
+		Default:     false,
+	},
+}
+
+const logoutURLHelpSynopsis = `
+Generates OpenID Connect logout URLs for credentials.
+`
+
+const logoutURLHelpDescription = `
+This endpoint creates a URL that ends the end-user session of a
+credential at the authorization server using OpenID Connect
+RP-initiated logout. If the credential has an ID token, it is sent
+as a hint. The credential can optionally be deleted at the same time.
+`
+
+func pathLogoutURL(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: LogoutURLPathPrefix + nameRegex("name") + `$`,
+		Fields:  logoutURLFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.logoutURLUpdateOperation,
+				Summary:  "Get a logout URL for a credential.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(logoutURLHelpSynopsis),
+		HelpDescription: strings.TrimSpace(logoutURLHelpDescription),
+	}
+}
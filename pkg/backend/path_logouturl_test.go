@@ -0,0 +1,120 @@
+package backend_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogoutURL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	exchange := testutil.AmendTokenMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), func(t *provider.Token) error {
+		t.ExtraData = map[string]interface{}{"id_token": "id_" + t.AccessToken}
+		return nil
+	})
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(testutil.MockWithAuthCodeExchange(client, exchange)))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write a credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Get a logout URL without deleting the credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.LogoutURLPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"redirect_url": "https://example.com/logged-out",
+			"state":        "foo",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Empty(t, resp.Warnings)
+
+	u, err := url.Parse(resp.Data["url"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, testutil.MockEndSessionURL, (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String())
+	assert.Equal(t, url.Values{
+		"client_id":                {client.ID},
+		"id_token_hint":            {"id_token_1"},
+		"post_logout_redirect_uri": {"https://example.com/logged-out"},
+		"state":                    {"foo"},
+	}, u.Query())
+
+	read := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+
+	// Get a logout URL and delete the credential.
+	req.Data = map[string]interface{}{
+		"delete": true,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.NotEmpty(t, resp.Data["url"])
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
@@ -0,0 +1,144 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"golang.org/x/oauth2"
+)
+
+func (b *backend) oboReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	keyer := persistence.AuthCodeName(data.Get("name").(string))
+	expiryDelta := time.Duration(data.Get("minimum_seconds").(int)) * time.Second
+	entry, err := b.getRefreshCredToken(
+		ctx,
+		req.Storage,
+		keyer,
+		expiryDelta,
+	)
+	switch {
+	case err != nil:
+		return nil, errmark.MarkShort(err)
+	case entry == nil:
+		return nil, nil
+	case !entry.TokenIssued():
+		if entry.AuthServerError != "" {
+			return logical.ErrorResponse("server %q has configuration problems: %s", entry.AuthServerName, entry.AuthServerError), nil
+		} else if entry.UserError != "" {
+			return logical.ErrorResponse(entry.UserError), nil
+		}
+
+		return logical.ErrorResponse("token pending issuance"), nil
+	case !b.tokenValid(entry.Token.Token, expiryDelta):
+		if entry.AuthServerError != "" {
+			return logical.ErrorResponse("server %q has configuration problems: %s", entry.AuthServerName, entry.AuthServerError), nil
+		} else if entry.UserError != "" {
+			return logical.ErrorResponse(entry.UserError), nil
+		}
+
+		return logical.ErrorResponse("token expired"), nil
+	}
+
+	scopes := data.Get("scopes").([]string)
+
+	// Exchanged tokens share a cache with the sts/ endpoint, so the key is
+	// prefixed to keep the two from colliding.
+	exchangeKey := "obo,scopes=" + cacheKeyList(scopes)
+
+	tok, err := b.exchangeCachedToken(ctx, req.Storage, &cachedTokenExchange{
+		AuthServerName:  entry.AuthServerName,
+		DPoPKey:         entry.DPoPKey,
+		ExchangeKey:     exchangeKey,
+		ExchangedTokens: entry.ExchangedTokens,
+		Exchange: func(ctx context.Context, ops *providerOperations) (*provider.Token, error) {
+			oboTok, err := ops.OnBehalfOf(
+				ctx,
+				entry.Token,
+				provider.WithScopes(scopes),
+				provider.WithProviderOptions(entry.ProviderOptions),
+			)
+			if err != nil {
+				return nil, errmap.Wrap(err, "on-behalf-of request failed")
+			}
+
+			// copy into smaller struct for caching
+			return &provider.Token{
+				Token: &oauth2.Token{
+					AccessToken: oboTok.Token.AccessToken,
+					TokenType:   oboTok.Token.TokenType,
+					Expiry:      oboTok.Token.Expiry,
+				},
+			}, nil
+		},
+		Store: func(ctx context.Context, exchangeKey string, tok *provider.Token) error {
+			return b.storeExchangedToken(ctx, req.Storage, keyer, exchangeKey, tok)
+		},
+	}, expiryDelta)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return b.tokenExchangeResponse(ctx, data, entry.DPoPKey, tok)
+}
+
+const (
+	OBOPathPrefix = "obo/"
+)
+
+var oboFields = map[string]*framework.FieldSchema{
+	// fields for both read & write operations
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	// fields for read operation
+	"scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the scopes of the downstream API to request from the authorization server.",
+		Query:       true,
+	},
+	"minimum_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Minimum remaining seconds to allow when reusing an on-behalf-of access token.",
+		Default:     0,
+		Query:       true,
+	},
+	"htm":        stsFields["htm"],
+	"htu":        stsFields["htu"],
+	"dpop_nonce": stsFields["dpop_nonce"],
+}
+
+const oboHelpSynopsis = `
+Performs a Microsoft identity platform on-behalf-of request for an existing credential.
+`
+
+const oboHelpDescription = `
+This endpoint uses the access token of an already stored OAuth 2.0
+credential to request a token for a downstream API on behalf of the
+same user. Reading from a corresponding credential path under this
+endpoint performs a urn:ietf:params:oauth:grant-type:jwt-bearer
+request with requested_token_use=on_behalf_of and returns the
+resulting token in the response.
+`
+
+func pathOBO(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: OBOPathPrefix + nameRegex("name") + `$`,
+		Fields:  oboFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.oboReadOperation,
+				Summary:  "Perform an on-behalf-of request for an existing credential.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(oboHelpSynopsis),
+		HelpDescription: strings.TrimSpace(oboHelpDescription),
+	}
+}
@@ -0,0 +1,112 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnBehalfOf(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	obo := testutil.ExpiringMockOnBehalfOf(testutil.IncrementMockOnBehalfOf("obo_"), 2*time.Minute)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+		testutil.MockWithOnBehalfOf(client, func(tok *provider.Token, opts *provider.OnBehalfOfOptions) (*provider.Token, error) {
+			assert.Equal(t, "token_1", tok.AccessToken)
+			return obo(tok, opts)
+		}),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	read := func(scopes string) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      backend.OBOPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"scopes": scopes,
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+		return resp
+	}
+
+	// Read a token for a downstream API.
+	resp = read("api://downstream/.default")
+	assert.Equal(t, "obo_1", resp.Data["access_token"])
+	assert.Equal(t, "Bearer", resp.Data["type"])
+	assert.NotEmpty(t, resp.Data["expire_time"])
+
+	// The same scopes should be served from the cache.
+	resp = read("api://downstream/.default")
+	assert.Equal(t, "obo_1", resp.Data["access_token"])
+
+	// The order of the scopes does not matter.
+	resp = read("api://downstream/.default,offline_access")
+	assert.Equal(t, "obo_2", resp.Data["access_token"])
+
+	resp = read("offline_access,api://downstream/.default")
+	assert.Equal(t, "obo_2", resp.Data["access_token"])
+
+	// Different scopes should issue a new token.
+	resp = read("api://other/.default")
+	assert.Equal(t, "obo_3", resp.Data["access_token"])
+}
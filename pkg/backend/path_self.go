@@ -66,6 +66,13 @@ func (b *backend) selfReadOperation(ctx context.Context, req *logical.Request, d
 		rd["provider_options"] = entry.Config.ProviderOptions
 	}
 
+	proof, err := b.dpopProof(ctx, data, entry.DPoPKey, entry.Token.AccessToken)
+	if err != nil {
+		return errorResponse(err)
+	} else if proof != "" {
+		rd["dpop_proof"] = proof
+	}
+
 	resp := &logical.Response{
 		Data: rd,
 	}
@@ -94,6 +101,11 @@ func (b *backend) selfUpdateOperation(ctx context.Context, req *logical.Request,
 	entry.Config.Scopes = data.Get("scopes").([]string)
 	entry.Config.ProviderOptions = data.Get("provider_options").(map[string]string)
 
+	ctx, err = ops.withDPoPKey(ctx, &entry.DPoPKey)
+	if err != nil {
+		return nil, err
+	}
+
 	tok, err := ops.ClientCredentials(
 		ctx,
 		provider.WithURLParams(entry.Config.TokenURLParams),
@@ -108,7 +120,7 @@ func (b *backend) selfUpdateOperation(ctx context.Context, req *logical.Request,
 
 	entry.SetToken(ctx, tok)
 
-	if err := b.data.ClientCreds.Manager(req.Storage).WriteClientCredsEntry(ctx, persistence.ClientCredsName(data.Get("name").(string)), entry); err != nil {
+	if err := b.replaceClientCredsEntry(ctx, req.Storage, persistence.ClientCredsName(data.Get("name").(string)), entry); err != nil {
 		return nil, err
 	}
 
@@ -116,10 +128,25 @@ func (b *backend) selfUpdateOperation(ctx context.Context, req *logical.Request,
 }
 
 func (b *backend) selfDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	if err := b.data.ClientCreds.Manager(req.Storage).DeleteClientCredsEntry(ctx, persistence.ClientCredsName(data.Get("name").(string))); err != nil {
+	var revocations []*persistence.RevocationEntry
+	err := b.data.ClientCreds.WithLock(persistence.ClientCredsName(data.Get("name").(string)), func(ch *persistence.LockedClientCredsHolder) error {
+		cm := ch.Manager(req.Storage)
+
+		entry, err := cm.ReadClientCredsEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry != nil {
+			revocations = credentialRevocations(entry.AuthServerName, entry.Token, entry.ExchangedTokens)
+		}
+
+		return cm.DeleteClientCredsEntry(ctx)
+	})
+	if err != nil {
 		return nil, err
 	}
 
+	b.revokeTokens(ctx, req.Storage, revocations)
+
 	return nil, nil
 }
 
@@ -139,6 +166,22 @@ var selfFields = map[string]*framework.FieldSchema{
 		Description: "Minimum remaining seconds to allow when reusing access token.",
 		Query:       true,
 	},
+	"htm": {
+		Type:        framework.TypeString,
+		Description: "The HTTP method of the request to generate a DPoP proof for.",
+		Default:     "GET",
+		Query:       true,
+	},
+	"htu": {
+		Type:        framework.TypeString,
+		Description: "The URL of the request to generate a DPoP proof for. If specified, a proof for the access token is returned in the dpop_proof field.",
+		Query:       true,
+	},
+	"dpop_nonce": {
+		Type:        framework.TypeString,
+		Description: "A nonce provided by the resource server to include in the DPoP proof.",
+		Query:       true,
+	},
 	// fields for write operation
 	"server": {
 		Type:        framework.TypeString,
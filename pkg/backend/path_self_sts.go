@@ -0,0 +1,113 @@
+package backend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"golang.org/x/oauth2"
+)
+
+func (b *backend) selfSTSReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ter, err := newTokenExchangeRequest(req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	keyer := persistence.ClientCredsName(data.Get("name").(string))
+	expiryDelta := time.Duration(data.Get("minimum_seconds").(int)) * time.Second
+
+	entry, err := b.getUpdateClientCredsToken(
+		ctx,
+		req.Storage,
+		keyer,
+		expiryDelta,
+	)
+	switch {
+	case errmark.Matches(err, errmark.RuleType(&oauth2.RetrieveError{})) || errmark.MarkedUser(err):
+		return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "client credentials flow failed").Error()), nil
+	case err != nil:
+		return nil, err
+	case entry == nil:
+		return nil, nil
+	case !b.tokenValid(entry.Token.Token, expiryDelta):
+		return logical.ErrorResponse("token expired"), nil
+	}
+
+	tok, err := b.exchangeCachedToken(ctx, req.Storage, &cachedTokenExchange{
+		AuthServerName:  entry.AuthServerName,
+		DPoPKey:         entry.DPoPKey,
+		ExchangeKey:     ter.CacheKey(),
+		ExchangedTokens: entry.ExchangedTokens,
+		Exchange: func(ctx context.Context, ops *providerOperations) (*provider.Token, error) {
+			return b.tokenExchange(ctx, req.Storage, ops, entry.Token, ter, entry.Config.ProviderOptions, expiryDelta)
+		},
+		Store: func(ctx context.Context, exchangeKey string, tok *provider.Token) error {
+			return b.storeClientCredsExchangedToken(ctx, req.Storage, keyer, exchangeKey, tok)
+		},
+	}, expiryDelta)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return b.tokenExchangeResponse(ctx, data, entry.DPoPKey, tok)
+}
+
+const (
+	SelfSTSPathPrefix = "self-sts/"
+)
+
+// selfSTSFields are the same as stsFields, except that the token of another
+// credential cannot be used as the actor token.
+var selfSTSFields = map[string]*framework.FieldSchema{
+	"name":                 stsFields["name"],
+	"scopes":               stsFields["scopes"],
+	"audiences":            stsFields["audiences"],
+	"resources":            stsFields["resources"],
+	"subject_token_type":   stsFields["subject_token_type"],
+	"requested_token_type": stsFields["requested_token_type"],
+	"actor_token":          stsFields["actor_token"],
+	"actor_token_type":     stsFields["actor_token_type"],
+	"minimum_seconds":      stsFields["minimum_seconds"],
+	"htm":                  stsFields["htm"],
+	"htu":                  stsFields["htu"],
+	"dpop_nonce":           stsFields["dpop_nonce"],
+}
+
+const selfSTSHelpSynopsis = `
+Performs RFC 8693 token exchange for an existing client credentials entry.
+`
+
+const selfSTSHelpDescription = `
+This endpoint performs a token exchange for an access token obtained
+using the client credentials flow. Reading from a corresponding
+credential path under this endpoint obtains or reuses the token of
+the self/ credential with the same name, exchanges it, and returns
+the resulting token in the response. Use it to downscope the token
+or to retarget it to a different audience or resource.
+`
+
+func pathSelfSTS(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: SelfSTSPathPrefix + nameRegex("name") + `$`,
+		Fields:  selfSTSFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.selfSTSReadOperation,
+				Summary:  "Perform a token exchange for an existing client credentials entry.",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.selfSTSReadOperation,
+				Summary:  "Perform a token exchange for an existing client credentials entry with an actor token.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(selfSTSHelpSynopsis),
+		HelpDescription: strings.TrimSpace(selfSTSHelpDescription),
+	}
+}
@@ -0,0 +1,130 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestClientCredentialsExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithClientCredentials(client, func(_ *provider.ClientCredentialsOptions) (*provider.Token, error) {
+			return &provider.Token{
+				Token: &oauth2.Token{
+					AccessToken: "machine",
+				},
+			}, nil
+		}),
+		testutil.MockWithTokenExchange(client, testutil.RestrictMockTokenExchange(map[string]testutil.MockTokenExchangeFunc{
+			"machine": testutil.FilterMockTokenExchange(
+				testutil.ExpiringMockTokenExchange(testutil.IncrementMockTokenExchange("exchanged_"), 2*time.Minute),
+				func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+					return assert.Equal(t, map[string]string{"tenant": "foo"}, opts.ProviderOptions)
+				},
+				func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+					// Other credentials cannot be used as the actor.
+					return assert.Empty(t, opts.ActorToken)
+				},
+			),
+		})),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write a client credentials entry.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.SelfPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":           "mock",
+			"provider_options": "tenant=foo",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Exchange the token for an audience-specific token.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.SelfSTSPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"audiences": "urn:audiencea",
+			"actor":     "other",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "exchanged_1", resp.Data["access_token"])
+	assert.NotEmpty(t, resp.Data["expire_time"])
+
+	// The exchanged token is reused for the same parameters.
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "exchanged_1", resp.Data["access_token"])
+
+	// A different audience requires a new exchange.
+	req.Data["audiences"] = "urn:audienceb"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "exchanged_2", resp.Data["access_token"])
+
+	// Nonexistent entries are not found.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.SelfSTSPathPrefix + `missing`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
@@ -2,15 +2,20 @@ package backend
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/registration"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao/sdk/v2/framework"
 	"github.com/openbao/openbao/sdk/v2/logical"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	jose "gopkg.in/square/go-jose.v2"
 )
 
 func (b *backend) serversListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -59,13 +64,66 @@ func (b *backend) serversReadOperation(ctx context.Context, req *logical.Request
 			"provider_options": server.ProviderOptions,
 		},
 	}
+	if server.TokenEndpointAuthMethod != "" {
+		resp.Data["token_endpoint_auth_method"] = server.TokenEndpointAuthMethod
+	}
+	if server.ClientPrivateKey != "" {
+		resp.Data["client_private_key_id"] = server.ClientPrivateKeyID
+		resp.Data["client_private_key_alg"] = server.ClientPrivateKeyAlgorithm
+	}
+	if server.TLSClientCertificate != "" {
+		resp.Data["tls_client_certificate"] = server.TLSClientCertificate
+	}
+	if server.DPoP {
+		resp.Data["dpop"] = true
+	}
+	if server.RequestObjectSigningKey != "" {
+		resp.Data["request_object_signing_key_id"] = server.RequestObjectSigningKeyID
+		resp.Data["request_object_signing_alg"] = server.RequestObjectSigningAlgorithm
+	}
+	if server.PushedAuthRequests {
+		resp.Data["pushed_auth_requests"] = true
+	}
+	if server.PKCE != nil {
+		resp.Data["pkce"] = *server.PKCE
+	}
+	if server.CallbackSuccessTemplate != "" {
+		resp.Data["callback_success_template"] = server.CallbackSuccessTemplate
+	}
+	if server.CallbackFailureTemplate != "" {
+		resp.Data["callback_failure_template"] = server.CallbackFailureTemplate
+	}
+	if server.CallbackCredentialPrefix != "" {
+		resp.Data["callback_credential_prefix"] = server.CallbackCredentialPrefix
+	}
+	if len(server.STSActors) > 0 {
+		resp.Data["sts_actors"] = server.STSActors
+	}
+	if reg := server.Registration; reg != nil {
+		resp.Data["registration_url"] = reg.URL
+		resp.Data["redirect_uris"] = reg.Metadata.RedirectURIs
+		resp.Data["grant_types"] = reg.Metadata.GrantTypes
+		if reg.Metadata.ClientName != "" {
+			resp.Data["client_name"] = reg.Metadata.ClientName
+		}
+		if reg.ClientURI != "" {
+			resp.Data["registration_client_uri"] = reg.ClientURI
+		}
+		if reg.ClientSecretExpiresAt > 0 {
+			resp.Data["client_secret_expires_at"] = time.Unix(reg.ClientSecretExpiresAt, 0)
+		}
+	}
 	return resp, nil
 }
 
 func (b *backend) serversUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-	clientID, ok := data.GetOk("client_id")
-	if !ok {
+	registrationURL := data.Get("registration_url").(string)
+
+	_, ok := data.GetOk("client_id")
+	if !ok && registrationURL == "" {
 		return logical.ErrorResponse("missing client ID"), nil
+	} else if ok && registrationURL != "" {
+		return logical.ErrorResponse("client ID cannot be specified when registering a client"), nil
 	}
 
 	providerName, ok := data.GetOk("provider")
@@ -84,42 +142,206 @@ func (b *backend) serversUpdateOperation(ctx context.Context, req *logical.Reque
 		return nil, err
 	}
 
+	for _, field := range []string{"callback_success_template", "callback_failure_template"} {
+		if _, err := parseCallbackTemplate(data.Get(field).(string)); err != nil {
+			return logical.ErrorResponse("invalid %s: %+v", field, err), nil
+		}
+	}
+
+	tokenEndpointAuthMethod := data.Get("token_endpoint_auth_method").(string)
+	clientPrivateKey := data.Get("client_private_key").(string)
+	clientPrivateKeyID := data.Get("client_private_key_id").(string)
+	clientPrivateKeyAlgorithm := data.Get("client_private_key_alg").(string)
+	if clientPrivateKey != "" {
+		key, err := jwtkey.ParsePEM(clientPrivateKey, clientPrivateKeyID, clientPrivateKeyAlgorithm)
+		if err != nil {
+			return logical.ErrorResponse("invalid client_private_key: %+v", err), nil
+		}
+
+		clientPrivateKeyAlgorithm = string(key.Algorithm)
+	}
+
+	tlsClientCertificate := data.Get("tls_client_certificate").(string)
+	tlsClientKey := data.Get("tls_client_key").(string)
+	if tlsClientCertificate != "" || tlsClientKey != "" {
+		if _, err := tls.X509KeyPair([]byte(tlsClientCertificate), []byte(tlsClientKey)); err != nil {
+			return logical.ErrorResponse("invalid TLS client certificate: %+v", err), nil
+		}
+	}
+
+	switch tokenEndpointAuthMethod {
+	case "":
+	case persistence.TokenEndpointAuthMethodPrivateKeyJWT:
+		if clientPrivateKey == "" {
+			return logical.ErrorResponse("token endpoint authentication method %q requires a client private key", tokenEndpointAuthMethod), nil
+		}
+	case persistence.TokenEndpointAuthMethodTLSClientAuth, persistence.TokenEndpointAuthMethodSelfSignedTLSClientAuth:
+		if tlsClientCertificate == "" {
+			return logical.ErrorResponse("token endpoint authentication method %q requires a TLS client certificate", tokenEndpointAuthMethod), nil
+		}
+	default:
+		return logical.ErrorResponse("unknown token endpoint authentication method %q", tokenEndpointAuthMethod), nil
+	}
+
+	requestObjectSigningKey := data.Get("request_object_signing_key").(string)
+	requestObjectSigningKeyID := data.Get("request_object_signing_key_id").(string)
+	requestObjectSigningAlgorithm := data.Get("request_object_signing_alg").(string)
+	if requestObjectSigningKey != "" {
+		key, err := jwtkey.ParsePEM(requestObjectSigningKey, requestObjectSigningKeyID, requestObjectSigningAlgorithm)
+		if err != nil {
+			return logical.ErrorResponse("invalid request_object_signing_key: %+v", err), nil
+		}
+
+		requestObjectSigningAlgorithm = string(key.Algorithm)
+	}
+
 	var clientSecrets []string
 	if clientSecret := data.Get("client_secret").(string); clientSecret != "" {
 		clientSecrets = append(clientSecrets, clientSecret)
 	}
 	clientSecrets = append(clientSecrets, data.Get("client_secrets").([]string)...)
+	if len(clientSecrets) > 0 && registrationURL != "" {
+		return logical.ErrorResponse("client secrets cannot be specified when registering a client"), nil
+	}
+
+	var pkce *bool
+	if v, ok := data.GetOk("pkce"); ok {
+		pkce = new(bool)
+		*pkce = v.(bool)
+	}
 
 	entry := &persistence.AuthServerEntry{
 		Name: data.Get("name").(string),
 
-		ClientID:        clientID.(string),
+		ClientID:        data.Get("client_id").(string),
 		ClientSecrets:   clientSecrets,
 		AuthURLParams:   data.Get("auth_url_params").(map[string]string),
 		ProviderName:    providerName.(string),
 		ProviderVersion: p.Version(),
 		ProviderOptions: providerOptions,
+
+		TokenEndpointAuthMethod:   tokenEndpointAuthMethod,
+		ClientPrivateKey:          clientPrivateKey,
+		ClientPrivateKeyID:        clientPrivateKeyID,
+		ClientPrivateKeyAlgorithm: clientPrivateKeyAlgorithm,
+
+		TLSClientCertificate: tlsClientCertificate,
+		TLSClientKey:         tlsClientKey,
+
+		DPoP: data.Get("dpop").(bool),
+
+		RequestObjectSigningKey:       requestObjectSigningKey,
+		RequestObjectSigningKeyID:     requestObjectSigningKeyID,
+		RequestObjectSigningAlgorithm: requestObjectSigningAlgorithm,
+
+		PushedAuthRequests: data.Get("pushed_auth_requests").(bool),
+
+		PKCE:                    pkce,
+		CallbackSuccessTemplate: data.Get("callback_success_template").(string),
+		CallbackFailureTemplate: data.Get("callback_failure_template").(string),
+
+		CallbackCredentialPrefix: data.Get("callback_credential_prefix").(string),
+
+		STSActors: data.Get("sts_actors").([]string),
 	}
 	keyer := persistence.AuthServerName(entry.Name)
 
-	if err := b.data.AuthServer.Manager(req.Storage).WriteAuthServerEntry(ctx, keyer, entry); err != nil {
-		return nil, err
+	var warnings []string
+	err = b.data.AuthServer.WithLock(keyer, func(lash *persistence.LockedAuthServerHolder) error {
+		m := lash.Manager(req.Storage)
+
+		prev, err := m.ReadAuthServerEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		if registrationURL != "" {
+			cfg := &registration.Config{
+				RegistrationURL:    registrationURL,
+				InitialAccessToken: data.Get("registration_initial_access_token").(string),
+			}
+
+			md := &registration.Metadata{
+				RedirectURIs:            data.Get("redirect_uris").([]string),
+				GrantTypes:              data.Get("grant_types").([]string),
+				TokenEndpointAuthMethod: tokenEndpointAuthMethod,
+				ClientName:              data.Get("client_name").(string),
+			}
+			if tokenEndpointAuthMethod == persistence.TokenEndpointAuthMethodPrivateKeyJWT {
+				key, err := jwtkey.ParsePEM(clientPrivateKey, clientPrivateKeyID, clientPrivateKeyAlgorithm)
+				if err != nil {
+					return err
+				}
+
+				md.JWKS = &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*key.Public()}}
+			}
+
+			if err := b.registerAuthServerClient(ctx, prev, entry, cfg, md); err != nil {
+				return err
+			}
+		}
+
+		if err := m.WriteAuthServerEntry(ctx, entry); err != nil {
+			// A client we just registered would otherwise be orphaned at the
+			// authorization server, so make an effort to remove it again.
+			if replacesRegisteredClient(entry, prev) {
+				if warning := b.deregisterAuthServerClient(ctx, entry); warning != "" {
+					b.Logger().Warn("failed to clean up registered client", "server", entry.Name, "warning", warning)
+				}
+			}
+
+			return err
+		}
+
+		// The previous client is only removed once the server no longer
+		// refers to it.
+		if replacesRegisteredClient(prev, entry) {
+			if warning := b.deregisterAuthServerClient(ctx, prev); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	b.cache.AuthServer.Invalidate(keyer)
 
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+
 	return nil, nil
 }
 
 func (b *backend) serversDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 	keyer := persistence.AuthServerName(data.Get("name").(string))
 
-	if err := b.data.AuthServer.Manager(req.Storage).DeleteAuthServerEntry(ctx, keyer); err != nil {
+	var warning string
+	err := b.data.AuthServer.WithLock(keyer, func(lash *persistence.LockedAuthServerHolder) error {
+		m := lash.Manager(req.Storage)
+
+		entry, err := m.ReadAuthServerEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		warning = b.deregisterAuthServerClient(ctx, entry)
+
+		return m.DeleteAuthServerEntry(ctx)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	b.cache.AuthServer.Invalidate(keyer)
 
+	if warning != "" {
+		return &logical.Response{Warnings: []string{warning}}, nil
+	}
+
 	return nil, nil
 }
 
@@ -158,6 +380,90 @@ var serversFields = map[string]*framework.FieldSchema{
 		Type:        framework.TypeKVPairs,
 		Description: "Specifies any provider-specific options.",
 	},
+	"token_endpoint_auth_method": {
+		Type:        framework.TypeString,
+		Description: `Specifies how the client authenticates to the authorization server. If set to "private_key_jwt", requests are authenticated using a JWT signed with the client private key instead of a client secret. If set to "tls_client_auth" or "self_signed_tls_client_auth", requests are authenticated using the TLS client certificate.`,
+	},
+	"tls_client_certificate": {
+		Type:        framework.TypeString,
+		Description: "Specifies a PEM-encoded certificate chain to present to the authorization server when establishing TLS connections.",
+	},
+	"tls_client_key": {
+		Type:        framework.TypeString,
+		Description: "Specifies the PEM-encoded private key of the TLS client certificate.",
+	},
+	"client_private_key": {
+		Type:        framework.TypeString,
+		Description: "Specifies a PEM-encoded RSA, ECDSA, or Ed25519 private key to sign client assertions with.",
+	},
+	"client_private_key_id": {
+		Type:        framework.TypeString,
+		Description: "Specifies the key ID to include in the header of client assertions.",
+	},
+	"client_private_key_alg": {
+		Type:        framework.TypeString,
+		Description: "Specifies the JWS algorithm to sign client assertions with. Defaults to RS256 for RSA keys, ES256, ES384, or ES512 for ECDSA keys depending on the curve, and EdDSA for Ed25519 keys.",
+	},
+	"dpop": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to bind tokens issued to credentials for this server to a per-credential key using RFC 9449 DPoP.",
+	},
+	"request_object_signing_key": {
+		Type:        framework.TypeString,
+		Description: "Specifies a PEM-encoded RSA, ECDSA, or Ed25519 private key. If set, authorization code URL parameters are signed into an RFC 9101 request object using this key.",
+	},
+	"request_object_signing_key_id": {
+		Type:        framework.TypeString,
+		Description: "Specifies the key ID to include in the header of signed request objects.",
+	},
+	"request_object_signing_alg": {
+		Type:        framework.TypeString,
+		Description: "Specifies the JWS algorithm to sign request objects with. Defaults to RS256 for RSA keys, ES256, ES384, or ES512 for ECDSA keys depending on the curve, and EdDSA for Ed25519 keys.",
+	},
+	"pushed_auth_requests": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether to create authorization code URLs using RFC 9126 pushed authorization requests. Pushed authorization requests are always used if the authorization server requires them.",
+	},
+	"pkce": {
+		Type:        framework.TypeBool,
+		Description: "Specifies whether authorization code URLs include an RFC 7636 (PKCE) code challenge by default. Their codes must then be exchanged by providing the state to the credential endpoint. If not specified, defaults to whether the authorization server metadata advertises the S256 code challenge method.",
+	},
+	"callback_success_template": {
+		Type:        framework.TypeString,
+		Description: "Specifies an HTML template to render when the callback endpoint successfully writes a credential.",
+	},
+	"callback_failure_template": {
+		Type:        framework.TypeString,
+		Description: "Specifies an HTML template to render when the callback endpoint fails to write a credential.",
+	},
+	"callback_credential_prefix": {
+		Type:        framework.TypeString,
+		Description: "Specifies a prefix that the names of credentials written by the callback endpoint must have. If not specified, the callback endpoint does not write credentials.",
+	},
+	"sts_actors": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the names of the credentials that may be used as the actor of token exchanges for credentials of this server.",
+	},
+	"registration_url": {
+		Type:        framework.TypeString,
+		Description: "Specifies an RFC 7591 client registration endpoint. If set, the client is registered with the authorization server instead of using the client_id and client_secret fields.",
+	},
+	"registration_initial_access_token": {
+		Type:        framework.TypeString,
+		Description: "Specifies the initial access token that authorizes the client registration request.",
+	},
+	"redirect_uris": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the redirect URIs of a registered client.",
+	},
+	"grant_types": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the grant types a registered client may use.",
+	},
+	"client_name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the human-readable name of a registered client.",
+	},
 }
 
 const serversHelpSynopsis = `
@@ -2,6 +2,9 @@ package backend_test
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
 	"testing"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
 	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 )
@@ -62,6 +66,15 @@ func TestServerReadWrite(t *testing.T) {
 	require.Empty(t, resp.Data["client_secret"])
 	require.Equal(t, "mock", resp.Data["provider"])
 	require.Equal(t, 2, resp.Data["provider_version"])
+
+	// Keys that cannot be used are rejected.
+	write.Data["request_object_signing_key"] = "not a key"
+
+	resp, err = b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), "invalid request_object_signing_key")
 }
 
 func TestMultipleServers(t *testing.T) {
@@ -188,3 +201,262 @@ func TestMultipleServers(t *testing.T) {
 	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
 	require.Equal(t, []string{"server1", "server2", "server3"}, resp.Data["keys"])
 }
+
+func TestServerRegistration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var registered []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/register":
+			assert.Equal(t, "Bearer initial", r.Header.Get("Authorization"))
+
+			var md map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&md))
+			assert.Equal(t, []interface{}{"https://example.com/callback"}, md["redirect_uris"])
+
+			registered = append(registered, "client1")
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"client_id": "client1",
+				"client_secret": "secret1",
+				"registration_access_token": "rat1",
+				"registration_client_uri": "http://localhost/register/client1",
+				"redirect_uris": ["https://example.com/callback"]
+			}`))
+		case r.Method == http.MethodPut && r.URL.Path == "/register/client1":
+			assert.Equal(t, "Bearer rat1", r.Header.Get("Authorization"))
+
+			var md map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&md))
+			assert.Equal(t, "client1", md["client_id"])
+			assert.Equal(t, "secret1", md["client_secret"])
+
+			_, _ = w.Write([]byte(`{
+				"client_id": "client1",
+				"client_secret": "secret2",
+				"redirect_uris": ["https://example.com/callback", "https://example.com/other"]
+			}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/register/client1":
+			assert.Equal(t, "Bearer rat1", r.Header.Get("Authorization"))
+
+			registered = registered[:0]
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	write := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"provider":                          "mock",
+			"registration_url":                  "http://localhost/register",
+			"registration_initial_access_token": "initial",
+			"redirect_uris":                     "https://example.com/callback",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Equal(t, []string{"client1"}, registered)
+
+	read := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "client1", resp.Data["client_id"])
+	assert.Equal(t, "http://localhost/register", resp.Data["registration_url"])
+	assert.Equal(t, "http://localhost/register/client1", resp.Data["registration_client_uri"])
+
+	// Updating the server updates the registered client instead of creating a
+	// new one.
+	write.Data["redirect_uris"] = "https://example.com/callback,https://example.com/other"
+
+	resp, err = b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Equal(t, []string{"client1"}, registered)
+
+	resp, err = b.HandleRequest(ctx, read)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"https://example.com/callback", "https://example.com/other"}, resp.Data["redirect_uris"])
+
+	// Deleting the server deregisters the client.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Empty(t, registered)
+}
+
+type failingPutStorage struct {
+	logical.InmemStorage
+	fail bool
+}
+
+func (fps *failingPutStorage) Put(ctx context.Context, entry *logical.StorageEntry) error {
+	if fps.fail {
+		return errors.New("storage unavailable")
+	}
+
+	return fps.InmemStorage.Put(ctx, entry)
+}
+
+func TestServerRegistrationWriteFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var registered []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/register":
+			registered = append(registered, "client1")
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"client_id": "client1",
+				"client_secret": "secret1",
+				"registration_access_token": "rat1",
+				"registration_client_uri": "http://localhost/register/client1"
+			}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/other":
+			registered = append(registered, "client2")
+
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{
+				"client_id": "client2",
+				"client_secret": "secret2",
+				"registration_access_token": "rat2",
+				"registration_client_uri": "http://localhost/other/client2"
+			}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/register/client1":
+			assert.Equal(t, "Bearer rat1", r.Header.Get("Authorization"))
+
+			registered = removeString(registered, "client1")
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && r.URL.Path == "/other/client2":
+			assert.Equal(t, "Bearer rat2", r.Header.Get("Authorization"))
+
+			registered = removeString(registered, "client2")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory())
+
+	storage := &failingPutStorage{fail: true}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	write := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"provider":         "mock",
+			"registration_url": "http://localhost/register",
+			"redirect_uris":    "https://example.com/callback",
+		},
+	}
+
+	// The client registered for the server must not be left behind when the
+	// server cannot be saved.
+	_, err = b.HandleRequest(ctx, write)
+	require.EqualError(t, err, "storage unavailable")
+	require.Empty(t, registered)
+
+	storage.fail = false
+
+	resp, err := b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Equal(t, []string{"client1"}, registered)
+
+	storage.fail = true
+
+	// The previous client must keep working when a replacement client cannot
+	// be saved.
+	write.Data["registration_url"] = "http://localhost/other"
+
+	_, err = b.HandleRequest(ctx, write)
+	require.EqualError(t, err, "storage unavailable")
+	require.Equal(t, []string{"client1"}, registered)
+
+	// The same applies when switching to a manually configured client.
+	_, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"provider":      "mock",
+			"client_id":     "abc",
+			"client_secret": "def",
+		},
+	})
+	require.EqualError(t, err, "storage unavailable")
+	require.Equal(t, []string{"client1"}, registered)
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "client1", resp.Data["client_id"])
+
+	// Once the replacement is saved, the previous client is removed.
+	storage.fail = false
+
+	resp, err = b.HandleRequest(ctx, write)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Equal(t, []string{"client2"}, registered)
+}
+
+func removeString(ss []string, s string) []string {
+	var r []string
+	for _, candidate := range ss {
+		if candidate != s {
+			r = append(r, candidate)
+		}
+	}
+	return r
+}
@@ -10,12 +10,25 @@ import (
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao/sdk/v2/framework"
 	"github.com/openbao/openbao/sdk/v2/logical"
-	"github.com/puppetlabs/leg/errmap/pkg/errmap"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
-	"golang.org/x/oauth2"
 )
 
 func (b *backend) stsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ter, err := newTokenExchangeRequest(req, data)
+	if err != nil {
+		return errorResponse(err)
+	}
+	ter.Actor = data.Get("actor").(string)
+
+	return b.stsExchange(ctx, req, data, ter, nil)
+}
+
+// stsExchange performs a token exchange for the credential named in the
+// request, reusing a previously exchanged token with the same parameters if
+// possible. If a profile is given, the credential must use the server of the
+// profile and the exchanged token must not outlive the maximum lifetime of
+// the profile.
+func (b *backend) stsExchange(ctx context.Context, req *logical.Request, data *framework.FieldData, ter *tokenExchangeRequest, profile *persistence.STSProfileEntry) (*logical.Response, error) {
 	keyer := persistence.AuthCodeName(data.Get("name").(string))
 	expiryDelta := time.Duration(data.Get("minimum_seconds").(int)) * time.Second
 	entry, err := b.getRefreshCredToken(
@@ -47,71 +60,53 @@ func (b *backend) stsReadOperation(ctx context.Context, req *logical.Request, da
 		return logical.ErrorResponse("token expired"), nil
 	}
 
-	scopes := data.Get("scopes").([]string)
-	audiences := data.Get("audiences").([]string)
-	resources := data.Get("resources").([]string)
-	exchangeKey := "scopes=" + strings.Join(scopes, " ") +
-		",audiences=" + strings.Join(audiences, " ") +
-		",resources=" + strings.Join(resources, " ")
-
-	tok, ok := entry.ExchangedTokens[exchangeKey]
-	if !ok || !b.tokenValid(tok, expiryDelta) {
-		ops, put, err := b.getProviderOperations(ctx, req.Storage, persistence.AuthServerName(entry.AuthServerName), defaultExpiryDelta)
-		if errmark.MarkedUser(err) {
-			return logical.ErrorResponse(fmt.Errorf("server %q has configuration problems: %w", entry.AuthServerName, errmark.MarkShort(err)).Error()), nil
-		} else if err != nil {
-			return nil, err
-		}
-		defer put()
-
-		exchangedTok, err := ops.TokenExchange(
-			ctx,
-			entry.Token,
-			provider.WithScopes(scopes),
-			provider.WithAudiences(audiences),
-			provider.WithResources(resources),
-			provider.WithProviderOptions(entry.ProviderOptions),
-		)
-		if errmark.MarkedUser(err) {
-			return logical.ErrorResponse(errmap.Wrap(errmark.MarkShort(err), "exchange failed").Error()), nil
-		} else if err != nil {
-			return nil, err
-		}
-		if !b.tokenValid(exchangedTok.Token, expiryDelta) {
-			return logical.ErrorResponse("token expired"), nil
+	exchangeKey := ter.CacheKey()
+	if profile != nil {
+		if entry.AuthServerName != profile.AuthServerName {
+			return logical.ErrorResponse("credential uses server %q, but profile %q requires server %q", entry.AuthServerName, profile.Name, profile.AuthServerName), nil
 		}
 
-		// copy into smaller struct for caching
-		tok = &oauth2.Token{
-			AccessToken: exchangedTok.Token.AccessToken,
-			TokenType:   exchangedTok.Token.TokenType,
-			Expiry:      exchangedTok.Token.Expiry,
-		}
+		// Changing the profile changes the parameters in the key, so tokens
+		// exchanged with a previous version of the profile are not reused.
+		exchangeKey = fmt.Sprintf("profile=%s,maximum_expiry_seconds=%d,", profile.Name, profile.MaximumExpirySeconds) + exchangeKey
+	}
 
-		if !tok.Expiry.IsZero() {
-			// Cache the token since it has an expiration time
-			err = b.storeExchangedToken(
-				ctx,
-				req.Storage,
-				keyer,
-				exchangeKey,
-				tok)
+	if err := b.checkTokenExchangeActor(ctx, req.Storage, entry.AuthServerName, ter); err != nil {
+		return errorResponse(err)
+	}
+
+	tok, err := b.exchangeCachedToken(ctx, req.Storage, &cachedTokenExchange{
+		AuthServerName:  entry.AuthServerName,
+		DPoPKey:         entry.DPoPKey,
+		ExchangeKey:     exchangeKey,
+		ExchangedTokens: entry.ExchangedTokens,
+		Exchange: func(ctx context.Context, ops *providerOperations) (*provider.Token, error) {
+			tok, err := b.tokenExchange(ctx, req.Storage, ops, entry.Token, ter, entry.ProviderOptions, expiryDelta)
 			if err != nil {
 				return nil, err
 			}
-		}
-	}
 
-	rd := map[string]interface{}{
-		"access_token": tok.AccessToken,
-		"type":         tok.Type(),
-	}
+			// RFC 8693 has no way to ask for a shorter lifetime, so we refuse
+			// tokens that the authorization server allows to live longer
+			// (or indefinitely) instead.
+			if profile != nil && profile.MaximumExpirySeconds > 0 {
+				maximumExpiry := b.clock.Now().Add(time.Duration(profile.MaximumExpirySeconds) * time.Second)
+				if tok.Expiry.IsZero() || tok.Expiry.After(maximumExpiry) {
+					return nil, errmark.MarkUser(ErrExchangedTokenTooLong)
+				}
+			}
 
-	if !tok.Expiry.IsZero() {
-		rd["expire_time"] = tok.Expiry
+			return tok, nil
+		},
+		Store: func(ctx context.Context, exchangeKey string, tok *provider.Token) error {
+			return b.storeExchangedToken(ctx, req.Storage, keyer, exchangeKey, tok)
+		},
+	}, expiryDelta)
+	if err != nil {
+		return errorResponse(err)
 	}
 
-	return &logical.Response{Data: rd}, nil
+	return b.tokenExchangeResponse(ctx, data, entry.DPoPKey, tok)
 }
 
 const (
@@ -140,12 +135,55 @@ var stsFields = map[string]*framework.FieldSchema{
 		Description: "Specifies the target RFC 8707 resource indicators for the minted token.",
 		Query:       true,
 	},
+	"subject_token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies which token of the credential to use as the subject token. One of access_token, refresh_token, or id_token, or the corresponding RFC 8693 token type URI.",
+		Default:     "access_token",
+		Query:       true,
+	},
+	"requested_token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies the RFC 8693 token type to request from the authorization server. Short names like access_token or jwt are expanded to their token type URIs. Other token types must be URNs.",
+		Default:     "access_token",
+		Query:       true,
+	},
+	"actor": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of another credential to send as the actor token for delegation. The credential must be listed in the sts_actors of the server.",
+		Query:       true,
+	},
+	"actor_token": {
+		Type:        framework.TypeString,
+		Description: "Specifies a token to send as the actor token for delegation. Cannot be used with actor. Only accepted in the body of a write request.",
+	},
+	"actor_token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies the type of the actor token. For an actor credential, one of access_token, refresh_token, or id_token, or the corresponding RFC 8693 token type URI.",
+		Default:     "access_token",
+		Query:       true,
+	},
 	"minimum_seconds": {
 		Type:        framework.TypeDurationSecond,
 		Description: "Minimum remaining seconds to allow when reusing exchanged access token.",
 		Default:     0,
 		Query:       true,
 	},
+	"htm": {
+		Type:        framework.TypeString,
+		Description: "The HTTP method of the request to generate a DPoP proof for.",
+		Default:     "GET",
+		Query:       true,
+	},
+	"htu": {
+		Type:        framework.TypeString,
+		Description: "The URL of the request to generate a DPoP proof for. If specified and the exchanged token is DPoP-bound, a proof for it is returned in the dpop_proof field.",
+		Query:       true,
+	},
+	"dpop_nonce": {
+		Type:        framework.TypeString,
+		Description: "A nonce provided by the resource server to include in the DPoP proof.",
+		Query:       true,
+	},
 }
 
 const stsHelpSynopsis = `
@@ -155,9 +193,14 @@ Performs RFC 8693 token exchange for an existing credential.
 const stsHelpDescription = `
 This endpoint performs a token exchange for an already stored OAuth
 2.0 credential. Reading from a corresponding credential path under
-this endpoint performs the exchange with a requested
-urn:ietf:params:oauth:token-type:access_token token type and returns
-the resulting token in the response.
+this endpoint performs the exchange using the access token, refresh
+token, or ID token of the credential as the subject and returns the
+resulting token in the response. By default, an
+urn:ietf:params:oauth:token-type:access_token token type is
+requested. A token, or another credential that the server allows,
+can be specified as the actor for delegation. Because the parameters
+of a read are part of the URL, an actor token can only be provided by
+writing to the credential path instead.
 `
 
 func pathSTS(b *backend) *framework.Path {
@@ -169,6 +212,10 @@ func pathSTS(b *backend) *framework.Path {
 				Callback: b.stsReadOperation,
 				Summary:  "Perform a token exchange for an existing credential.",
 			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.stsReadOperation,
+				Summary:  "Perform a token exchange for an existing credential with an actor token.",
+			},
 		},
 		HelpSynopsis:    strings.TrimSpace(stsHelpSynopsis),
 		HelpDescription: strings.TrimSpace(stsHelpDescription),
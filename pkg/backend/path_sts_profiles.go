@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/logical"
+)
+
+func (b *backend) stsProfilesListOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	var profileNames []string
+
+	m := b.data.STSProfile.Manager(req.Storage)
+	err := m.ForEachSTSProfileKey(ctx, func(keyer persistence.STSProfileKeyer) error {
+		entry, err := m.ReadSTSProfileEntry(ctx, keyer)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		profileNames = append(profileNames, entry.Name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(profileNames)
+	return logical.ListResponse(profileNames), nil
+}
+
+func (b *backend) stsProfilesReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	entry, err := b.data.STSProfile.Manager(req.Storage).ReadSTSProfileEntry(ctx, persistence.STSProfileName(data.Get("name").(string)))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	rd := map[string]interface{}{
+		"server":               entry.AuthServerName,
+		"subject_token_type":   entry.SubjectTokenType,
+		"requested_token_type": entry.RequestedTokenType,
+	}
+
+	if len(entry.Scopes) > 0 {
+		rd["scopes"] = entry.Scopes
+	}
+
+	if len(entry.Audiences) > 0 {
+		rd["audiences"] = entry.Audiences
+	}
+
+	if len(entry.Resources) > 0 {
+		rd["resources"] = entry.Resources
+	}
+
+	if entry.MaximumExpirySeconds > 0 {
+		rd["maximum_expiry_seconds"] = entry.MaximumExpirySeconds
+	}
+
+	return &logical.Response{Data: rd}, nil
+}
+
+func (b *backend) stsProfilesUpdateOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	serverName, err := b.getServerNameOrDefault(ctx, req.Storage, data.Get("server").(string))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	server, err := b.data.AuthServer.Manager(req.Storage).ReadAuthServerEntry(ctx, persistence.AuthServerName(serverName))
+	if err != nil {
+		return nil, err
+	} else if server == nil {
+		return logical.ErrorResponse(ErrNoSuchServer.Error()), nil
+	}
+
+	subjectTokenType := expandTokenType(data.Get("subject_token_type").(string))
+	switch subjectTokenType {
+	case provider.TokenTypeAccessToken, provider.TokenTypeRefreshToken, provider.TokenTypeIDToken:
+	default:
+		return logical.ErrorResponse("unsupported subject token type %q", subjectTokenType), nil
+	}
+
+	requestedTokenType := expandTokenType(data.Get("requested_token_type").(string))
+	if !validTokenType(requestedTokenType) {
+		return logical.ErrorResponse("unsupported requested token type %q", requestedTokenType), nil
+	}
+
+	name := data.Get("name").(string)
+	entry := &persistence.STSProfileEntry{
+		Name:                 name,
+		AuthServerName:       serverName,
+		Scopes:               data.Get("scopes").([]string),
+		Audiences:            data.Get("audiences").([]string),
+		Resources:            data.Get("resources").([]string),
+		SubjectTokenType:     subjectTokenType,
+		RequestedTokenType:   requestedTokenType,
+		MaximumExpirySeconds: data.Get("maximum_expiry_seconds").(int),
+	}
+
+	if err := b.data.STSProfile.Manager(req.Storage).WriteSTSProfileEntry(ctx, persistence.STSProfileName(name), entry); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) stsProfilesDeleteOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	if err := b.data.STSProfile.Manager(req.Storage).DeleteSTSProfileEntry(ctx, persistence.STSProfileName(data.Get("name").(string))); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (b *backend) stsProfilesCredsReadOperation(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	profile, err := b.data.STSProfile.Manager(req.Storage).ReadSTSProfileEntry(ctx, persistence.STSProfileName(data.Get("profile").(string)))
+	if err != nil || profile == nil {
+		return nil, err
+	}
+
+	ter := &tokenExchangeRequest{
+		Scopes:             profile.Scopes,
+		Audiences:          profile.Audiences,
+		Resources:          profile.Resources,
+		SubjectTokenType:   profile.SubjectTokenType,
+		RequestedTokenType: profile.RequestedTokenType,
+	}
+
+	return b.stsExchange(ctx, req, data, ter, profile)
+}
+
+const (
+	STSProfilesPathPrefix = "sts-profiles/"
+)
+
+var stsProfilesFields = map[string]*framework.FieldSchema{
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the profile.",
+	},
+	"server": {
+		Type:        framework.TypeString,
+		Description: "The name of the authorization server that credentials exchanged with this profile must use.",
+	},
+	"scopes": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the subset of scopes to request from the authorization server.",
+	},
+	"audiences": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the target audiences for the minted token.",
+	},
+	"resources": {
+		Type:        framework.TypeCommaStringSlice,
+		Description: "Specifies the target RFC 8707 resource indicators for the minted token.",
+	},
+	"subject_token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies which token of the credential to use as the subject token. One of access_token, refresh_token, or id_token, or the corresponding RFC 8693 token type URI.",
+		Default:     "access_token",
+	},
+	"requested_token_type": {
+		Type:        framework.TypeString,
+		Description: "Specifies the RFC 8693 token type to request from the authorization server. Short names like access_token or jwt are expanded to their token type URIs. Other token types must be URNs.",
+		Default:     "access_token",
+	},
+	"maximum_expiry_seconds": {
+		Type:        framework.TypeDurationSecond,
+		Description: "Maximum lifetime in seconds of tokens exchanged with this profile. Tokens that the authorization server issues with a longer or unknown lifetime are refused.",
+	},
+}
+
+var stsProfilesCredsFields = map[string]*framework.FieldSchema{
+	"profile": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the profile.",
+	},
+	"name": {
+		Type:        framework.TypeString,
+		Description: "Specifies the name of the credential.",
+	},
+	"minimum_seconds": stsFields["minimum_seconds"],
+	"htm":             stsFields["htm"],
+	"htu":             stsFields["htu"],
+	"dpop_nonce":      stsFields["dpop_nonce"],
+}
+
+const stsProfilesHelpSynopsis = `
+Manages named token exchange profiles.
+`
+
+const stsProfilesHelpDescription = `
+This endpoint configures profiles that fix the parameters of an
+RFC 8693 token exchange. Reading from a credential path under a
+profile performs the exchange for an existing credential using the
+parameters of the profile, so access to each profile can be granted
+separately by policy.
+`
+
+const stsProfilesCredsHelpSynopsis = `
+Performs RFC 8693 token exchange for an existing credential using a profile.
+`
+
+const stsProfilesCredsHelpDescription = `
+This endpoint performs a token exchange for an already stored OAuth
+2.0 credential using the scopes, audiences, resources, and token
+types of the given profile. The credential must use the server of
+the profile. Exchanged tokens are cached separately for each profile,
+and the profile can limit how long they may be valid.
+`
+
+func pathSTSProfilesList(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: STSProfilesPathPrefix + `?$`,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ListOperation: &framework.PathOperation{
+				Callback: b.stsProfilesListOperation,
+				Summary:  "List available token exchange profile names.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(stsProfilesHelpSynopsis),
+		HelpDescription: strings.TrimSpace(stsProfilesHelpDescription),
+	}
+}
+
+func pathSTSProfiles(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: STSProfilesPathPrefix + nameRegex("name") + `$`,
+		Fields:  stsProfilesFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.stsProfilesReadOperation,
+				Summary:  "Get information about a token exchange profile.",
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.stsProfilesUpdateOperation,
+				Summary:  "Write a token exchange profile.",
+			},
+			logical.DeleteOperation: &framework.PathOperation{
+				Callback: b.stsProfilesDeleteOperation,
+				Summary:  "Remove a token exchange profile.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(stsProfilesHelpSynopsis),
+		HelpDescription: strings.TrimSpace(stsProfilesHelpDescription),
+	}
+}
+
+func pathSTSProfilesCreds(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: STSProfilesPathPrefix + nameRegex("profile") + `:/creds/` + nameRegex("name") + `$`,
+		Fields:  stsProfilesCredsFields,
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.stsProfilesCredsReadOperation,
+				Summary:  "Perform a token exchange for an existing credential using a profile.",
+			},
+		},
+		HelpSynopsis:    strings.TrimSpace(stsProfilesCredsHelpSynopsis),
+		HelpDescription: strings.TrimSpace(stsProfilesCredsHelpDescription),
+	}
+}
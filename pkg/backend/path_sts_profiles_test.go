@@ -0,0 +1,230 @@
+package backend_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSTSProfiles(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "hij",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.IncrementMockAuthCodeExchange("token_")),
+		testutil.MockWithTokenExchange(client, testutil.FilterMockTokenExchange(
+			testutil.ExpiringMockTokenExchange(testutil.IncrementMockTokenExchange("profile_"), time.Hour),
+			func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+				return assert.Equal(t, []string{"urn:audiencea"}, opts.Audiences)
+			},
+			func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+				return assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", opts.RequestedTokenType)
+			},
+		)),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configurations.
+	for _, name := range []string{"mock", "other"} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.ServersPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"client_id":     client.ID,
+				"client_secret": client.Secret,
+				"provider":      "mock",
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// Write credentials for each server.
+	for name, server := range map[string]string{"test": "mock", "elsewhere": "other"} {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server": server,
+				"code":   "test",
+			},
+		}
+
+		resp, err := b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// Write a profile.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":                 "mock",
+			"audiences":              "urn:audiencea",
+			"requested_token_type":   "jwt",
+			"maximum_expiry_seconds": "2h",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Unsupported token types and missing servers are rejected.
+	for _, data := range []map[string]interface{}{
+		{"server": "mock", "subject_token_type": "saml2"},
+		{"server": "mock", "requested_token_type": "bogus"},
+		{"server": "mock", "requested_token_type": "https://example.com/token"},
+		{"server": "missing"},
+	} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.STSProfilesPathPrefix + `invalid`,
+			Storage:   storage,
+			Data:      data,
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError(), "expected error for %v", data)
+	}
+
+	// List and read the profile.
+	req = &logical.Request{
+		Operation: logical.ListOperation,
+		Path:      backend.STSProfilesPathPrefix,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.Equal(t, []string{"audience-a"}, resp.Data["keys"])
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, "mock", resp.Data["server"])
+	assert.Equal(t, []string{"urn:audiencea"}, resp.Data["audiences"])
+	assert.Equal(t, provider.TokenTypeAccessToken, resp.Data["subject_token_type"])
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", resp.Data["requested_token_type"])
+	assert.Equal(t, 7200, resp.Data["maximum_expiry_seconds"])
+
+	// Exchange a credential using the profile.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a:/creds/test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "profile_1", resp.Data["access_token"])
+
+	// The real expiry of the token is reported.
+	expireTime, ok := resp.Data["expire_time"].(time.Time)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expireTime, time.Minute)
+
+	// The exchanged token is reused.
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "profile_1", resp.Data["access_token"])
+
+	// Tokens that outlive the maximum lifetime of a profile are refused.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.STSProfilesPathPrefix + `short`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server":                 "mock",
+			"audiences":              "urn:audiencea",
+			"requested_token_type":   "jwt",
+			"maximum_expiry_seconds": "5m",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSProfilesPathPrefix + `short:/creds/test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.EqualError(t, resp.Error(), backend.ErrExchangedTokenTooLong.Error())
+
+	// Credentials for other servers cannot use the profile.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a:/creds/elsewhere`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// Delete the profile.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSProfilesPathPrefix + `audience-a:/creds/test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+}
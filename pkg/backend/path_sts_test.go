@@ -11,6 +11,7 @@ import (
 	"github.com/openbao/openbao/sdk/v2/logical"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
 func TestLimitedExchange(t *testing.T) {
@@ -99,4 +100,217 @@ func TestLimitedExchange(t *testing.T) {
 	require.Equal(t, "limited_1", resp.Data["access_token"])
 	require.Equal(t, "Bearer", resp.Data["type"])
 	require.NotEmpty(t, resp.Data["expire_time"])
+
+	// The same parameters in a different order are served from the cache.
+	req.Data = map[string]interface{}{
+		"scopes":    "scopec,scopea",
+		"audiences": "urn:audiencec,urn:audiencea",
+		"resources": "urn:resourcec,urn:resourcea",
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "limited_1", resp.Data["access_token"])
+
+	// Unsupported token types are rejected before contacting the
+	// authorization server.
+	for _, data := range []map[string]interface{}{
+		{"requested_token_type": "foo"},
+		{"requested_token_type": "https://example.com/token"},
+		{"actor_token_type": "foo"},
+	} {
+		req.Data = data
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.NotNil(t, resp)
+		require.True(t, resp.IsError(), "expected error for %v", data)
+	}
+}
+
+func TestDelegatedExchange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "hij",
+		Secret: "def",
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, testutil.RestrictMockAuthCodeExchange(map[string]testutil.MockAuthCodeExchangeFunc{
+			"subject": testutil.IncrementMockAuthCodeExchange("subject_"),
+			"actor":   testutil.IncrementMockAuthCodeExchange("actor_"),
+		})),
+		testutil.MockWithTokenExchange(client, testutil.FilterMockTokenExchange(
+			func(_ *provider.Token, _ *provider.TokenExchangeOptions) (*provider.Token, error) {
+				return &provider.Token{
+					Token: &oauth2.Token{
+						AccessToken:  "delegated",
+						TokenType:    "N_A",
+						RefreshToken: "delegated_refresh",
+						Expiry:       time.Now().Add(2 * time.Minute),
+					},
+					ExtraData: map[string]interface{}{
+						"issued_token_type": "urn:ietf:params:oauth:token-type:jwt",
+					},
+				}, nil
+			},
+			func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+				return assert.Equal(t, provider.TokenTypeAccessToken, opts.SubjectTokenType)
+			},
+			func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+				return assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", opts.RequestedTokenType)
+			},
+			func(_ *provider.Token, opts *provider.TokenExchangeOptions) bool {
+				return assert.Equal(t, "actor_1", opts.ActorToken) &&
+					assert.Equal(t, provider.TokenTypeAccessToken, opts.ActorTokenType)
+			},
+		)),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+			"sts_actors":    "actor,missing",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+
+	// Write the subject and actor credentials.
+	for _, name := range []string{"subject", "actor"} {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + name,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server": "mock",
+				"code":   name,
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	}
+
+	// Perform a delegated exchange.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSPathPrefix + `subject`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"requested_token_type": "jwt",
+			"actor":                "actor",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "delegated", resp.Data["access_token"])
+	assert.Equal(t, "delegated_refresh", resp.Data["refresh_token"])
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", resp.Data["issued_token_type"])
+
+	// The cached token retains the additional response data.
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "delegated_refresh", resp.Data["refresh_token"])
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", resp.Data["issued_token_type"])
+
+	// The subject credential does not have an ID token.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSPathPrefix + `subject`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"subject_token_type": "id_token",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+
+	// The actor credential does not exist.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSPathPrefix + `subject`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"actor": "missing",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), `actor credential "missing" does not exist`)
+
+	// Credentials not allowed by the server cannot be used as the actor.
+	req.Data["actor"] = "subject"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.Contains(t, resp.Error().Error(), `credential "subject" is not an allowed actor for server "mock"`)
+
+	// The caller can provide the actor token instead, but not as part of the
+	// URL of a read request.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSPathPrefix + `subject`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"requested_token_type": "jwt",
+			"actor_token":          "actor_1",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.EqualError(t, resp.Error(), backend.ErrActorTokenInQuery.Error())
+
+	req.Operation = logical.UpdateOperation
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	assert.Equal(t, "delegated", resp.Data["access_token"])
+
+	// But not together with an actor credential.
+	req.Data["actor"] = "actor"
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.True(t, resp.IsError())
+	assert.EqualError(t, resp.Error(), backend.ErrActorAndActorToken.Error())
 }
@@ -2,11 +2,21 @@ package backend
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientctx"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao/sdk/v2/logical"
@@ -35,6 +45,18 @@ func providerErrorFormat(es []error) string {
 	return buf.String()
 }
 
+// clientSecretRejected determines whether another client secret might
+// succeed where the given error occurred. Errors returned by the server for
+// any other reason than a failure to authenticate the client are conclusive.
+func clientSecretRejected(err error) bool {
+	var serr *semerr.Error
+	if !errors.As(err, &serr) {
+		return true
+	}
+
+	return serr.Code == "invalid_client" || serr.Code == "unauthorized_client"
+}
+
 type multiError = multierror.Error
 
 type providerError struct {
@@ -62,91 +84,292 @@ type providerOperations struct {
 	provider provider.Provider
 }
 
-func (po *providerOperations) AuthCodeURL(state string, opts ...provider.AuthCodeURLOption) (string, bool) {
-	opts = append([]provider.AuthCodeURLOption{}, opts...)
-	opts = append(opts, provider.WithURLParams(po.entry.AuthURLParams))
+// clientContext returns a context configured to authenticate the client
+// according to the server configuration. If the client authenticates without
+// using its client secrets, the returned boolean is true.
+func (po *providerOperations) clientContext(ctx context.Context) (context.Context, bool, error) {
+	if po.entry.TLSClientCertificate != "" {
+		cert, err := tls.X509KeyPair([]byte(po.entry.TLSClientCertificate), []byte(po.entry.TLSClientKey))
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
 
-	return po.provider.Public(po.entry.ClientID).AuthCodeURL(state, opts...)
-}
+		ctx = clientctx.WithTLSClientCertificate(ctx, cert)
+	}
 
-func (po *providerOperations) DeviceCodeAuth(ctx context.Context, opts ...provider.DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
-	return po.provider.Public(po.entry.ClientID).DeviceCodeAuth(ctx, opts...)
-}
+	switch po.entry.TokenEndpointAuthMethod {
+	case persistence.TokenEndpointAuthMethodPrivateKeyJWT:
+		key, err := jwtkey.ParsePEM(po.entry.ClientPrivateKey, po.entry.ClientPrivateKeyID, po.entry.ClientPrivateKeyAlgorithm)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid client private key: %w", err)
+		}
 
-func (po *providerOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...provider.DeviceCodeExchangeOption) (*provider.Token, error) {
-	return po.provider.Public(po.entry.ClientID).DeviceCodeExchange(ctx, deviceCode, opts...)
+		return clientauth.WithAuthenticator(ctx, &clientauth.PrivateKeyJWT{
+			ClientID: po.entry.ClientID,
+			Key:      key,
+		}), true, nil
+	case persistence.TokenEndpointAuthMethodTLSClientAuth, persistence.TokenEndpointAuthMethodSelfSignedTLSClientAuth:
+		return clientauth.WithAuthenticator(ctx, &clientauth.TLSClientAuth{
+			ClientID: po.entry.ClientID,
+		}), true, nil
+	default:
+		return ctx, false, nil
+	}
 }
 
-func (po *providerOperations) RefreshToken(ctx context.Context, t *provider.Token, opts ...provider.RefreshTokenOption) (*provider.Token, error) {
-	if len(po.entry.ClientSecrets) == 0 {
-		return po.provider.Public(po.entry.ClientID).RefreshToken(ctx, t, opts...)
-	}
+// withDPoPKey returns a context that binds issued tokens to the given
+// PEM-encoded DPoP key. If the credential does not have a key yet and the
+// server requires DPoP, a new key is generated and stored in key.
+func (po *providerOperations) withDPoPKey(ctx context.Context, key *string) (context.Context, error) {
+	if *key == "" {
+		if !po.entry.DPoP {
+			return ctx, nil
+		}
 
-	err := &multierror.Error{ErrorFormat: providerErrorFormat}
-	for _, clientSecret := range po.entry.ClientSecrets {
-		rt, rerr := po.provider.Private(po.entry.ClientID, clientSecret).RefreshToken(ctx, t, opts...)
-		if rerr == nil {
-			return rt, nil
+		pk, err := dpop.GenerateKey()
+		if err != nil {
+			return nil, err
 		}
 
-		err = multierror.Append(err, rerr)
+		*key = pk
+	}
+
+	k, err := dpop.ParseKey(*key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DPoP key: %w", err)
 	}
 
-	return nil, &providerError{err}
+	return dpop.WithKey(ctx, k), nil
 }
 
-func (po *providerOperations) AuthCodeExchange(ctx context.Context, code string, opts ...provider.AuthCodeExchangeOption) (*provider.Token, error) {
-	if len(po.entry.ClientSecrets) == 0 {
-		return nil, errmark.MarkUser(provider.ErrMissingClientSecret)
-	}
+func (po *providerOperations) authCodeURLOptions(opts []provider.AuthCodeURLOption) ([]provider.AuthCodeURLOption, error) {
+	opts = append([]provider.AuthCodeURLOption{}, opts...)
+	opts = append(opts, provider.WithURLParams(po.entry.AuthURLParams))
 
-	err := &multierror.Error{ErrorFormat: providerErrorFormat}
-	for _, clientSecret := range po.entry.ClientSecrets {
-		rt, rerr := po.provider.Private(po.entry.ClientID, clientSecret).AuthCodeExchange(ctx, code, opts...)
-		if rerr == nil {
-			return rt, nil
+	if po.entry.RequestObjectSigningKey != "" {
+		// The key is validated when the server is written, but we never want
+		// to fall back to an unsigned request if it somehow cannot be used.
+		key, err := jwtkey.ParsePEM(po.entry.RequestObjectSigningKey, po.entry.RequestObjectSigningKeyID, po.entry.RequestObjectSigningAlgorithm)
+		if err != nil {
+			return nil, errmark.MarkUser(fmt.Errorf("invalid request object signing key: %w", err))
 		}
 
-		err = multierror.Append(err, rerr)
+		opts = append(opts, provider.WithRequestObjectKey{Key: key})
+	}
+
+	return opts, nil
+}
+
+func (po *providerOperations) AuthCodeURL(state string, opts ...provider.AuthCodeURLOption) (string, bool, error) {
+	opts, err := po.authCodeURLOptions(opts)
+	if err != nil {
+		return "", false, err
 	}
 
-	return nil, &providerError{err}
+	return po.provider.Public(po.entry.ClientID).AuthCodeURL(state, opts...)
 }
 
-func (po *providerOperations) ClientCredentials(ctx context.Context, opts ...provider.ClientCredentialsOption) (*provider.Token, error) {
+// withClientSecrets calls fn with the private operations of the client. If
+// the client does not authenticate using its client secrets, fn is called
+// once. Otherwise, fn is called with each client secret in turn until one is
+// accepted. Only a failure to authenticate the client is retried with the
+// next secret: any other response from the server applies to every secret,
+// and repeating the request would only replay its grant.
+//
+// If the client has no secrets, fn is called once without one unless
+// requireSecret is set, in which case ErrMissingClientSecret is returned.
+func (po *providerOperations) withClientSecrets(ctx context.Context, requireSecret bool, fn func(ctx context.Context, ops provider.PrivateOperations) error) error {
+	ctx, authenticated, err := po.clientContext(ctx)
+	if err != nil {
+		return err
+	} else if authenticated {
+		return fn(ctx, po.provider.Private(po.entry.ClientID, ""))
+	}
+
 	if len(po.entry.ClientSecrets) == 0 {
-		return nil, errmark.MarkUser(provider.ErrMissingClientSecret)
+		if requireSecret {
+			return errmark.MarkUser(provider.ErrMissingClientSecret)
+		}
+
+		// The provider decides whether a public client may perform the
+		// request.
+		return fn(ctx, po.provider.Private(po.entry.ClientID, ""))
 	}
 
-	err := &multierror.Error{ErrorFormat: providerErrorFormat}
+	merr := &multierror.Error{ErrorFormat: providerErrorFormat}
 	for _, clientSecret := range po.entry.ClientSecrets {
-		rt, rerr := po.provider.Private(po.entry.ClientID, clientSecret).ClientCredentials(ctx, opts...)
+		rerr := fn(ctx, po.provider.Private(po.entry.ClientID, clientSecret))
 		if rerr == nil {
-			return rt, nil
+			return nil
+		} else if !clientSecretRejected(rerr) {
+			return rerr
 		}
 
-		err = multierror.Append(err, rerr)
+		merr = multierror.Append(merr, rerr)
 	}
 
-	return nil, &providerError{err}
+	return &providerError{merr}
 }
 
-func (po *providerOperations) TokenExchange(ctx context.Context, t *provider.Token, opts ...provider.TokenExchangeOption) (*provider.Token, error) {
-	if len(po.entry.ClientSecrets) == 0 {
-		return nil, errmark.MarkUser(provider.ErrMissingClientSecret)
+func (po *providerOperations) LogoutURL(idTokenHint string, opts ...provider.LogoutURLOption) (string, bool) {
+	return po.provider.Public(po.entry.ClientID).LogoutURL(idTokenHint, opts...)
+}
+
+func (po *providerOperations) PKCESupported(opts ...provider.AuthCodeURLOption) bool {
+	return po.provider.Public(po.entry.ClientID).PKCESupported(opts...)
+}
+
+func (po *providerOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...provider.AuthCodeURLOption) (u string, ok bool, err error) {
+	opts, err = po.authCodeURLOptions(opts)
+	if err != nil {
+		return "", false, err
 	}
 
-	err := &multierror.Error{ErrorFormat: providerErrorFormat}
-	for _, clientSecret := range po.entry.ClientSecrets {
-		rt, rerr := po.provider.Private(po.entry.ClientID, clientSecret).TokenExchange(ctx, t, opts...)
-		if rerr == nil {
-			return rt, nil
-		}
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		u, ok, err = ops.PushedAuthCodeURL(ctx, state, opts...)
+		return
+	})
+	return
+}
+
+// DeviceCodeAuth requests a device code. Confidential clients authenticate
+// the request, while public clients only identify themselves.
+func (po *providerOperations) DeviceCodeAuth(ctx context.Context, opts ...provider.DeviceCodeAuthOption) (auth *devicecode.Auth, ok bool, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		auth, ok, err = ops.DeviceCodeAuth(ctx, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...provider.DeviceCodeExchangeOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.DeviceCodeExchange(ctx, deviceCode, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) VerifyLogoutToken(ctx context.Context, token string) (*provider.LogoutToken, bool, error) {
+	return po.provider.Public(po.entry.ClientID).VerifyLogoutToken(ctx, token)
+}
+
+func (po *providerOperations) RefreshToken(ctx context.Context, t *provider.Token, opts ...provider.RefreshTokenOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.RefreshToken(ctx, t, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) AuthCodeExchange(ctx context.Context, code string, opts ...provider.AuthCodeExchangeOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.AuthCodeExchange(ctx, code, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) ClientCredentials(ctx context.Context, opts ...provider.ClientCredentialsOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.ClientCredentials(ctx, opts...)
+		return
+	})
+	return
+}
 
-		err = multierror.Append(err, rerr)
+func (po *providerOperations) TokenExchange(ctx context.Context, t *provider.Token, opts ...provider.TokenExchangeOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.TokenExchange(ctx, t, opts...)
+		return
+	})
+	return
+}
+
+// Revoke invalidates a token at the authorization server. Public clients may
+// revoke their own tokens.
+func (po *providerOperations) Revoke(ctx context.Context, token string, opts ...provider.RevokeOption) (ok bool, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		ok, err = ops.Revoke(ctx, token, opts...)
+		return
+	})
+	return
+}
+
+// Introspect asks the authorization server for the state of a token. The
+// introspection endpoint requires client authentication.
+func (po *providerOperations) Introspect(ctx context.Context, token string, opts ...provider.IntrospectOption) (r *introspection.Result, ok bool, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		r, ok, err = ops.Introspect(ctx, token, opts...)
+		return
+	})
+	return
+}
+
+// OnBehalfOf performs a Microsoft identity platform on-behalf-of request using
+// the given token as the assertion.
+func (po *providerOperations) OnBehalfOf(ctx context.Context, t *provider.Token, opts ...provider.OnBehalfOfOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.OnBehalfOf(ctx, t, opts...)
+		return
+	})
+	return
+}
+
+// JWTBearer performs a JWT bearer authorization grant request using an
+// assertion signed by the client private key of the server. Client
+// authentication is optional for this grant.
+func (po *providerOperations) JWTBearer(ctx context.Context, opts ...provider.JWTBearerOption) (tok *provider.Token, err error) {
+	if po.entry.ClientPrivateKey == "" {
+		return nil, errmark.MarkUser(ErrMissingClientPrivateKey)
 	}
 
-	return nil, &providerError{err}
+	key, err := jwtkey.ParsePEM(po.entry.ClientPrivateKey, po.entry.ClientPrivateKeyID, po.entry.ClientPrivateKeyAlgorithm)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client private key: %w", err)
+	}
+
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.JWTBearer(ctx, key, opts...)
+		return
+	})
+	return
+}
+
+// SAML2Bearer performs a SAML 2.0 bearer assertion grant request. Client
+// authentication is optional for this grant.
+func (po *providerOperations) SAML2Bearer(ctx context.Context, assertion string, opts ...provider.SAML2BearerOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.SAML2Bearer(ctx, assertion, opts...)
+		return
+	})
+	return
+}
+
+// PasswordCredentials performs a resource owner password credentials grant
+// request. Public clients may use this grant.
+func (po *providerOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...provider.PasswordCredentialsOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, false, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.PasswordCredentials(ctx, username, password, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) BackchannelAuth(ctx context.Context, opts ...provider.BackchannelAuthOption) (auth *ciba.Auth, ok bool, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		auth, ok, err = ops.BackchannelAuth(ctx, opts...)
+		return
+	})
+	return
+}
+
+func (po *providerOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...provider.BackchannelAuthExchangeOption) (tok *provider.Token, err error) {
+	err = po.withClientSecrets(ctx, true, func(ctx context.Context, ops provider.PrivateOperations) (err error) {
+		tok, err = ops.BackchannelAuthExchange(ctx, authReqID, opts...)
+		return
+	})
+	return
 }
 
 func (b *backend) getProviderOperations(ctx context.Context, storage logical.Storage, keyer persistence.AuthServerKeyer, expiryDelta time.Duration) (*providerOperations, func(), error) {
@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/registration"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+)
+
+// mapRegistrationError marks the errors defined by RFC 7591 § 3.2.2, which
+// indicate a problem with the metadata provided by the user, as user errors.
+func mapRegistrationError(err error) error {
+	return errmark.MarkUserIf(
+		semerr.Map(err),
+		errmark.RuleAny(
+			semerr.RuleCode("invalid_redirect_uri"),
+			semerr.RuleCode("invalid_client_metadata"),
+			semerr.RuleCode("invalid_software_statement"),
+			semerr.RuleCode("unapproved_software_statement"),
+			semerr.RuleCode("invalid_token"),
+		),
+	)
+}
+
+// registerAuthServerClient registers the client of the given server entry
+// with the authorization server, filling in its client ID and secret. If the
+// previous version of the entry was registered with the same endpoint, its
+// client is updated in place instead. Any other previously registered client
+// is left alone so that it keeps working until the entry is saved.
+func (b *backend) registerAuthServerClient(ctx context.Context, prev, entry *persistence.AuthServerEntry, cfg *registration.Config, md *registration.Metadata) error {
+	if prev != nil && prev.Registration != nil {
+		if client := prev.Client(); prev.Registration.URL == cfg.RegistrationURL && client.Manageable() {
+			client, err := registration.Update(ctx, client, md)
+			if err != nil {
+				return errmap.Wrap(mapRegistrationError(err), "failed to update client registration")
+			}
+
+			entry.SetRegisteredClient(cfg.RegistrationURL, client)
+			return nil
+		}
+	}
+
+	client, err := cfg.Register(ctx, md)
+	if err != nil {
+		return errmap.Wrap(mapRegistrationError(err), "failed to register client")
+	}
+
+	entry.SetRegisteredClient(cfg.RegistrationURL, client)
+	return nil
+}
+
+// replacesRegisteredClient determines whether the client registered for one
+// version of a server entry is no longer used by another version, which may
+// be nil.
+func replacesRegisteredClient(from, to *persistence.AuthServerEntry) bool {
+	if from == nil || from.Registration == nil {
+		return false
+	}
+
+	return to == nil || to.Registration == nil || to.Registration.URL != from.Registration.URL || to.ClientID != from.ClientID
+}
+
+// deregisterAuthServerClient deletes the registered client of the given
+// server entry from the authorization server. A failure to deregister the
+// client does not prevent the server from being changed, so instead of an
+// error it returns a warning to show to the user.
+func (b *backend) deregisterAuthServerClient(ctx context.Context, entry *persistence.AuthServerEntry) string {
+	if entry.Registration == nil {
+		return ""
+	}
+
+	client := entry.Client()
+	if !client.Manageable() {
+		return "the authorization server does not support deregistering client " + client.ClientID + ", so it must be removed manually"
+	}
+
+	if err := registration.Delete(ctx, client); err != nil {
+		b.Logger().Warn("failed to deregister client", "server", entry.Name, "client_id", client.ClientID, "error", err)
+		return "failed to deregister client " + client.ClientID + ": " + errmark.MarkShort(semerr.Map(err)).Error()
+	}
+
+	return ""
+}
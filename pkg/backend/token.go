@@ -1,9 +1,15 @@
 package backend
 
 import (
+	"context"
+	"net/url"
 	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"golang.org/x/oauth2"
 )
 
@@ -26,3 +32,29 @@ func tokenExpired(clk clock.Clock, t *oauth2.Token, expiryDelta time.Duration) b
 func (b *backend) tokenValid(tok *oauth2.Token, expiryDelta time.Duration) bool {
 	return tok != nil && tok.AccessToken != "" && !tokenExpired(b.clock, tok, expiryDelta)
 }
+
+// dpopProof signs a proof for presenting the given access token to the
+// protected resource requested by the htm and htu fields. If the caller did
+// not request a proof, it returns an empty string.
+func (b *backend) dpopProof(ctx context.Context, data *framework.FieldData, key, accessToken string) (string, error) {
+	htu := data.Get("htu").(string)
+	if htu == "" {
+		return "", nil
+	} else if key == "" {
+		return "", errmark.MarkUser(ErrNotDPoPBound)
+	}
+
+	if u, err := url.Parse(htu); err != nil || !u.IsAbs() {
+		return "", errmark.MarkUser(ErrInvalidHTU)
+	}
+
+	k, err := dpop.ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return dpop.NewProof(clockctx.WithClock(ctx, b.clock), k, data.Get("htm").(string), htu, dpop.ProofOptions{
+		AccessToken: accessToken,
+		Nonce:       data.Get("dpop_nonce").(string),
+	})
+}
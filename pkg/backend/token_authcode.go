@@ -16,9 +16,49 @@ import (
 	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
 	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 	"github.com/puppetlabs/leg/timeutil/pkg/retry"
-	"golang.org/x/oauth2"
 )
 
+// exchangeAuthCode exchanges an authorization code for a token and writes the
+// given entry, updated with the issued token, as the credential for keyer.
+func (b *backend) exchangeAuthCode(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry, code string, opts ...provider.AuthCodeExchangeOption) error {
+	ops, put, err := b.getProviderOperations(ctx, storage, persistence.AuthServerName(entry.AuthServerName), defaultExpiryDelta)
+	if err != nil {
+		return fmt.Errorf("server %q has configuration problems: %w", entry.AuthServerName, err)
+	}
+	defer put()
+
+	ctx, err = ops.withDPoPKey(ctx, &entry.DPoPKey)
+	if err != nil {
+		return err
+	}
+
+	tok, err := ops.AuthCodeExchange(ctx, code, opts...)
+	if errmark.MarkedUser(err) {
+		return errmark.MarkUser(errmap.Wrap(errmark.MarkShort(err), "exchange failed"))
+	} else if err != nil {
+		return err
+	}
+
+	entry.SetToken(ctx, tok)
+
+	return b.replaceAuthCodeEntry(ctx, storage, keyer, entry)
+}
+
+// jwtBearerOptions returns the options to issue a token using the JWT bearer
+// grant with the given assertion configuration.
+func jwtBearerOptions(cfg *persistence.JWTBearerConfig, providerOptions map[string]string) []provider.JWTBearerOption {
+	return []provider.JWTBearerOption{
+		provider.WithAssertionClaims{
+			Issuer:   cfg.Issuer,
+			Subject:  cfg.Subject,
+			Audience: cfg.Audience,
+			Claims:   cfg.Claims,
+		},
+		provider.WithScopes(cfg.Scopes),
+		provider.WithProviderOptions(providerOptions),
+	}
+}
+
 type refreshProcess struct {
 	backend     *backend
 	storage     logical.Storage
@@ -111,7 +151,7 @@ func (b *backend) refreshCredToken(ctx context.Context, storage logical.Storage,
 		switch {
 		case err != nil || candidate == nil:
 			return err
-		case !candidate.TokenIssued() || b.tokenValid(candidate.Token.Token, expiryDelta) || candidate.RefreshToken == "":
+		case !candidate.TokenIssued() || b.tokenValid(candidate.Token.Token, expiryDelta) || (candidate.RefreshToken == "" && candidate.JWTBearer == nil):
 			entry = candidate
 			return nil
 		}
@@ -125,7 +165,17 @@ func (b *backend) refreshCredToken(ctx context.Context, storage logical.Storage,
 			defer put()
 
 			// Refresh.
-			refreshed, err := ops.RefreshToken(ctx, candidate.Token, provider.WithProviderOptions(candidate.ProviderOptions))
+			var refreshed *provider.Token
+			ctx, err := ops.withDPoPKey(ctx, &candidate.DPoPKey)
+			if err == nil {
+				if candidate.RefreshToken == "" {
+					// Credentials issued using the JWT bearer grant are
+					// reissued using a new assertion.
+					refreshed, err = ops.JWTBearer(ctx, jwtBearerOptions(candidate.JWTBearer, candidate.ProviderOptions)...)
+				} else {
+					refreshed, err = ops.RefreshToken(ctx, candidate.Token, provider.WithProviderOptions(candidate.ProviderOptions))
+				}
+			}
 
 			if err != nil {
 				msg := errmap.Wrap(errmark.MarkShort(err), "refresh failed").Error()
@@ -163,7 +213,7 @@ func (b *backend) getRefreshCredToken(ctx context.Context, storage logical.Stora
 	}
 }
 
-func (b *backend) storeExchangedToken(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, exchangeKey string, tok *oauth2.Token) error {
+func (b *backend) storeExchangedToken(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, exchangeKey string, tok *provider.Token) error {
 	ctx = clockctx.WithClock(ctx, b.clock)
 
 	err := b.data.AuthCode.WithLock(keyer, func(ach *persistence.LockedAuthCodeHolder) error {
@@ -176,11 +226,11 @@ func (b *backend) storeExchangedToken(ctx context.Context, storage logical.Stora
 
 		if entry.ExchangedTokens == nil {
 			// first time, make the map
-			entry.ExchangedTokens = make(map[string]*oauth2.Token)
+			entry.ExchangedTokens = make(map[string]*provider.Token)
 		} else {
 			// remove every expired exchanged token while we're here
 			for k, t := range entry.ExchangedTokens {
-				if !b.tokenValid(t, defaultExpiryDelta) {
+				if !b.tokenValid(t.Token, defaultExpiryDelta) {
 					delete(entry.ExchangedTokens, k)
 				}
 			}
@@ -30,7 +30,8 @@ func (rp *reapProcess) Description() string {
 }
 
 func (rp *reapProcess) Run(ctx context.Context) error {
-	return rp.backend.data.AuthCode.WithLock(rp.keyer, func(ch *persistence.LockedAuthCodeHolder) error {
+	var revocations []*persistence.RevocationEntry
+	err := rp.backend.data.AuthCode.WithLock(rp.keyer, func(ch *persistence.LockedAuthCodeHolder) error {
 		cm := ch.Manager(rp.storage)
 
 		entry, err := cm.ReadAuthCodeEntry(ctx)
@@ -53,8 +54,16 @@ func (rp *reapProcess) Run(ctx context.Context) error {
 		}
 
 		rp.backend.Logger().Debug("credential deleted by reaping", "key", rp.keyer.AuthCodeKey(), "cause", err)
+
+		revocations = credentialRevocations(entry.AuthServerName, entry.Token, entry.ExchangedTokens)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	rp.backend.revokeTokens(ctx, rp.storage, revocations)
+	return nil
 }
 
 type reapDescriptor struct {
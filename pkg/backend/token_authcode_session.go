@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+)
+
+const (
+	// authCodeSessionTTL is how long a user has to complete an authorization
+	// code flow started by the auth-code-url endpoint.
+	authCodeSessionTTL = 10 * time.Minute
+
+	// authCodeSessionReapInterval is how often expired authorization code
+	// sessions are removed from storage.
+	authCodeSessionReapInterval = time.Minute
+)
+
+// randomString returns a URL-safe string encoding the given number of bytes
+// from the backend's random source.
+func (b *backend) randomString(n int) (string, error) {
+	rd := make([]byte, n)
+	if _, err := b.GetRandomReader().Read(rd); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(rd), nil
+}
+
+// getAuthCodeSession retrieves the authorization code session for the given
+// state. It returns nil if the session does not exist or has expired.
+func (b *backend) getAuthCodeSession(ctx context.Context, storage logical.Storage, state string) (*persistence.AuthCodeSessionEntry, error) {
+	entry, err := b.data.AuthCodeSession.Manager(storage).ReadAuthCodeSessionEntry(ctx, persistence.AuthCodeSessionState(state))
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	if entry.Expired(clockctx.WithClock(ctx, b.clock)) {
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+// useAuthCodeSession calls fn with the authorization code session for the
+// given state while holding its lock, and deletes the session only if fn
+// succeeds. A session can therefore be used at most once, but an exchange that
+// fails, for example because the authorization server is temporarily
+// unavailable, can be retried without starting a new authorization request.
+func (b *backend) useAuthCodeSession(ctx context.Context, storage logical.Storage, state string, fn func(session *persistence.AuthCodeSessionEntry) error) error {
+	return b.data.AuthCodeSession.WithLock(persistence.AuthCodeSessionState(state), func(ch *persistence.LockedAuthCodeSessionHolder) error {
+		cm := ch.Manager(storage)
+
+		entry, err := cm.ReadAuthCodeSessionEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry == nil || entry.Expired(clockctx.WithClock(ctx, b.clock)) {
+			return errmark.MarkUser(ErrNoAuthCodeSession)
+		}
+
+		if err := fn(entry); err != nil {
+			return err
+		}
+
+		return cm.DeleteAuthCodeSessionEntry(ctx)
+	})
+}
+
+// authCodeSessionExchangeOptions returns the options to provide when
+// exchanging an authorization code issued for the given session.
+func authCodeSessionExchangeOptions(session *persistence.AuthCodeSessionEntry) []provider.AuthCodeExchangeOption {
+	return []provider.AuthCodeExchangeOption{
+		provider.WithRedirectURL(session.RedirectURL),
+		provider.WithProviderOptions(session.ProviderOptions),
+		provider.WithCodeVerifier(session.CodeVerifier),
+		provider.WithNonce(session.Nonce),
+	}
+}
+
+// authCodeSessionErrorMessage formats the error recorded in a session by the
+// callback endpoint.
+func authCodeSessionErrorMessage(session *persistence.AuthCodeSessionEntry) string {
+	if session.ErrorDescription == "" {
+		return session.Error
+	}
+
+	return fmt.Sprintf("%s: %s", session.Error, session.ErrorDescription)
+}
+
+type authCodeSessionReapProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeSessionKeyer
+}
+
+var _ scheduler.Process = &authCodeSessionReapProcess{}
+
+func (acsrp *authCodeSessionReapProcess) Description() string {
+	return fmt.Sprintf("authorization code session reap (%s)", acsrp.keyer.AuthCodeSessionKey())
+}
+
+func (acsrp *authCodeSessionReapProcess) Run(ctx context.Context) error {
+	return acsrp.backend.data.AuthCodeSession.WithLock(acsrp.keyer, func(ch *persistence.LockedAuthCodeSessionHolder) error {
+		cm := ch.Manager(acsrp.storage)
+
+		entry, err := cm.ReadAuthCodeSessionEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if !entry.Expired(clockctx.WithClock(ctx, acsrp.backend.clock)) {
+			return nil
+		}
+
+		return cm.DeleteAuthCodeSessionEntry(ctx)
+	})
+}
+
+type authCodeSessionReapDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &authCodeSessionReapDescriptor{}
+
+func (acsrd *authCodeSessionReapDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	b := backoff.Build(
+		backoff.Constant(authCodeSessionReapInterval),
+		backoff.NonSliding,
+	)
+	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		err := acsrd.backend.data.AuthCodeSession.Manager(acsrd.storage).ForEachAuthCodeSessionKey(ctx, func(keyer persistence.AuthCodeSessionKeyer) error {
+			proc := &authCodeSessionReapProcess{
+				backend: acsrd.backend,
+				storage: acsrd.storage,
+				keyer:   keyer,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+
+			return nil
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(acsrd.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
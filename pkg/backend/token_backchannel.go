@@ -0,0 +1,201 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+)
+
+const backchannelAuthExpiredUserError = "backchannel authentication request expired before the user responded"
+
+type backchannelAuthExchangeProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.AuthCodeKeyer
+}
+
+var _ scheduler.Process = &backchannelAuthExchangeProcess{}
+
+func (baep *backchannelAuthExchangeProcess) Description() string {
+	return fmt.Sprintf("backchannel authentication exchange (%s)", baep.keyer.AuthCodeKey())
+}
+
+func (baep *backchannelAuthExchangeProcess) Run(ctx context.Context) error {
+	return baep.backend.getExchangeBackchannelAuth(ctx, baep.storage, baep.keyer)
+}
+
+type backchannelAuthExchangeDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &backchannelAuthExchangeDescriptor{}
+
+func (baed *backchannelAuthExchangeDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	b := backoff.Build(
+		backoff.Constant(time.Second),
+		backoff.NonSliding,
+	)
+	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		err := baed.backend.data.AuthCode.Manager(baed.storage).ForEachBackchannelAuthKey(ctx, func(keyer persistence.AuthCodeKeyer) error {
+			proc := &backchannelAuthExchangeProcess{
+				backend: baed.backend,
+				storage: baed.storage,
+				keyer:   keyer,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+
+			return nil
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(baed.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+func (b *backend) exchangeBackchannelAuth(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	return b.data.AuthCode.WithLock(keyer, func(ch *persistence.LockedAuthCodeHolder) error {
+		cm := ch.Manager(storage)
+
+		auth, err := cm.ReadBackchannelAuthEntry(ctx)
+		if err != nil || auth == nil {
+			return err
+		}
+
+		ct, err := cm.ReadAuthCodeEntry(ctx)
+		switch {
+		case err != nil:
+			return err
+		case ct == nil || ct.TokenIssued() || ct.UserError != "":
+			// The credential was deleted or replaced, so this request is no
+			// longer needed.
+			return cm.DeleteBackchannelAuthEntry(ctx)
+		}
+
+		// The user can no longer approve an expired request, so there's no
+		// point in asking the authorization server about it.
+		if auth.Expired(ctx) {
+			ct.SetUserError(ctx, backchannelAuthExpiredUserError)
+			if err := cm.WriteAuthCodeEntry(ctx, ct); err != nil {
+				return err
+			}
+
+			return cm.DeleteBackchannelAuthEntry(ctx)
+		}
+
+		if !auth.ShouldPoll(ctx) {
+			return nil
+		}
+
+		ops, put, err := b.getProviderOperations(ctx, storage, persistence.AuthServerName(ct.AuthServerName), defaultExpiryDelta)
+		if errmark.MarkedUser(err) {
+			ct.SetAuthServerError(ctx, errmark.MarkShort(err).Error())
+		} else if err != nil {
+			return err
+		} else {
+			defer put()
+
+			ctx, err := ops.withDPoPKey(ctx, &ct.DPoPKey)
+			if err != nil {
+				return err
+			}
+
+			auth, ct, err = backchannelAuthExchange(ctx, ops, auth, ct)
+			if err != nil {
+				return err
+			}
+
+			if !ct.TokenIssued() && ct.UserError == "" {
+				if err := cm.WriteBackchannelAuthEntry(ctx, auth); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := cm.WriteAuthCodeEntry(ctx, ct); err != nil {
+			return err
+		}
+
+		if ct.TokenIssued() || ct.UserError != "" {
+			if err := cm.DeleteBackchannelAuthEntry(ctx); err != nil {
+				b.Logger().Warn("failed to clean up stale backchannel authentication request", "error", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *backend) getExchangeBackchannelAuth(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	cctx := clockctx.WithClock(ctx, b.clock)
+
+	entry, err := b.data.AuthCode.Manager(storage).ReadBackchannelAuthEntry(ctx, keyer)
+	switch {
+	case err != nil:
+		return err
+	case entry == nil:
+		return nil
+	case !entry.ShouldPoll(cctx) && !entry.Expired(cctx):
+		return nil
+	default:
+		return b.exchangeBackchannelAuth(ctx, storage, keyer)
+	}
+}
+
+func backchannelAuthExchange(ctx context.Context, ops *providerOperations, bae *persistence.BackchannelAuthEntry, ace *persistence.AuthCodeEntry) (*persistence.BackchannelAuthEntry, *persistence.AuthCodeEntry, error) {
+	tok, err := ops.BackchannelAuthExchange(
+		ctx,
+		bae.AuthReqID,
+		provider.WithProviderOptions(bae.ProviderOptions),
+	)
+	if err != nil {
+		msg := errmap.Wrap(errmark.MarkShort(err), "backchannel authentication exchange failed").Error()
+		switch {
+		case errmark.Matches(err, errmark.RuleType((*net.OpError)(nil))):
+			bae.Interval, err = deviceAuthNetworkErrorBackoff(ctx, bae.Interval)
+			if err != nil {
+				return nil, nil, err
+			}
+		case semerr.IsCode(err, "slow_down"):
+			bae.Interval += 5 // seconds
+		case semerr.IsCode(err, "authorization_pending"):
+		case errmark.MarkedUser(err):
+			ace.SetUserError(ctx, msg)
+		default:
+			ace.SetTransientError(ctx, msg)
+		}
+
+		// Pending requests do not record an error on the credential, so track
+		// the attempt here to honor the polling interval.
+		bae.LastAttemptedIssueTime = clockctx.Clock(ctx).Now()
+	} else {
+		ace.SetToken(ctx, tok)
+	}
+
+	return bae, ace, nil
+}
@@ -38,6 +38,11 @@ func (b *backend) updateClientCredsToken(ctx context.Context, storage logical.St
 		}
 		defer put()
 
+		ctx, err := ops.withDPoPKey(ctx, &candidate.DPoPKey)
+		if err != nil {
+			return err
+		}
+
 		updated, err := ops.ClientCredentials(
 			ctx,
 			provider.WithURLParams(candidate.Config.TokenURLParams),
@@ -72,3 +77,31 @@ func (b *backend) getUpdateClientCredsToken(ctx context.Context, storage logical
 		return b.updateClientCredsToken(ctx, storage, keyer, expiryDelta)
 	}
 }
+
+func (b *backend) storeClientCredsExchangedToken(ctx context.Context, storage logical.Storage, keyer persistence.ClientCredsKeyer, exchangeKey string, tok *provider.Token) error {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	return b.data.ClientCreds.WithLock(keyer, func(ch *persistence.LockedClientCredsHolder) error {
+		cm := ch.Manager(storage)
+
+		entry, err := cm.ReadClientCredsEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if entry.ExchangedTokens == nil {
+			// first time, make the map
+			entry.ExchangedTokens = make(map[string]*provider.Token)
+		} else {
+			// remove every expired exchanged token while we're here
+			for k, t := range entry.ExchangedTokens {
+				if !b.tokenValid(t.Token, defaultExpiryDelta) {
+					delete(entry.ExchangedTokens, k)
+				}
+			}
+		}
+		entry.ExchangedTokens[exchangeKey] = tok
+
+		return cm.WriteClientCredsEntry(ctx, entry)
+	})
+}
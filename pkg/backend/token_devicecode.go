@@ -19,6 +19,11 @@ import (
 	"github.com/puppetlabs/leg/timeutil/pkg/retry"
 )
 
+const (
+	deviceCodeExpiredUserError  = "device code expired before authorization completed"
+	deviceCodeCanceledUserError = "device authorization canceled"
+)
+
 type deviceCodeExchangeProcess struct {
 	backend *backend
 	storage logical.Storage
@@ -95,7 +100,18 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 			// Someone deleted the token from under us, updated it with a new
 			// request, or it was never persisted in the first place. Just delete
 			// this auth.
-			return cm.DeleteAuthCodeEntry(ctx)
+			return cm.DeleteDeviceAuthEntry(ctx)
+		}
+
+		// There's no point in asking the authorization server about a device
+		// code we know has expired.
+		if auth.Expired(ctx) {
+			ct.SetUserError(ctx, deviceCodeExpiredUserError)
+			if err := cm.WriteAuthCodeEntry(ctx, ct); err != nil {
+				return err
+			}
+
+			return cm.DeleteDeviceAuthEntry(ctx)
 		}
 
 		// Check the issue time one last time. Someone could have updated this from
@@ -113,6 +129,11 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 		} else {
 			defer put()
 
+			ctx, err := ops.withDPoPKey(ctx, &ct.DPoPKey)
+			if err != nil {
+				return err
+			}
+
 			// Perform the exchange.
 			auth, ct, err = deviceAuthExchange(ctx, ops, auth, ct)
 			if err != nil {
@@ -147,13 +168,15 @@ func (b *backend) exchangeDeviceAuth(ctx context.Context, storage logical.Storag
 }
 
 func (b *backend) getExchangeDeviceAuth(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	cctx := clockctx.WithClock(ctx, b.clock)
+
 	entry, err := b.data.AuthCode.Manager(storage).ReadDeviceAuthEntry(ctx, keyer)
 	switch {
 	case err != nil:
 		return err
 	case entry == nil:
 		return nil
-	case !entry.ShouldPoll(clockctx.WithClock(ctx, b.clock)):
+	case !entry.ShouldPoll(cctx) && !entry.Expired(cctx):
 		return nil
 	default:
 		return b.exchangeDeviceAuth(ctx, storage, keyer)
@@ -183,7 +206,10 @@ func deviceAuthExchange(ctx context.Context, ops provider.PublicOperations, dae
 			ace.SetTransientError(ctx, msg)
 		}
 
-		dae.LastAttemptedIssueTime = ace.LastAttemptedIssueTime
+		// Pending requests do not record an error on the credential, so track
+		// the attempt here to honor the polling interval.
+		dae.LastAttemptedIssueTime = clockctx.Clock(ctx).Now()
+		dae.LastPollResult = msg
 	} else {
 		ace.SetToken(ctx, tok)
 	}
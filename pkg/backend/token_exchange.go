@@ -0,0 +1,303 @@
+package backend
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/framework"
+	"github.com/openbao/openbao/sdk/v2/helper/strutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmap"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"golang.org/x/oauth2"
+)
+
+// tokenExchangeRequest holds the parameters of an RFC 8693 token exchange
+// requested using the sts/ or self-sts/ paths.
+type tokenExchangeRequest struct {
+	Scopes             []string
+	Audiences          []string
+	Resources          []string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Actor              string
+	ActorToken         string
+	ActorTokenType     string
+}
+
+func newTokenExchangeRequest(req *logical.Request, data *framework.FieldData) (*tokenExchangeRequest, error) {
+	ter := &tokenExchangeRequest{
+		Scopes:             data.Get("scopes").([]string),
+		Audiences:          data.Get("audiences").([]string),
+		Resources:          data.Get("resources").([]string),
+		SubjectTokenType:   expandTokenType(data.Get("subject_token_type").(string)),
+		RequestedTokenType: expandTokenType(data.Get("requested_token_type").(string)),
+		ActorToken:         data.Get("actor_token").(string),
+		ActorTokenType:     expandTokenType(data.Get("actor_token_type").(string)),
+	}
+
+	// Parameters of read requests are part of the URL, which is likely to be
+	// logged along the way.
+	if ter.ActorToken != "" && req.Operation == logical.ReadOperation {
+		return nil, errmark.MarkUser(ErrActorTokenInQuery)
+	}
+
+	if !validTokenType(ter.RequestedTokenType) {
+		return nil, errmark.MarkUser(fmt.Errorf("unsupported requested token type %q", ter.RequestedTokenType))
+	} else if !validTokenType(ter.ActorTokenType) {
+		return nil, errmark.MarkUser(fmt.Errorf("unsupported actor token type %q", ter.ActorTokenType))
+	}
+
+	return ter, nil
+}
+
+// cacheKeyList joins the given values for use in a cache key. The values are
+// sorted so that requests that differ only in their order share a key.
+func cacheKeyList(values []string) string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+// CacheKey returns the key to store the exchanged token under. Tokens
+// exchanged with the same parameters are interchangeable.
+func (ter *tokenExchangeRequest) CacheKey() string {
+	key := "scopes=" + cacheKeyList(ter.Scopes) +
+		",audiences=" + cacheKeyList(ter.Audiences) +
+		",resources=" + cacheKeyList(ter.Resources) +
+		",subject_token_type=" + ter.SubjectTokenType +
+		",requested_token_type=" + ter.RequestedTokenType
+	if ter.Actor != "" {
+		key += ",actor=" + ter.Actor + ",actor_token_type=" + ter.ActorTokenType
+	} else if ter.ActorToken != "" {
+		// Don't store the actor token itself with the credential.
+		key += fmt.Sprintf(",actor_token_sha256=%x,actor_token_type=%s", sha256.Sum256([]byte(ter.ActorToken)), ter.ActorTokenType)
+	}
+
+	return key
+}
+
+// tokenExchange exchanges the given subject token and returns a copy of the
+// resulting token that is suitable for caching.
+func (b *backend) tokenExchange(ctx context.Context, storage logical.Storage, ops *providerOperations, subject *provider.Token, ter *tokenExchangeRequest, providerOptions map[string]string, expiryDelta time.Duration) (*provider.Token, error) {
+	if _, ok := subject.TokenOfType(ter.SubjectTokenType); !ok {
+		return nil, errmark.MarkUser(fmt.Errorf("credential does not have a token of type %q", ter.SubjectTokenType))
+	}
+
+	actorToken := ter.ActorToken
+	if ter.Actor != "" {
+		var err error
+		actorToken, err = b.actorToken(ctx, storage, ter.Actor, ter.ActorTokenType, expiryDelta)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	exchangedTok, err := ops.TokenExchange(
+		ctx,
+		subject,
+		provider.WithScopes(ter.Scopes),
+		provider.WithAudiences(ter.Audiences),
+		provider.WithResources(ter.Resources),
+		provider.WithSubjectTokenType(ter.SubjectTokenType),
+		provider.WithRequestedTokenType(ter.RequestedTokenType),
+		provider.WithActorToken{Token: actorToken, TokenType: ter.ActorTokenType},
+		provider.WithProviderOptions(providerOptions),
+	)
+	if err != nil {
+		return nil, errmap.Wrap(err, "exchange failed")
+	}
+
+	// copy into smaller struct for caching
+	return &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  exchangedTok.Token.AccessToken,
+			TokenType:    exchangedTok.Token.TokenType,
+			RefreshToken: exchangedTok.Token.RefreshToken,
+			Expiry:       exchangedTok.Token.Expiry,
+		},
+		ExtraData: exchangedTok.ExtraData,
+	}, nil
+}
+
+// cachedTokenExchange describes an exchange of the token of a credential whose
+// result is kept with the credential until it expires.
+type cachedTokenExchange struct {
+	// AuthServerName is the server of the credential.
+	AuthServerName string
+
+	// DPoPKey is the DPoP key the token of the credential is bound to, if
+	// any. The exchanged token is bound to the same key.
+	DPoPKey string
+
+	// ExchangeKey identifies the parameters of the exchange. Tokens exchanged
+	// with the same key are interchangeable.
+	ExchangeKey string
+
+	// ExchangedTokens are the tokens previously exchanged for the
+	// credential.
+	ExchangedTokens map[string]*provider.Token
+
+	// Exchange performs the exchange and returns a copy of the resulting
+	// token that is suitable for caching.
+	Exchange func(ctx context.Context, ops *providerOperations) (*provider.Token, error)
+
+	// Store caches the exchanged token with the credential.
+	Store func(ctx context.Context, exchangeKey string, tok *provider.Token) error
+}
+
+// exchangeCachedToken returns the previously exchanged token for the given
+// exchange if it is still valid, and otherwise performs the exchange and
+// caches the result.
+func (b *backend) exchangeCachedToken(ctx context.Context, storage logical.Storage, cte *cachedTokenExchange, expiryDelta time.Duration) (*provider.Token, error) {
+	if tok, ok := cte.ExchangedTokens[cte.ExchangeKey]; ok && b.tokenValid(tok.Token, expiryDelta) {
+		return tok, nil
+	}
+
+	ops, put, err := b.getProviderOperations(ctx, storage, persistence.AuthServerName(cte.AuthServerName), defaultExpiryDelta)
+	if errmark.MarkedUser(err) {
+		return nil, errmark.MarkUser(fmt.Errorf("server %q has configuration problems: %w", cte.AuthServerName, errmark.MarkShort(err)))
+	} else if err != nil {
+		return nil, err
+	}
+	defer put()
+
+	if cte.DPoPKey != "" {
+		key := cte.DPoPKey
+		if ctx, err = ops.withDPoPKey(ctx, &key); err != nil {
+			return nil, err
+		}
+	}
+
+	tok, err := cte.Exchange(ctx, ops)
+	if err != nil {
+		return nil, err
+	} else if !b.tokenValid(tok.Token, expiryDelta) {
+		return nil, errmark.MarkUser(ErrExchangedTokenExpired)
+	}
+
+	if !tok.Expiry.IsZero() {
+		// Cache the token since it has an expiration time
+		if err := cte.Store(ctx, cte.ExchangeKey, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	return tok, nil
+}
+
+// checkTokenExchangeActor ensures that the actor of a token exchange for a
+// credential of the given server may be used. Reading the token of another
+// credential must be allowed by the server configuration because the caller
+// may not have access to that credential itself. It is checked before reusing
+// a previously exchanged token so that changes to the server configuration
+// take effect immediately.
+func (b *backend) checkTokenExchangeActor(ctx context.Context, storage logical.Storage, serverName string, ter *tokenExchangeRequest) error {
+	switch {
+	case ter.Actor != "" && ter.ActorToken != "":
+		return errmark.MarkUser(ErrActorAndActorToken)
+	case ter.Actor == "":
+		return nil
+	}
+
+	server, err := b.data.AuthServer.Manager(storage).ReadAuthServerEntry(ctx, persistence.AuthServerName(serverName))
+	if err != nil {
+		return err
+	} else if server == nil {
+		return errmark.MarkUser(ErrNoSuchServer)
+	}
+
+	if !strutil.StrListContains(server.STSActors, ter.Actor) {
+		return errmark.MarkUser(fmt.Errorf("credential %q is not an allowed actor for server %q", ter.Actor, serverName))
+	}
+
+	return nil
+}
+
+// actorToken retrieves the token of the given type from the credential that
+// acts on behalf of the subject of a delegated token exchange.
+func (b *backend) actorToken(ctx context.Context, storage logical.Storage, name, tokenType string, expiryDelta time.Duration) (string, error) {
+	entry, err := b.getRefreshCredToken(ctx, storage, persistence.AuthCodeName(name), expiryDelta)
+	switch {
+	case err != nil:
+		return "", errmark.MarkShort(err)
+	case entry == nil:
+		return "", errmark.MarkUser(fmt.Errorf("actor credential %q does not exist", name))
+	case !entry.TokenIssued() || !b.tokenValid(entry.Token.Token, expiryDelta):
+		return "", errmark.MarkUser(fmt.Errorf("actor credential %q does not have a valid token", name))
+	}
+
+	tok, ok := entry.Token.TokenOfType(tokenType)
+	if !ok {
+		return "", errmark.MarkUser(fmt.Errorf("actor credential %q does not have a token of type %q", name, tokenType))
+	}
+
+	return tok, nil
+}
+
+// tokenExchangeResponse builds the response for an exchanged token, including
+// a DPoP proof for it if requested.
+func (b *backend) tokenExchangeResponse(ctx context.Context, data *framework.FieldData, dpopKey string, tok *provider.Token) (*logical.Response, error) {
+	rd := map[string]interface{}{
+		"access_token": tok.AccessToken,
+		"type":         tok.Type(),
+	}
+
+	if !tok.Expiry.IsZero() {
+		rd["expire_time"] = tok.Expiry
+	}
+
+	if tok.RefreshToken != "" {
+		rd["refresh_token"] = tok.RefreshToken
+	}
+
+	if issuedTokenType, ok := tok.ExtraData["issued_token_type"].(string); ok {
+		rd["issued_token_type"] = issuedTokenType
+	}
+
+	if tok.Type() == dpop.TokenType {
+		proof, err := b.dpopProof(ctx, data, dpopKey, tok.AccessToken)
+		if err != nil {
+			return errorResponse(err)
+		} else if proof != "" {
+			rd["dpop_proof"] = proof
+		}
+	}
+
+	return &logical.Response{Data: rd}, nil
+}
+
+// validTokenType determines whether the given expanded token type can be sent
+// to an authorization server. Only the token types defined by RFC 8693 § 3 may
+// be given by their short names; other token types must be URNs.
+func validTokenType(tokenType string) bool {
+	const prefix = "urn:ietf:params:oauth:token-type:"
+	if name := strings.TrimPrefix(tokenType, prefix); name != tokenType {
+		switch name {
+		case "access_token", "refresh_token", "id_token", "saml1", "saml2", "jwt":
+			return true
+		default:
+			return false
+		}
+	}
+
+	return strings.HasPrefix(tokenType, "urn:")
+}
+
+// expandTokenType converts the short names of the token types defined by RFC
+// 8693 § 3, like "access_token", to their full URIs. Other values are
+// returned unchanged.
+func expandTokenType(tokenType string) string {
+	if tokenType == "" || strings.Contains(tokenType, ":") {
+		return tokenType
+	}
+
+	return "urn:ietf:params:oauth:token-type:" + tokenType
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+)
+
+const (
+	// logoutTokenMaxAge is how long after it is issued a back-channel logout
+	// token is accepted. Used tokens are remembered for this long so that they
+	// cannot be replayed.
+	logoutTokenMaxAge = 5 * time.Minute
+
+	// logoutTokenLeeway is how far in the future the issue time of a logout
+	// token may be to allow for clock skew with the authorization server.
+	logoutTokenLeeway = time.Minute
+
+	// logoutTokenReapInterval is how often records of used logout tokens are
+	// removed from storage once they are no longer needed.
+	logoutTokenReapInterval = time.Minute
+)
+
+// useLogoutToken calls fn while holding the lock for the given logout token,
+// and records the token as used only if fn succeeds. Tokens that were not
+// issued recently or that have already been used are rejected, so a token can
+// take effect at most once but an authorization server may retry delivery
+// after a failure.
+func (b *backend) useLogoutToken(ctx context.Context, storage logical.Storage, serverName string, lt *provider.LogoutToken, fn func() error) error {
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	now := b.clock.Now()
+	if lt.IssuedAt.Before(now.Add(-logoutTokenMaxAge)) || lt.IssuedAt.After(now.Add(logoutTokenLeeway)) {
+		return errmark.MarkUser(ErrLogoutTokenNotCurrent)
+	}
+
+	return b.data.LogoutToken.WithLock(persistence.LogoutTokenID(serverName, lt.ID), func(ch *persistence.LockedLogoutTokenHolder) error {
+		lm := ch.Manager(storage)
+
+		entry, err := lm.ReadLogoutTokenEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry != nil && !entry.Expired(ctx) {
+			return errmark.MarkUser(ErrLogoutTokenReplayed)
+		}
+
+		if err := fn(); err != nil {
+			return err
+		}
+
+		return lm.WriteLogoutTokenEntry(ctx, &persistence.LogoutTokenEntry{
+			AuthServerName: serverName,
+			ExpiryTime:     lt.IssuedAt.Add(logoutTokenMaxAge),
+		})
+	})
+}
+
+type logoutTokenReapProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.LogoutTokenKeyer
+}
+
+var _ scheduler.Process = &logoutTokenReapProcess{}
+
+func (ltrp *logoutTokenReapProcess) Description() string {
+	return fmt.Sprintf("logout token reap (%s)", ltrp.keyer.LogoutTokenKey())
+}
+
+func (ltrp *logoutTokenReapProcess) Run(ctx context.Context) error {
+	return ltrp.backend.data.LogoutToken.WithLock(ltrp.keyer, func(ch *persistence.LockedLogoutTokenHolder) error {
+		lm := ch.Manager(ltrp.storage)
+
+		entry, err := lm.ReadLogoutTokenEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if !entry.Expired(clockctx.WithClock(ctx, ltrp.backend.clock)) {
+			return nil
+		}
+
+		return lm.DeleteLogoutTokenEntry(ctx)
+	})
+}
+
+type logoutTokenReapDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &logoutTokenReapDescriptor{}
+
+func (ltrd *logoutTokenReapDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	b := backoff.Build(
+		backoff.Constant(logoutTokenReapInterval),
+		backoff.NonSliding,
+	)
+	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		err := ltrd.backend.data.LogoutToken.Manager(ltrd.storage).ForEachLogoutTokenKey(ctx, func(keyer persistence.LogoutTokenKeyer) error {
+			proc := &logoutTokenReapProcess{
+				backend: ltrd.backend,
+				storage: ltrd.storage,
+				keyer:   keyer,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+
+			return nil
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(ltrd.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,330 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/revocation"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/persistence"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/scheduler"
+	"github.com/puppetlabs/leg/timeutil/pkg/backoff"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"github.com/puppetlabs/leg/timeutil/pkg/retry"
+)
+
+const (
+	// revocationRetryInterval is how often the queue of failed revocations
+	// is checked for entries to retry.
+	revocationRetryInterval = 30 * time.Second
+
+	// revocationMaximumAttempts is the number of times we try to revoke a
+	// token before giving up on it.
+	revocationMaximumAttempts = 24
+
+	// revocationInlineTimeout is how long removing a credential waits for the
+	// authorization server to revoke its tokens before leaving the remaining
+	// revocations to the background.
+	revocationInlineTimeout = 5 * time.Second
+)
+
+// tokenRevocations returns the requests needed to revoke the given token at
+// the authorization server that issued it.
+func tokenRevocations(serverName string, tok *provider.Token) []*persistence.RevocationEntry {
+	if tok == nil || tok.Token == nil {
+		return nil
+	}
+
+	var res []*persistence.RevocationEntry
+
+	// RFC 7009 § 2.1: revoking the refresh token should also invalidate
+	// access tokens issued from it, so it goes first.
+	if tok.RefreshToken != "" {
+		res = append(res, &persistence.RevocationEntry{
+			AuthServerName:  serverName,
+			Token:           tok.RefreshToken,
+			TokenTypeHint:   revocation.TokenTypeHintRefreshToken,
+			ProviderOptions: tok.ProviderOptions,
+		})
+	}
+
+	if tok.AccessToken != "" {
+		res = append(res, &persistence.RevocationEntry{
+			AuthServerName:  serverName,
+			Token:           tok.AccessToken,
+			TokenTypeHint:   revocation.TokenTypeHintAccessToken,
+			ProviderOptions: tok.ProviderOptions,
+			Expiry:          tok.Expiry,
+		})
+	}
+
+	return res
+}
+
+// credentialRevocations returns the requests needed to revoke the token of a
+// credential and every token exchanged for it. Exchanged tokens may include
+// refresh tokens, so they must not simply be dropped with the credential.
+func credentialRevocations(serverName string, tok *provider.Token, exchanged map[string]*provider.Token) []*persistence.RevocationEntry {
+	res := tokenRevocations(serverName, tok)
+
+	keys := make([]string, 0, len(exchanged))
+	for key := range exchanged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		res = append(res, tokenRevocations(serverName, exchanged[key])...)
+	}
+
+	return res
+}
+
+// replacedTokenRevocations returns the requests needed to revoke the tokens of
+// a credential that is being replaced, excluding any token the replacement
+// still uses.
+func replacedTokenRevocations(serverName string, prev *provider.Token, prevExchanged map[string]*provider.Token, next *provider.Token, nextExchanged map[string]*provider.Token) []*persistence.RevocationEntry {
+	res := credentialRevocations(serverName, prev, prevExchanged)
+
+	inUse := make(map[string]struct{})
+	for _, re := range credentialRevocations(serverName, next, nextExchanged) {
+		inUse[re.Token] = struct{}{}
+	}
+
+	kept := res[:0]
+	for _, re := range res {
+		if _, found := inUse[re.Token]; !found {
+			kept = append(kept, re)
+		}
+	}
+	return kept
+}
+
+// revoke sends a single revocation request to the authorization server. It
+// only returns an error if the request should be retried.
+func (b *backend) revoke(ctx context.Context, storage logical.Storage, re *persistence.RevocationEntry) error {
+	ops, put, err := b.getProviderOperations(ctx, storage, persistence.AuthServerName(re.AuthServerName), defaultExpiryDelta)
+	if errmark.MarkedUser(err) {
+		b.Logger().Warn("unable to revoke token", "server", re.AuthServerName, "error", err)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer put()
+
+	_, err = ops.Revoke(
+		ctx,
+		re.Token,
+		provider.WithTokenTypeHint(re.TokenTypeHint),
+		provider.WithProviderOptions(re.ProviderOptions),
+	)
+	if errmark.MarkedUser(err) {
+		b.Logger().Warn("authorization server refused to revoke token", "server", re.AuthServerName, "error", err)
+		return nil
+	}
+
+	return err
+}
+
+// revokeTokens attempts to revoke each of the given tokens. Any that fail or
+// do not complete within revocationInlineTimeout are queued to be retried in
+// the background, so removing a credential is never held up for long by the
+// authorization server.
+func (b *backend) revokeTokens(ctx context.Context, storage logical.Storage, res []*persistence.RevocationEntry) {
+	if len(res) == 0 {
+		return
+	}
+
+	ctx = clockctx.WithClock(ctx, b.clock)
+
+	rctx, cancel := context.WithTimeout(ctx, revocationInlineTimeout)
+	defer cancel()
+
+	for _, re := range res {
+		err := b.revoke(rctx, storage, re)
+		if err == nil {
+			continue
+		}
+
+		re.SetError(ctx, errmark.MarkShort(err).Error())
+
+		if err := b.data.Revocation.Manager(storage).WriteRevocationEntry(ctx, persistence.RevocationToken(re.Token), re); err != nil {
+			b.Logger().Error("failed to queue token revocation", "server", re.AuthServerName, "error", err)
+		}
+	}
+}
+
+// replaceAuthCodeEntry writes the given credential and revokes the tokens of
+// the credential it replaces, if any.
+func (b *backend) replaceAuthCodeEntry(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer, entry *persistence.AuthCodeEntry) error {
+	var revocations []*persistence.RevocationEntry
+	err := b.data.AuthCode.WithLock(keyer, func(ch *persistence.LockedAuthCodeHolder) (err error) {
+		revocations, err = writeAuthCodeEntryRevocations(ctx, ch.Manager(storage), entry)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	b.revokeTokens(ctx, storage, revocations)
+	return nil
+}
+
+// deleteAuthCodeEntry deletes the given credential and revokes its tokens.
+func (b *backend) deleteAuthCodeEntry(ctx context.Context, storage logical.Storage, keyer persistence.AuthCodeKeyer) error {
+	var revocations []*persistence.RevocationEntry
+	err := b.data.AuthCode.WithLock(keyer, func(ch *persistence.LockedAuthCodeHolder) error {
+		cm := ch.Manager(storage)
+
+		entry, err := cm.ReadAuthCodeEntry(ctx)
+		if err != nil {
+			return err
+		} else if entry != nil {
+			revocations = credentialRevocations(entry.AuthServerName, entry.Token, entry.ExchangedTokens)
+		}
+
+		return cm.DeleteAuthCodeEntry(ctx)
+	})
+	if err != nil {
+		return err
+	}
+
+	b.revokeTokens(ctx, storage, revocations)
+	return nil
+}
+
+// writeAuthCodeEntryRevocations writes the given credential using a locked
+// manager and returns the revocations needed for the credential it replaces.
+func writeAuthCodeEntryRevocations(ctx context.Context, cm *persistence.LockedAuthCodeManager, entry *persistence.AuthCodeEntry) ([]*persistence.RevocationEntry, error) {
+	prev, err := cm.ReadAuthCodeEntry(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cm.WriteAuthCodeEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	if prev == nil {
+		return nil, nil
+	}
+
+	return replacedTokenRevocations(prev.AuthServerName, prev.Token, prev.ExchangedTokens, entry.Token, entry.ExchangedTokens), nil
+}
+
+// replaceClientCredsEntry writes the given client credentials entry and
+// revokes the token of the entry it replaces, if any.
+func (b *backend) replaceClientCredsEntry(ctx context.Context, storage logical.Storage, keyer persistence.ClientCredsKeyer, entry *persistence.ClientCredsEntry) error {
+	var revocations []*persistence.RevocationEntry
+	err := b.data.ClientCreds.WithLock(keyer, func(ch *persistence.LockedClientCredsHolder) error {
+		cm := ch.Manager(storage)
+
+		prev, err := cm.ReadClientCredsEntry(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := cm.WriteClientCredsEntry(ctx, entry); err != nil {
+			return err
+		}
+
+		if prev != nil {
+			revocations = replacedTokenRevocations(prev.AuthServerName, prev.Token, prev.ExchangedTokens, entry.Token, entry.ExchangedTokens)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b.revokeTokens(ctx, storage, revocations)
+	return nil
+}
+
+type revocationRetryProcess struct {
+	backend *backend
+	storage logical.Storage
+	keyer   persistence.RevocationKeyer
+}
+
+var _ scheduler.Process = &revocationRetryProcess{}
+
+func (rrp *revocationRetryProcess) Description() string {
+	return fmt.Sprintf("token revocation (%s)", rrp.keyer.RevocationKey())
+}
+
+func (rrp *revocationRetryProcess) Run(ctx context.Context) error {
+	ctx = clockctx.WithClock(ctx, rrp.backend.clock)
+
+	return rrp.backend.data.Revocation.WithLock(rrp.keyer, func(ch *persistence.LockedRevocationHolder) error {
+		rm := ch.Manager(rrp.storage)
+
+		entry, err := rm.ReadRevocationEntry(ctx)
+		if err != nil || entry == nil {
+			return err
+		}
+
+		if entry.Expired(ctx) {
+			return rm.DeleteRevocationEntry(ctx)
+		} else if !entry.ShouldRetry(ctx) {
+			return nil
+		}
+
+		err = rrp.backend.revoke(ctx, rrp.storage, entry)
+		if err == nil {
+			return rm.DeleteRevocationEntry(ctx)
+		}
+
+		entry.SetError(ctx, errmark.MarkShort(err).Error())
+		if entry.Attempts >= revocationMaximumAttempts {
+			rrp.backend.Logger().Warn("giving up on token revocation", "server", entry.AuthServerName, "attempts", entry.Attempts, "error", err)
+			return rm.DeleteRevocationEntry(ctx)
+		}
+
+		return rm.WriteRevocationEntry(ctx, entry)
+	})
+}
+
+type revocationRetryDescriptor struct {
+	backend *backend
+	storage logical.Storage
+}
+
+var _ scheduler.Descriptor = &revocationRetryDescriptor{}
+
+func (rrd *revocationRetryDescriptor) Run(ctx context.Context, pc chan<- scheduler.Process) error {
+	b := backoff.Build(
+		backoff.Constant(revocationRetryInterval),
+		backoff.NonSliding,
+	)
+	err := retry.Wait(ctx, func(ctx context.Context) (bool, error) {
+		err := rrd.backend.data.Revocation.Manager(rrd.storage).ForEachRevocationKey(ctx, func(keyer persistence.RevocationKeyer) error {
+			proc := &revocationRetryProcess{
+				backend: rrd.backend,
+				storage: rrd.storage,
+				keyer:   keyer,
+			}
+
+			select {
+			case pc <- proc:
+			case <-ctx.Done():
+			}
+
+			return nil
+		})
+		if err != nil {
+			return retry.Done(err)
+		}
+
+		return retry.Repeat(nil)
+	}, retry.WithClock(rrd.backend.clock), retry.WithBackoffFactory(b))
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
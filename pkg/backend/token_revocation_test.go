@@ -0,0 +1,257 @@
+package backend_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/backend"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock"
+	"github.com/puppetlabs/leg/timeutil/pkg/clock/k8sext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	testclock "k8s.io/utils/clock/testing"
+)
+
+type revocationRecorder struct {
+	mut     sync.Mutex
+	revoked []string
+}
+
+func (rr *revocationRecorder) Revoke(token string, opts *provider.RevokeOptions) error {
+	rr.mut.Lock()
+	defer rr.mut.Unlock()
+
+	rr.revoked = append(rr.revoked, opts.TokenTypeHint+":"+token)
+	return nil
+}
+
+func (rr *revocationRecorder) Revoked() []string {
+	rr.mut.Lock()
+	defer rr.mut.Unlock()
+
+	return append([]string(nil), rr.revoked...)
+}
+
+func refreshableMockAuthCodeExchange() testutil.MockAuthCodeExchangeFunc {
+	return testutil.AmendTokenMockAuthCodeExchange(testutil.IncrementMockAuthCodeExchange("token_"), func(t *provider.Token) error {
+		t.RefreshToken = "refresh_" + t.AccessToken
+		return nil
+	})
+}
+
+func TestRevokeOnReplaceAndDelete(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	rr := &revocationRecorder{}
+
+	exchange := func(_ *provider.Token, _ *provider.TokenExchangeOptions) (*provider.Token, error) {
+		return &provider.Token{
+			Token: &oauth2.Token{
+				AccessToken:  "exchanged",
+				RefreshToken: "exchanged_refresh",
+				Expiry:       time.Now().Add(time.Hour),
+			},
+		}, nil
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, refreshableMockAuthCodeExchange()),
+		testutil.MockWithTokenExchange(client, exchange),
+		testutil.MockWithRevoke(client, rr.Revoke),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	b, err := backend.New(backend.Options{ProviderRegistry: pr})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential twice; the second write replaces the first.
+	for i := 0; i < 2; i++ {
+		req = &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      backend.CredsPathPrefix + `test`,
+			Storage:   storage,
+			Data: map[string]interface{}{
+				"server": "mock",
+				"code":   "test",
+			},
+		}
+
+		resp, err = b.HandleRequest(ctx, req)
+		require.NoError(t, err)
+		require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+		require.Nil(t, resp)
+	}
+
+	assert.Equal(t, []string{"refresh_token:refresh_token_1", "access_token:token_1"}, rr.Revoked())
+
+	// Exchange the token of the credential. The exchanged token is cached
+	// with the credential.
+	req = &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      backend.STSPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	require.False(t, resp.IsError(), "response has error: %+v", resp.Error())
+	require.Equal(t, "exchanged", resp.Data["access_token"])
+
+	// Delete the credential.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+
+	assert.Equal(t, []string{
+		"refresh_token:refresh_token_1", "access_token:token_1",
+		"refresh_token:refresh_token_2", "access_token:token_2",
+		"refresh_token:exchanged_refresh", "access_token:exchanged",
+	}, rr.Revoked())
+}
+
+func TestRevokeRetry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client := testutil.MockClient{
+		ID:     "abc",
+		Secret: "def",
+	}
+
+	rr := &revocationRecorder{}
+
+	var attempts int
+	var attemptsMut sync.Mutex
+	revoke := func(token string, opts *provider.RevokeOptions) error {
+		attemptsMut.Lock()
+		defer attemptsMut.Unlock()
+
+		attempts++
+		if attempts <= 4 {
+			return testutil.ServiceUnavailableErrorMockRevoke(token, opts)
+		}
+
+		return rr.Revoke(token, opts)
+	}
+
+	pr := provider.NewRegistry()
+	pr.MustRegister("mock", testutil.MockFactory(
+		testutil.MockWithAuthCodeExchange(client, refreshableMockAuthCodeExchange()),
+		testutil.MockWithRevoke(client, revoke),
+	))
+
+	storage := &logical.InmemStorage{}
+
+	clk := testclock.NewFakeClock(time.Now())
+
+	b, err := backend.New(backend.Options{
+		ProviderRegistry: pr,
+		Clock: clock.NewTimerCallbackClock(
+			k8sext.NewClock(clk),
+			func(d time.Duration) {
+				clk.Step(d)
+			},
+		),
+	})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup(ctx, &logical.BackendConfig{StorageView: storage}))
+
+	// Write server configuration.
+	req := &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.ServersPathPrefix + `mock`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"client_id":     client.ID,
+			"client_secret": client.Secret,
+			"provider":      "mock",
+		},
+	}
+
+	resp, err := b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Write a valid credential.
+	req = &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"server": "mock",
+			"code":   "test",
+		},
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp != nil && resp.IsError(), "response has error: %+v", resp.Error())
+	require.Nil(t, resp)
+
+	// Deleting the credential succeeds even though the authorization server
+	// is unavailable.
+	req = &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      backend.CredsPathPrefix + `test`,
+		Storage:   storage,
+	}
+
+	resp, err = b.HandleRequest(ctx, req)
+	require.NoError(t, err)
+	require.Nil(t, resp)
+	require.Empty(t, rr.Revoked())
+
+	// The failed revocations are retried in the background.
+	require.NoError(t, b.Initialize(ctx, &logical.InitializationRequest{Storage: storage}))
+	defer b.Cleanup(ctx)
+
+	for len(rr.Revoked()) < 2 {
+		select {
+		case <-ctx.Done():
+			require.Fail(t, "context expired waiting for token revocation")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"refresh_token:refresh_token_1", "access_token:token_1"}, rr.Revoked())
+}
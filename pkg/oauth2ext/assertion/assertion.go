@@ -0,0 +1,42 @@
+// Package assertion implements the RFC 7521 framework for using assertions as
+// authorization grants.
+package assertion
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// GrantTypeJWTBearer is the RFC 7523 § 2.1 grant type for using a JWT as
+	// an authorization grant.
+	GrantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// GrantTypeSAML2Bearer is the RFC 7522 § 2.1 grant type for using a SAML
+	// 2.0 assertion as an authorization grant.
+	GrantTypeSAML2Bearer = "urn:ietf:params:oauth:grant-type:saml2-bearer"
+)
+
+type Config struct {
+	*oauth2.Config
+}
+
+// Token exchanges the given assertion for a token using the given grant type
+// as described by RFC 7521 § 4.1.
+func (c *Config) Token(ctx context.Context, grantType, assertion string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	opts = append(
+		opts,
+		oauth2.SetAuthURLParam("grant_type", grantType),
+		oauth2.SetAuthURLParam("assertion", assertion),
+	)
+
+	if len(c.Scopes) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("scope", strings.Join(c.Scopes, " ")))
+	}
+
+	// Sending an empty code is harmless and this is the only way to use the
+	// regular token endpoint while switching the grant type.
+	return c.Exchange(ctx, "", opts...)
+}
@@ -0,0 +1,91 @@
+// Package ciba implements the poll mode of OpenID Connect Client-Initiated
+// Backchannel Authentication (CIBA) Core 1.0.
+package ciba
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"golang.org/x/oauth2"
+)
+
+const (
+	GrantType = "urn:openid:params:grant-type:ciba"
+)
+
+type Auth struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int32  `json:"expires_in"`
+	Interval  int32  `json:"interval,omitempty"`
+}
+
+// AuthOptions are the parameters of an authentication request that identify
+// the end-user and describe the request to them.
+type AuthOptions struct {
+	LoginHint      string
+	BindingMessage string
+}
+
+type Config struct {
+	*oauth2.Config
+
+	BackchannelAuthURL string
+}
+
+// BackchannelAuth sends an authentication request to the backchannel
+// authentication endpoint as described by CIBA Core 1.0 § 7.1.
+func (c *Config) BackchannelAuth(ctx context.Context, opts AuthOptions) (*Auth, error) {
+	v := url.Values{
+		"scope":      {strings.Join(c.Scopes, " ")},
+		"login_hint": {opts.LoginHint},
+	}
+	if opts.BindingMessage != "" {
+		v.Set("binding_message", opts.BindingMessage)
+	}
+
+	ca := &clientauth.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		AuthStyle:    c.Endpoint.AuthStyle,
+	}
+
+	req, err := ca.NewRequest(ctx, c.BackchannelAuthURL, v)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := clientauth.Retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Auth{}
+	if err := json.Unmarshal(body, auth); err != nil {
+		return nil, err
+	}
+	switch {
+	case auth.AuthReqID == "":
+		return nil, errors.New("server response missing auth_req_id")
+	case auth.ExpiresIn <= 0:
+		return nil, errors.New("server response missing expires_in")
+	}
+
+	return auth, nil
+}
+
+// Exchange polls the token endpoint for the result of the authentication
+// request with the given ID as described by CIBA Core 1.0 § 10.1.
+func (c *Config) Exchange(ctx context.Context, authReqID string, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	opts = append(
+		opts,
+		oauth2.SetAuthURLParam("grant_type", GrantType),
+		oauth2.SetAuthURLParam("auth_req_id", authReqID),
+	)
+
+	// As with other extension grants, sending an empty code is harmless.
+	return c.Config.Exchange(ctx, "", opts...)
+}
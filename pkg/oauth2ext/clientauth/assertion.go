@@ -0,0 +1,177 @@
+package clientauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientctx"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	// ClientAssertionTypeJWTBearer is the RFC 7523 § 2.2 client assertion
+	// type.
+	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// ClientAssertionLifetime is how long a client assertion remains valid.
+	ClientAssertionLifetime = 5 * time.Minute
+)
+
+// Authenticator authenticates a client by adding parameters to the body of a
+// request to an authorization server endpoint.
+type Authenticator interface {
+	Authenticate(ctx context.Context, endpointURL string, v url.Values) error
+}
+
+type authenticatorKey struct{}
+
+// WithAuthenticator returns a context that carries the given authenticator.
+// Requests created by a Config using the returned context are authenticated
+// using it instead of the client secret.
+func WithAuthenticator(ctx context.Context, a Authenticator) context.Context {
+	return context.WithValue(ctx, authenticatorKey{}, a)
+}
+
+// AuthenticatorFromContext returns the authenticator carried by the given
+// context, if any.
+func AuthenticatorFromContext(ctx context.Context) (Authenticator, bool) {
+	a, ok := ctx.Value(authenticatorKey{}).(Authenticator)
+	return a, ok
+}
+
+// WithAuthenticatedRequests returns a context that wraps the current OAuth2
+// HTTP client so that form-encoded POST requests, like those the OAuth2
+// package makes to token endpoints, are authenticated using the given
+// authenticator.
+func WithAuthenticatedRequests(ctx context.Context, a Authenticator) context.Context {
+	return clientctx.WithUpdatedRequest(ctx, func(req *http.Request) error {
+		if req.Method != http.MethodPost || req.Body == nil || req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+			return nil
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if cerr := req.Body.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := url.ParseQuery(string(body))
+		if err != nil {
+			return err
+		}
+
+		// Requests created by NewRequest are already authenticated, but are
+		// authenticated again here so that each attempt to send a request,
+		// like a retry with a DPoP nonce, carries a new client assertion.
+		endpoint := *req.URL
+		endpoint.RawQuery = ""
+		endpoint.Fragment = ""
+
+		if err := a.Authenticate(req.Context(), endpoint.String(), v); err != nil {
+			return err
+		}
+
+		body = []byte(v.Encode())
+
+		req.ContentLength = int64(len(body))
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		return nil
+	})
+}
+
+// AssertionClaims returns the claims of an RFC 7523 § 3 client assertion for
+// the given client and audience.
+func AssertionClaims(ctx context.Context, clientID, aud string) (*jwt.Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	now := clockctx.Clock(ctx).Now()
+	return &jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{aud},
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(ClientAssertionLifetime)),
+	}, nil
+}
+
+// PrivateKeyJWT authenticates a client using a JWT signed by the client's
+// private key as described by the private_key_jwt method of OpenID Connect
+// Core 1.0 § 9.
+type PrivateKeyJWT struct {
+	ClientID string
+	Key      *jwtkey.Key
+}
+
+var _ Authenticator = &PrivateKeyJWT{}
+
+func (pkj *PrivateKeyJWT) Authenticate(ctx context.Context, endpointURL string, v url.Values) error {
+	claims, err := AssertionClaims(ctx, pkj.ClientID, endpointURL)
+	if err != nil {
+		return err
+	}
+
+	assertion, err := pkj.Key.Sign(claims)
+	if err != nil {
+		return err
+	}
+
+	v.Set("client_id", pkj.ClientID)
+	v.Del("client_secret")
+	v.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+	v.Set("client_assertion", assertion)
+	return nil
+}
+
+// ClientSecretJWT authenticates a client using a JWT signed with the client
+// secret as described by the client_secret_jwt method of OpenID Connect Core
+// 1.0 § 9.
+type ClientSecretJWT struct {
+	ClientID     string
+	ClientSecret string
+}
+
+var _ Authenticator = &ClientSecretJWT{}
+
+func (csj *ClientSecretJWT) Authenticate(ctx context.Context, endpointURL string, v url.Values) error {
+	claims, err := AssertionClaims(ctx, csj.ClientID, endpointURL)
+	if err != nil {
+		return err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.HS256, Key: []byte(csj.ClientSecret)},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return err
+	}
+
+	assertion, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return err
+	}
+
+	v.Set("client_id", csj.ClientID)
+	v.Del("client_secret")
+	v.Set("client_assertion_type", ClientAssertionTypeJWTBearer)
+	v.Set("client_assertion", assertion)
+	return nil
+}
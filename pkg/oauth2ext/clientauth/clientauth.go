@@ -0,0 +1,92 @@
+// Package clientauth makes authenticated requests to authorization server
+// endpoints that the OAuth2 package does not support directly, using the same
+// conventions the OAuth2 package uses for token requests.
+package clientauth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Config describes how a client authenticates to an authorization server.
+type Config struct {
+	ClientID     string
+	ClientSecret string
+
+	// AuthStyle determines where the client secret is sent. If it is
+	// oauth2.AuthStyleAutoDetect, the client secret is sent using HTTP Basic
+	// authentication as recommended by RFC 6749 § 2.3.1.
+	AuthStyle oauth2.AuthStyle
+}
+
+// NewRequest creates a form-encoded POST request to the given endpoint URL
+// authenticated using the client credentials.
+//
+// The client ID is always included in the request body. The client secret is
+// omitted if it is not set, allowing public clients to identify themselves.
+// If the context carries an Authenticator, it is used instead of the client
+// secret.
+func (c *Config) NewRequest(ctx context.Context, endpointURL string, v url.Values) (*http.Request, error) {
+	form := make(url.Values, len(v)+4)
+	for k, vs := range v {
+		form[k] = append([]string(nil), vs...)
+	}
+	form.Set("client_id", c.ClientID)
+
+	a, authenticated := AuthenticatorFromContext(ctx)
+
+	inHeader := !authenticated && c.ClientSecret != "" && c.AuthStyle != oauth2.AuthStyleInParams
+	if authenticated {
+		if err := a.Authenticate(ctx, endpointURL, form); err != nil {
+			return nil, err
+		}
+	} else if c.ClientSecret != "" && !inHeader {
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpointURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if inHeader {
+		req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	return req, nil
+}
+
+// Retrieve sends the given request using the OAuth2 HTTP client configured in
+// the context and returns the response body. If the server does not respond
+// with a successful status code, the error is an *oauth2.RetrieveError.
+func Retrieve(ctx context.Context, req *http.Request) ([]byte, error) {
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// This is the same restriction as used by Go's OAuth2 package for
+	// consistency.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &oauth2.RetrieveError{
+			Response: resp,
+			Body:     body,
+		}
+	}
+
+	return body, nil
+}
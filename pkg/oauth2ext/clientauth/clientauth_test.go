@@ -0,0 +1,91 @@
+package clientauth_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Config         clientauth.Config
+		ExpectedBody   url.Values
+		ExpectedHeader bool
+	}{
+		{
+			Name:           "in header",
+			Config:         clientauth.Config{ClientID: "foo", ClientSecret: "b@r", AuthStyle: oauth2.AuthStyleInHeader},
+			ExpectedBody:   url.Values{"client_id": {"foo"}, "a": {"b"}},
+			ExpectedHeader: true,
+		},
+		{
+			Name:           "auto detect",
+			Config:         clientauth.Config{ClientID: "foo", ClientSecret: "b@r"},
+			ExpectedBody:   url.Values{"client_id": {"foo"}, "a": {"b"}},
+			ExpectedHeader: true,
+		},
+		{
+			Name:         "in params",
+			Config:       clientauth.Config{ClientID: "foo", ClientSecret: "b@r", AuthStyle: oauth2.AuthStyleInParams},
+			ExpectedBody: url.Values{"client_id": {"foo"}, "client_secret": {"b@r"}, "a": {"b"}},
+		},
+		{
+			Name:         "public client",
+			Config:       clientauth.Config{ClientID: "foo", AuthStyle: oauth2.AuthStyleInHeader},
+			ExpectedBody: url.Values{"client_id": {"foo"}, "a": {"b"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			req, err := test.Config.NewRequest(context.Background(), "http://localhost/par", url.Values{"a": {"b"}})
+			require.NoError(t, err)
+
+			b, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+
+			body, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+			assert.Equal(t, test.ExpectedBody, body)
+
+			user, password, ok := req.BasicAuth()
+			assert.Equal(t, test.ExpectedHeader, ok)
+			if test.ExpectedHeader {
+				assert.Equal(t, "foo", user)
+				assert.Equal(t, "b%40r", password)
+			}
+		})
+	}
+}
+
+type staticAuthenticator struct{}
+
+func (staticAuthenticator) Authenticate(ctx context.Context, endpointURL string, v url.Values) error {
+	v.Set("client_assertion", endpointURL)
+	return nil
+}
+
+func TestNewRequestWithAuthenticator(t *testing.T) {
+	ctx := clientauth.WithAuthenticator(context.Background(), staticAuthenticator{})
+
+	cfg := clientauth.Config{ClientID: "foo", ClientSecret: "bar", AuthStyle: oauth2.AuthStyleInHeader}
+
+	req, err := cfg.NewRequest(ctx, "http://localhost/par", url.Values{"a": {"b"}})
+	require.NoError(t, err)
+
+	b, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+
+	body, err := url.ParseQuery(string(b))
+	require.NoError(t, err)
+	assert.Equal(t, url.Values{"client_id": {"foo"}, "client_assertion": {"http://localhost/par"}, "a": {"b"}}, body)
+
+	_, _, ok := req.BasicAuth()
+	assert.False(t, ok)
+}
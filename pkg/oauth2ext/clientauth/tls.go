@@ -0,0 +1,21 @@
+package clientauth
+
+import (
+	"context"
+	"net/url"
+)
+
+// TLSClientAuth authenticates a client using the certificate it presents when
+// establishing a TLS connection to the authorization server (RFC 8705 § 2).
+// The request body only identifies the client.
+type TLSClientAuth struct {
+	ClientID string
+}
+
+var _ Authenticator = &TLSClientAuth{}
+
+func (tca *TLSClientAuth) Authenticate(ctx context.Context, endpointURL string, v url.Values) error {
+	v.Set("client_id", tca.ClientID)
+	v.Del("client_secret")
+	return nil
+}
@@ -0,0 +1,49 @@
+package clientctx
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+type tlsClientCertificateKey struct{}
+
+// WithTLSClientCertificate returns a context that replaces the current OAuth2
+// HTTP client with one that presents the given certificate to servers that
+// request it, as required for RFC 8705 mutual-TLS client authentication.
+//
+// The certificate can only be added to clients that use an *http.Transport
+// (including the default client). Other transports are used unmodified.
+func WithTLSClientCertificate(ctx context.Context, cert tls.Certificate) context.Context {
+	orig := oauth2.NewClient(ctx, nil)
+
+	transport := orig.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	if t, ok := transport.(*http.Transport); ok {
+		t = t.Clone()
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+		transport = t
+	}
+
+	upd := &http.Client{}
+	*upd = *orig
+	upd.Transport = transport
+
+	ctx = context.WithValue(ctx, tlsClientCertificateKey{}, true)
+	return context.WithValue(ctx, oauth2.HTTPClient, upd)
+}
+
+// HasTLSClientCertificate returns true if the given context was created by
+// WithTLSClientCertificate.
+func HasTLSClientCertificate(ctx context.Context) bool {
+	ok, _ := ctx.Value(tlsClientCertificateKey{}).(bool)
+	return ok
+}
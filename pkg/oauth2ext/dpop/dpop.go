@@ -0,0 +1,261 @@
+// Package dpop implements RFC 9449 OAuth 2.0 Demonstrating Proof of
+// Possession (DPoP).
+package dpop
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// TokenType is the token type issued by authorization servers for
+	// DPoP-bound access tokens.
+	TokenType = "DPoP"
+
+	// HeaderName is the name of the HTTP header that carries a proof.
+	HeaderName = "DPoP"
+
+	// NonceHeaderName is the name of the HTTP header servers use to provide a
+	// nonce to include in subsequent proofs.
+	NonceHeaderName = "DPoP-Nonce"
+
+	// ProofType is the JWT type of a proof.
+	ProofType = "dpop+jwt"
+)
+
+// GenerateKey creates a new ECDSA P-256 key suitable for signing proofs and
+// returns it PEM-encoded.
+func GenerateKey() (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// ParseKey parses a PEM-encoded key created by GenerateKey.
+func ParseKey(data string) (*jwtkey.Key, error) {
+	return jwtkey.ParsePEM(data, "", "")
+}
+
+type proofClaims struct {
+	ID         string `json:"jti"`
+	Method     string `json:"htm"`
+	URI        string `json:"htu"`
+	IssuedAt   int64  `json:"iat"`
+	AccessHash string `json:"ath,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+}
+
+// ProofOptions are the optional parameters of a proof.
+type ProofOptions struct {
+	// AccessToken is the access token presented along with the proof to a
+	// protected resource.
+	AccessToken string
+
+	// Nonce is the most recent nonce provided by the server.
+	Nonce string
+}
+
+// NewProof signs a proof for a request with the given HTTP method and target
+// URI. The query and fragment of the URI are not part of the proof and are
+// removed.
+func NewProof(ctx context.Context, key *jwtkey.Key, htm, htu string, opts ProofOptions) (string, error) {
+	u, err := url.Parse(htu)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	claims := &proofClaims{
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+		Method:   strings.ToUpper(htm),
+		URI:      u.String(),
+		IssuedAt: clockctx.Clock(ctx).Now().Unix(),
+		Nonce:    opts.Nonce,
+	}
+	if opts.AccessToken != "" {
+		h := sha256.Sum256([]byte(opts.AccessToken))
+		claims.AccessHash = base64.RawURLEncoding.EncodeToString(h[:])
+	}
+
+	so := &jose.SignerOptions{EmbedJWK: true}
+	return key.SignWithOptions(claims, so.WithType(ProofType))
+}
+
+type keyKey struct{}
+
+// WithKey returns a context that carries the given proof key.
+func WithKey(ctx context.Context, key *jwtkey.Key) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// KeyFromContext returns the proof key carried by the given context, if any.
+func KeyFromContext(ctx context.Context) (*jwtkey.Key, bool) {
+	key, ok := ctx.Value(keyKey{}).(*jwtkey.Key)
+	return key, ok && key != nil
+}
+
+type roundTripper struct {
+	http.RoundTripper
+	key *jwtkey.Key
+
+	mut   sync.Mutex
+	nonce string
+}
+
+func (t *roundTripper) applies(req *http.Request) (string, bool) {
+	if scheme, tok, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, TokenType) {
+		return tok, true
+	}
+
+	return "", req.Method == http.MethodPost && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded"
+}
+
+func (t *roundTripper) roundTrip(req *http.Request, accessToken string) (*http.Response, error) {
+	t.mut.Lock()
+	nonce := t.nonce
+	t.mut.Unlock()
+
+	proof, err := NewProof(req.Context(), t.key, req.Method, req.URL.String(), ProofOptions{
+		AccessToken: accessToken,
+		Nonce:       nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(HeaderName, proof)
+
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if nonce := resp.Header.Get(NonceHeaderName); nonce != "" {
+		t.mut.Lock()
+		t.nonce = nonce
+		t.mut.Unlock()
+	}
+
+	return resp, nil
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	accessToken, ok := t.applies(req)
+	if !ok {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+
+	resp, err := t.roundTrip(req, accessToken)
+	if err != nil || resp.Header.Get(NonceHeaderName) == "" {
+		return resp, err
+	} else if req.Body != nil && req.GetBody == nil {
+		// We can't replay the request.
+		return resp, nil
+	}
+
+	// RFC 9449 § 8 and § 9: the server requires a nonce we did not have. Retry
+	// the request once with the new nonce.
+	retry, err := requiresNonce(resp)
+	if err != nil || !retry {
+		return resp, err
+	}
+	_ = resp.Body.Close()
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+
+	return t.roundTrip(req, accessToken)
+}
+
+// requiresNonce determines whether the given response is an error indicating
+// that the proof must contain a server-provided nonce. The body of the
+// response is preserved.
+func requiresNonce(resp *http.Response) (bool, error) {
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if cerr := resp.Body.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return false, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		var e struct {
+			Error string `json:"error"`
+		}
+		return json.Unmarshal(body, &e) == nil && e.Error == "use_dpop_nonce", nil
+	case http.StatusUnauthorized:
+		return strings.Contains(resp.Header.Get("WWW-Authenticate"), `error="use_dpop_nonce"`), nil
+	default:
+		return false, nil
+	}
+}
+
+// WithProofs returns a context that wraps the current OAuth2 HTTP client so
+// that form-encoded POST requests, like those the OAuth2 package makes to
+// token endpoints, and requests that present a DPoP-bound access token carry
+// a proof signed by the key in the context. If the context does not have a
+// key, it is returned unmodified.
+func WithProofs(ctx context.Context) context.Context {
+	key, ok := KeyFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	orig := oauth2.NewClient(ctx, nil)
+
+	transport := orig.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	upd := &http.Client{}
+	*upd = *orig
+	upd.Transport = &roundTripper{
+		RoundTripper: transport,
+		key:          key,
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, upd)
+}
@@ -0,0 +1,108 @@
+package dpop_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+type proofClaims struct {
+	Method     string `json:"htm"`
+	URI        string `json:"htu"`
+	AccessHash string `json:"ath"`
+	Nonce      string `json:"nonce"`
+}
+
+func verifyProof(t *testing.T, proof string) (*jose.JSONWebKey, *proofClaims) {
+	sig, err := jose.ParseSigned(proof)
+	require.NoError(t, err)
+	require.Len(t, sig.Signatures, 1)
+
+	hdr := sig.Signatures[0].Protected
+	assert.Equal(t, dpop.ProofType, hdr.ExtraHeaders[jose.HeaderType])
+	require.NotNil(t, hdr.JSONWebKey)
+	assert.True(t, hdr.JSONWebKey.IsPublic())
+
+	payload, err := sig.Verify(hdr.JSONWebKey)
+	require.NoError(t, err)
+
+	var claims proofClaims
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	return hdr.JSONWebKey, &claims
+}
+
+func TestNewProof(t *testing.T) {
+	pk, err := dpop.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := dpop.ParseKey(pk)
+	require.NoError(t, err)
+
+	proof, err := dpop.NewProof(context.Background(), key, "post", "https://api.example.com/resource?q=1#frag", dpop.ProofOptions{
+		AccessToken: "token",
+		Nonce:       "nonce",
+	})
+	require.NoError(t, err)
+
+	_, claims := verifyProof(t, proof)
+
+	ath := sha256.Sum256([]byte("token"))
+	assert.Equal(t, &proofClaims{
+		Method:     http.MethodPost,
+		URI:        "https://api.example.com/resource",
+		AccessHash: base64.RawURLEncoding.EncodeToString(ath[:]),
+		Nonce:      "nonce",
+	}, claims)
+}
+
+func TestWithProofsRetriesWithNonce(t *testing.T) {
+	pk, err := dpop.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := dpop.ParseKey(pk)
+	require.NoError(t, err)
+
+	var requests int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		_, claims := verifyProof(t, r.Header.Get(dpop.HeaderName))
+		assert.Equal(t, http.MethodPost, claims.Method)
+
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+
+		w.Header().Set("Content-Type", "application/json")
+		if claims.Nonce != "server-nonce" {
+			w.Header().Set(dpop.NonceHeaderName, "server-nonce")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"access_token":"token","token_type":"DPoP"}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	defer srv.Close()
+
+	ctx := context.Background()
+	ctx = dpop.WithProofs(dpop.WithKey(ctx, key))
+
+	resp, err := oauth2.NewClient(ctx, nil).PostForm(srv.URL, url.Values{"grant_type": {"client_credentials"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, requests)
+}
@@ -0,0 +1,69 @@
+// Package introspection implements RFC 7662 OAuth 2.0 Token Introspection.
+package introspection
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"golang.org/x/oauth2"
+)
+
+// Result is the introspection response from RFC 7662 § 2.2. Only Active is
+// required; the other members are returned at the discretion of the
+// authorization server.
+type Result struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expiry    int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+}
+
+type Config struct {
+	*oauth2.Config
+
+	IntrospectionURL string
+}
+
+// Introspect asks the authorization server for the state of the given token
+// as described by RFC 7662 § 2.1. The token type hint is optional.
+func (c *Config) Introspect(ctx context.Context, token, tokenTypeHint string) (*Result, error) {
+	v := url.Values{
+		"token": {token},
+	}
+	if tokenTypeHint != "" {
+		v.Set("token_type_hint", tokenTypeHint)
+	}
+
+	ca := &clientauth.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		AuthStyle:    c.Endpoint.AuthStyle,
+	}
+
+	req, err := ca.NewRequest(ctx, c.IntrospectionURL, v)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := clientauth.Retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The aud member may be a string or an array, so it is not decoded.
+	r := &Result{}
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
@@ -0,0 +1,107 @@
+// Package jar implements RFC 9101 JWT-Secured Authorization Requests.
+package jar
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"golang.org/x/oauth2"
+)
+
+// RequestObjectLifetime is how long a signed request object remains valid.
+const RequestObjectLifetime = 5 * time.Minute
+
+type Config struct {
+	*oauth2.Config
+
+	// Key signs the request object.
+	Key *jwtkey.Key
+
+	// Audience identifies the authorization server. It should be the issuer
+	// identifier of the server.
+	Audience string
+
+	// Now returns the current time. If not specified, time.Now is used.
+	Now func() time.Time
+}
+
+func (c *Config) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+
+	return time.Now()
+}
+
+// RequestObject signs the given authorization request parameters into a
+// request object.
+func (c *Config) RequestObject(v url.Values) (string, error) {
+	claims := make(map[string]interface{}, len(v)+5)
+	for k, vs := range v {
+		if len(vs) == 1 {
+			claims[k] = vs[0]
+		} else {
+			claims[k] = vs
+		}
+	}
+
+	now := c.now()
+	claims["iss"] = c.ClientID
+	claims["aud"] = c.Audience
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = now.Add(RequestObjectLifetime).Unix()
+
+	return c.Key.Sign(claims)
+}
+
+// Values returns the parameters to send to the authorization server in place
+// of the given authorization request parameters.
+//
+// In addition to the client ID and signed request, the response type and
+// scope are duplicated outside of the request object for compatibility with
+// OpenID Connect Core 1.0 § 6.1.
+func (c *Config) Values(v url.Values) (url.Values, error) {
+	req, err := c.RequestObject(v)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := url.Values{
+		"client_id": {c.ClientID},
+		"request":   {req},
+	}
+	for _, k := range []string{"response_type", "scope"} {
+		if vs, ok := v[k]; ok {
+			rv[k] = vs
+		}
+	}
+
+	return rv, nil
+}
+
+// AuthCodeURL returns an authorization code URL that carries the parameters
+// the underlying configuration would generate in a signed request object.
+func (c *Config) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	u, err := url.Parse(c.Config.AuthCodeURL(state, opts...))
+	if err != nil {
+		return "", err
+	}
+
+	v, err := c.Values(u.Query())
+	if err != nil {
+		return "", err
+	}
+
+	var buf strings.Builder
+	buf.WriteString(c.Endpoint.AuthURL)
+	if strings.Contains(c.Endpoint.AuthURL, "?") {
+		buf.WriteByte('&')
+	} else {
+		buf.WriteByte('?')
+	}
+	buf.WriteString(v.Encode())
+	return buf.String(), nil
+}
@@ -0,0 +1,149 @@
+// Package jwtkey provides helpers for signing JWTs with keys configured by
+// users as PEM-encoded private keys.
+package jwtkey
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+var (
+	ErrNoPEMData          = errors.New("no PEM data found")
+	ErrUnsupportedKeyType = errors.New("unsupported key type")
+)
+
+// Key is a private key used to sign JWTs.
+type Key struct {
+	Signer    crypto.Signer
+	KeyID     string
+	Algorithm jose.SignatureAlgorithm
+}
+
+// Sign creates a compact JWT containing the given claims.
+func (k *Key) Sign(claims interface{}) (string, error) {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if k.KeyID != "" {
+		opts = opts.WithHeader(jose.HeaderKey("kid"), k.KeyID)
+	}
+
+	return k.SignWithOptions(claims, opts)
+}
+
+// SignWithOptions creates a compact JWT containing the given claims using the
+// given signer options.
+func (k *Key) SignWithOptions(claims interface{}, opts *jose.SignerOptions) (string, error) {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: k.Algorithm, Key: k.Signer}, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return jwt.Signed(signer).Claims(claims).CompactSerialize()
+}
+
+// Public returns the public part of this key as a JWK.
+func (k *Key) Public() *jose.JSONWebKey {
+	return &jose.JSONWebKey{
+		Key:       k.Signer.Public(),
+		KeyID:     k.KeyID,
+		Algorithm: string(k.Algorithm),
+		Use:       "sig",
+	}
+}
+
+// ParsePEM parses a PEM-encoded RSA, ECDSA, or Ed25519 private key. If alg is
+// empty, a default algorithm is selected based on the type of the key.
+func ParsePEM(data, keyID, alg string) (*Key, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, ErrNoPEMData
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	algorithm := jose.SignatureAlgorithm(alg)
+	if algorithm == "" {
+		algorithm, err = DefaultAlgorithm(signer)
+		if err != nil {
+			return nil, err
+		}
+	} else if err := checkAlgorithm(signer, algorithm); err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		Signer:    signer,
+		KeyID:     keyID,
+		Algorithm: algorithm,
+	}, nil
+}
+
+// DefaultAlgorithm returns the signature algorithm to use for a key when one
+// is not explicitly configured.
+func DefaultAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	}
+
+	return "", ErrUnsupportedKeyType
+}
+
+func checkAlgorithm(key crypto.Signer, alg jose.SignatureAlgorithm) error {
+	var ok bool
+	switch alg {
+	case jose.RS256, jose.RS384, jose.RS512, jose.PS256, jose.PS384, jose.PS512:
+		_, ok = key.(*rsa.PrivateKey)
+	case jose.ES256, jose.ES384, jose.ES512:
+		var want jose.SignatureAlgorithm
+		if k, isEC := key.(*ecdsa.PrivateKey); isEC {
+			want, _ = DefaultAlgorithm(k)
+		}
+		ok = want == alg
+	case jose.EdDSA:
+		_, ok = key.(ed25519.PrivateKey)
+	}
+
+	if !ok {
+		return fmt.Errorf("algorithm %q cannot be used with the given key", alg)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package jwtkey_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestParsePEM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		Name              string
+		PEM               *pem.Block
+		Algorithm         string
+		ExpectedPublic    crypto.PublicKey
+		ExpectedAlgorithm jose.SignatureAlgorithm
+		ExpectedError     string
+	}{
+		{
+			Name:              "RSA",
+			PEM:               &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			ExpectedPublic:    &rsaKey.PublicKey,
+			ExpectedAlgorithm: jose.RS256,
+		},
+		{
+			Name:              "RSA with explicit algorithm",
+			PEM:               &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+			Algorithm:         "PS256",
+			ExpectedPublic:    &rsaKey.PublicKey,
+			ExpectedAlgorithm: jose.PS256,
+		},
+		{
+			Name:              "ECDSA",
+			PEM:               &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+			ExpectedPublic:    &ecKey.PublicKey,
+			ExpectedAlgorithm: jose.ES384,
+		},
+		{
+			Name:          "ECDSA with mismatched curve",
+			PEM:           &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+			Algorithm:     "ES256",
+			ExpectedError: `algorithm "ES256" cannot be used with the given key`,
+		},
+		{
+			Name:              "Ed25519",
+			PEM:               &pem.Block{Type: "PRIVATE KEY", Bytes: edDER},
+			ExpectedPublic:    edKey.Public(),
+			ExpectedAlgorithm: jose.EdDSA,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			key, err := jwtkey.ParsePEM(string(pem.EncodeToMemory(test.PEM)), "k1", test.Algorithm)
+			if test.ExpectedError != "" {
+				assert.EqualError(t, err, test.ExpectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.ExpectedAlgorithm, key.Algorithm)
+
+			raw, err := key.Sign(jwt.Claims{Subject: "test"})
+			require.NoError(t, err)
+
+			tok, err := jwt.ParseSigned(raw)
+			require.NoError(t, err)
+			assert.Equal(t, "k1", tok.Headers[0].KeyID)
+
+			var claims jwt.Claims
+			require.NoError(t, tok.Claims(test.ExpectedPublic, &claims))
+			assert.Equal(t, "test", claims.Subject)
+		})
+	}
+
+	_, err = jwtkey.ParsePEM("not a key", "", "")
+	assert.ErrorIs(t, err, jwtkey.ErrNoPEMData)
+}
@@ -0,0 +1,90 @@
+// Package par implements RFC 9126 OAuth 2.0 Pushed Authorization Requests.
+package par
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"golang.org/x/oauth2"
+)
+
+type Response struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int32  `json:"expires_in"`
+}
+
+type Config struct {
+	*oauth2.Config
+
+	PushedAuthURL string
+}
+
+// Push sends the given authorization request parameters to the pushed
+// authorization request endpoint.
+func (c *Config) Push(ctx context.Context, v url.Values) (*Response, error) {
+	ca := &clientauth.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		AuthStyle:    c.Endpoint.AuthStyle,
+	}
+
+	req, err := ca.NewRequest(ctx, c.PushedAuthURL, v)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := clientauth.Retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &Response{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, err
+	}
+	if resp.RequestURI == "" {
+		return nil, errors.New("server response missing request_uri")
+	}
+
+	return resp, nil
+}
+
+// AuthCodeURL pushes the parameters of the authorization code URL that the
+// underlying configuration would generate and returns a URL that references
+// them using the issued request URI.
+func (c *Config) AuthCodeURL(ctx context.Context, state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	u, err := url.Parse(c.Config.AuthCodeURL(state, opts...))
+	if err != nil {
+		return "", err
+	}
+
+	return c.AuthCodeURLFromValues(ctx, u.Query())
+}
+
+// AuthCodeURLFromValues pushes the given authorization request parameters and
+// returns a URL that references them using the issued request URI.
+func (c *Config) AuthCodeURLFromValues(ctx context.Context, v url.Values) (string, error) {
+	resp, err := c.Push(ctx, v)
+	if err != nil {
+		return "", err
+	}
+
+	rv := url.Values{
+		"client_id":   {c.ClientID},
+		"request_uri": {resp.RequestURI},
+	}
+
+	var buf strings.Builder
+	buf.WriteString(c.Endpoint.AuthURL)
+	if strings.Contains(c.Endpoint.AuthURL, "?") {
+		buf.WriteByte('&')
+	} else {
+		buf.WriteByte('?')
+	}
+	buf.WriteString(rv.Encode())
+	return buf.String(), nil
+}
@@ -0,0 +1,157 @@
+// Package registration implements RFC 7591 OAuth 2.0 Dynamic Client
+// Registration and the RFC 7592 client configuration endpoint.
+package registration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+// Metadata is the subset of the client metadata defined by RFC 7591 § 2 that
+// this package supports.
+type Metadata struct {
+	RedirectURIs            []string            `json:"redirect_uris,omitempty"`
+	GrantTypes              []string            `json:"grant_types,omitempty"`
+	ResponseTypes           []string            `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string              `json:"token_endpoint_auth_method,omitempty"`
+	ClientName              string              `json:"client_name,omitempty"`
+	Scope                   string              `json:"scope,omitempty"`
+	JWKS                    *jose.JSONWebKeySet `json:"jwks,omitempty"`
+}
+
+// Client is the information the authorization server returns about a
+// registered client as described by RFC 7591 § 3.2.1 and RFC 7592 § 3.
+type Client struct {
+	Metadata
+
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at,omitempty"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+}
+
+// Manageable returns true if the authorization server provided the means to
+// update and delete this client using the client configuration endpoint.
+func (c *Client) Manageable() bool {
+	return c.RegistrationAccessToken != "" && c.RegistrationClientURI != ""
+}
+
+type Config struct {
+	RegistrationURL string
+
+	// InitialAccessToken is an optional bearer token that authorizes the
+	// registration request, as described by RFC 7591 § 3.
+	InitialAccessToken string
+}
+
+// Register creates a new client with the given metadata as described by RFC
+// 7591 § 3.1.
+func (c *Config) Register(ctx context.Context, md *Metadata) (*Client, error) {
+	return do(ctx, http.MethodPost, c.RegistrationURL, c.InitialAccessToken, md)
+}
+
+// Update replaces the metadata of the given client as described by RFC 7592 §
+// 2.2. The returned client may contain a new client secret or registration
+// access token.
+func Update(ctx context.Context, client *Client, md *Metadata) (*Client, error) {
+	if !client.Manageable() {
+		return nil, errors.New("client does not support configuration updates")
+	}
+
+	// The request must contain the client identifier and, if present, the
+	// current client secret.
+	req := &Client{
+		Metadata:     *md,
+		ClientID:     client.ClientID,
+		ClientSecret: client.ClientSecret,
+	}
+
+	upd, err := do(ctx, http.MethodPut, client.RegistrationClientURI, client.RegistrationAccessToken, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 7592 § 3: the server may omit the registration access token and
+	// client configuration URI if they have not changed.
+	if upd.RegistrationAccessToken == "" {
+		upd.RegistrationAccessToken = client.RegistrationAccessToken
+	}
+	if upd.RegistrationClientURI == "" {
+		upd.RegistrationClientURI = client.RegistrationClientURI
+	}
+	if upd.ClientSecret == "" {
+		upd.ClientSecret = client.ClientSecret
+		upd.ClientSecretExpiresAt = client.ClientSecretExpiresAt
+	}
+
+	return upd, nil
+}
+
+// Delete deregisters the given client as described by RFC 7592 § 2.3.
+func Delete(ctx context.Context, client *Client) error {
+	if !client.Manageable() {
+		return errors.New("client does not support deregistration")
+	}
+
+	req, err := newRequest(ctx, http.MethodDelete, client.RegistrationClientURI, client.RegistrationAccessToken, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = clientauth.Retrieve(ctx, req)
+	return err
+}
+
+func newRequest(ctx context.Context, method, endpointURL, token string, body interface{}) (*http.Request, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpointURL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+func do(ctx context.Context, method, endpointURL, token string, body interface{}) (*Client, error) {
+	req, err := newRequest(ctx, method, endpointURL, token, body)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := clientauth.Retrieve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &Client{}
+	if err := json.Unmarshal(b, client); err != nil {
+		return nil, err
+	} else if client.ClientID == "" {
+		return nil, errors.New("server response missing client_id")
+	}
+
+	return client, nil
+}
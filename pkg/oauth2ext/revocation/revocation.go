@@ -0,0 +1,52 @@
+// Package revocation implements RFC 7009 OAuth 2.0 Token Revocation.
+package revocation
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// TokenTypeHintAccessToken and TokenTypeHintRefreshToken are the token
+	// type hints defined by RFC 7009 § 2.1.
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+type Config struct {
+	*oauth2.Config
+
+	RevocationURL string
+}
+
+// Revoke asks the authorization server to invalidate the given token as
+// described by RFC 7009 § 2.1. The token type hint is optional.
+//
+// The authorization server responds successfully even if the token was
+// already invalid, so a nil error does not indicate that the token was
+// active.
+func (c *Config) Revoke(ctx context.Context, token, tokenTypeHint string) error {
+	v := url.Values{
+		"token": {token},
+	}
+	if tokenTypeHint != "" {
+		v.Set("token_type_hint", tokenTypeHint)
+	}
+
+	ca := &clientauth.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		AuthStyle:    c.Endpoint.AuthStyle,
+	}
+
+	req, err := ca.NewRequest(ctx, c.RevocationURL, v)
+	if err != nil {
+		return err
+	}
+
+	_, err = clientauth.Retrieve(ctx, req)
+	return err
+}
@@ -15,12 +15,12 @@ import (
 	"github.com/openbao/openbao/sdk/v2/helper/locksutil"
 	"github.com/openbao/openbao/sdk/v2/logical"
 	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
-	"golang.org/x/oauth2"
 )
 
 const (
-	authCodeKeyPrefix   = "creds/"
-	deviceAuthKeyPrefix = "devices/"
+	authCodeKeyPrefix        = "creds/"
+	deviceAuthKeyPrefix      = "devices/"
+	BackchannelAuthKeyPrefix = "backchannel/"
 )
 
 type AuthCodeKeyer interface {
@@ -30,6 +30,10 @@ type AuthCodeKeyer interface {
 	// DeviceAuthKey returns the storage key for storing DeviceAuthEntry
 	// objects.
 	DeviceAuthKey() string
+
+	// BackchannelAuthKey returns the storage key for storing
+	// BackchannelAuthEntry objects.
+	BackchannelAuthKey() string
 }
 
 type AuthCodeEntry struct {
@@ -45,6 +49,15 @@ type AuthCodeEntry struct {
 	// MaximumExpirySeconds caps issued auth tokens to a desired lifetime.
 	MaximumExpirySeconds int `json:"maximum_expiry_seconds,omitempty"`
 
+	// DPoPKey is the PEM-encoded private key that tokens for this entry are
+	// bound to using RFC 9449 DPoP, if any.
+	DPoPKey string `json:"dpop_key,omitempty"`
+
+	// JWTBearer is the configuration of the assertion used to issue tokens for
+	// this entry if it was created using the RFC 7523 JWT bearer grant. Tokens
+	// for such entries are reissued when they expire.
+	JWTBearer *JWTBearerConfig `json:"jwt_bearer,omitempty"`
+
 	// LastIssueTime is the most recent time a token was successfully issued.
 	LastIssueTime time.Time `json:"last_issue_time,omitempty"`
 
@@ -71,7 +84,15 @@ type AuthCodeEntry struct {
 	LastAttemptedIssueTime time.Time `json:"last_attempted_issue_time,omitempty"`
 
 	// Cache of successfully exchanged tokens
-	ExchangedTokens map[string]*oauth2.Token `json:"exchanged_tokens"`
+	ExchangedTokens map[string]*provider.Token `json:"exchanged_tokens"`
+}
+
+type JWTBearerConfig struct {
+	Issuer   string                 `json:"issuer,omitempty"`
+	Subject  string                 `json:"subject,omitempty"`
+	Audience string                 `json:"audience,omitempty"`
+	Claims   map[string]interface{} `json:"claims,omitempty"`
+	Scopes   []string               `json:"scopes,omitempty"`
 }
 
 func (ace *AuthCodeEntry) SetToken(ctx context.Context, tok *provider.Token) {
@@ -122,18 +143,62 @@ type DeviceAuthEntry struct {
 	Interval               int32             `json:"interval"`
 	LastAttemptedIssueTime time.Time         `json:"last_attempted_issue_time"`
 	ProviderOptions        map[string]string `json:"provider_options"`
+
+	// UserCode, VerificationURI, and VerificationURIComplete are the
+	// information the end-user needs to authorize the device. They are only
+	// present if the device code was requested by this plugin.
+	UserCode                string `json:"user_code,omitempty"`
+	VerificationURI         string `json:"verification_uri,omitempty"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+
+	// ExpireTime is the time the device code expires, if known. We stop
+	// polling after this time.
+	ExpireTime time.Time `json:"expire_time,omitempty"`
+
+	// LastPollResult describes the outcome of the most recent unsuccessful
+	// exchange attempt.
+	LastPollResult string `json:"last_poll_result,omitempty"`
 }
 
 func (dae *DeviceAuthEntry) ShouldPoll(ctx context.Context) bool {
 	return dae.LastAttemptedIssueTime.Add(time.Duration(dae.Interval) * time.Second).Before(clockctx.Clock(ctx).Now())
 }
 
+// Expired indicates whether the device code is known to have expired.
+func (dae *DeviceAuthEntry) Expired(ctx context.Context) bool {
+	return !dae.ExpireTime.IsZero() && !clockctx.Clock(ctx).Now().Before(dae.ExpireTime)
+}
+
+// BackchannelAuthEntry tracks an OpenID Connect CIBA authentication request
+// that is polled until the end-user approves or denies it.
+type BackchannelAuthEntry struct {
+	AuthReqID              string            `json:"auth_req_id"`
+	Interval               int32             `json:"interval"`
+	LastAttemptedIssueTime time.Time         `json:"last_attempted_issue_time"`
+	ProviderOptions        map[string]string `json:"provider_options"`
+
+	// ExpireTime is the time the authentication request expires. We stop
+	// polling after this time.
+	ExpireTime time.Time `json:"expire_time,omitempty"`
+}
+
+func (bae *BackchannelAuthEntry) ShouldPoll(ctx context.Context) bool {
+	return bae.LastAttemptedIssueTime.Add(time.Duration(bae.Interval) * time.Second).Before(clockctx.Clock(ctx).Now())
+}
+
+// Expired indicates whether the authentication request is known to have
+// expired.
+func (bae *BackchannelAuthEntry) Expired(ctx context.Context) bool {
+	return !bae.ExpireTime.IsZero() && !clockctx.Clock(ctx).Now().Before(bae.ExpireTime)
+}
+
 type AuthCodeKey string
 
 var _ AuthCodeKeyer = AuthCodeKey("")
 
-func (ack AuthCodeKey) AuthCodeKey() string   { return authCodeKeyPrefix + string(ack) }
-func (ack AuthCodeKey) DeviceAuthKey() string { return deviceAuthKeyPrefix + string(ack) }
+func (ack AuthCodeKey) AuthCodeKey() string        { return authCodeKeyPrefix + string(ack) }
+func (ack AuthCodeKey) DeviceAuthKey() string      { return deviceAuthKeyPrefix + string(ack) }
+func (ack AuthCodeKey) BackchannelAuthKey() string { return BackchannelAuthKeyPrefix + string(ack) }
 
 func AuthCodeName(name string) AuthCodeKeyer {
 	hash := sha1.Sum([]byte(name))
@@ -184,6 +249,22 @@ func (lacm *LockedAuthCodeManager) ReadDeviceAuthEntry(ctx context.Context) (*De
 	return entry, nil
 }
 
+func (lacm *LockedAuthCodeManager) ReadBackchannelAuthEntry(ctx context.Context) (*BackchannelAuthEntry, error) {
+	se, err := lacm.storage.Get(ctx, lacm.keyer.BackchannelAuthKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &BackchannelAuthEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
 func (lacm *LockedAuthCodeManager) WriteAuthCodeEntry(ctx context.Context, entry *AuthCodeEntry) error {
 	se, err := logical.StorageEntryJSON(lacm.keyer.AuthCodeKey(), entry)
 	if err != nil {
@@ -202,6 +283,15 @@ func (lacm *LockedAuthCodeManager) WriteDeviceAuthEntry(ctx context.Context, ent
 	return lacm.storage.Put(ctx, se)
 }
 
+func (lacm *LockedAuthCodeManager) WriteBackchannelAuthEntry(ctx context.Context, entry *BackchannelAuthEntry) error {
+	se, err := logical.StorageEntryJSON(lacm.keyer.BackchannelAuthKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacm.storage.Put(ctx, se)
+}
+
 func (lacm *LockedAuthCodeManager) DeleteAuthCodeEntry(ctx context.Context) error {
 	return lacm.storage.Delete(ctx, lacm.keyer.AuthCodeKey())
 }
@@ -210,6 +300,10 @@ func (lacm *LockedAuthCodeManager) DeleteDeviceAuthEntry(ctx context.Context) er
 	return lacm.storage.Delete(ctx, lacm.keyer.DeviceAuthKey())
 }
 
+func (lacm *LockedAuthCodeManager) DeleteBackchannelAuthEntry(ctx context.Context) error {
+	return lacm.storage.Delete(ctx, lacm.keyer.BackchannelAuthKey())
+}
+
 type LockedAuthCodeHolder struct {
 	keyer AuthCodeKeyer
 }
@@ -248,6 +342,15 @@ func (acm *AuthCodeManager) ReadDeviceAuthEntry(ctx context.Context, keyer AuthC
 	return entry, err
 }
 
+func (acm *AuthCodeManager) ReadBackchannelAuthEntry(ctx context.Context, keyer AuthCodeKeyer) (*BackchannelAuthEntry, error) {
+	var entry *BackchannelAuthEntry
+	err := acm.locker.WithLock(keyer, func(lach *LockedAuthCodeHolder) (err error) {
+		entry, err = lach.Manager(acm.storage).ReadBackchannelAuthEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
 func (acm *AuthCodeManager) WriteAuthCodeEntry(ctx context.Context, keyer AuthCodeKeyer, entry *AuthCodeEntry) error {
 	return acm.locker.WithLock(keyer, func(lach *LockedAuthCodeHolder) error {
 		return lach.Manager(acm.storage).WriteAuthCodeEntry(ctx, entry)
@@ -282,6 +385,11 @@ func (acm *AuthCodeManager) ForEachDeviceAuthKey(ctx context.Context, fn func(Au
 	return vaultext.ScanView(ctx, view, func(path string) error { return fn(AuthCodeKey(path)) })
 }
 
+func (acm *AuthCodeManager) ForEachBackchannelAuthKey(ctx context.Context, fn func(AuthCodeKeyer) error) error {
+	view := logical.NewStorageView(acm.storage, BackchannelAuthKeyPrefix)
+	return vaultext.ScanView(ctx, view, func(path string) error { return fn(AuthCodeKey(path)) })
+}
+
 type AuthCodeHolder struct {
 	locks []*locksutil.LockEntry
 }
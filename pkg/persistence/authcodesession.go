@@ -0,0 +1,182 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/vaultext"
+	"github.com/openbao/openbao/sdk/v2/helper/locksutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const (
+	AuthCodeSessionKeyPrefix = "sessions/"
+)
+
+type AuthCodeSessionKeyer interface {
+	// AuthCodeSessionKey returns the storage key for storing
+	// AuthCodeSessionEntry objects.
+	AuthCodeSessionKey() string
+}
+
+// AuthCodeSessionEntry records the parameters of an outstanding authorization
+// code request so that they can be checked and reused when the resulting code
+// is exchanged.
+type AuthCodeSessionEntry struct {
+	// AuthServerName is the authorization server the authorization code URL
+	// was generated for.
+	AuthServerName string `json:"auth_server_name"`
+
+	RedirectURL     string            `json:"redirect_url,omitempty"`
+	Scopes          []string          `json:"scopes,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// CodeVerifier is the RFC 7636 code verifier, if PKCE is in use.
+	CodeVerifier string `json:"code_verifier,omitempty"`
+
+	// CodeChallenge is the S256 challenge derived from CodeVerifier that was
+	// sent to the authorization server.
+	CodeChallenge string `json:"code_challenge,omitempty"`
+
+	// Nonce is the value the issued ID token must contain, for providers that
+	// support OpenID Connect.
+	Nonce string `json:"nonce,omitempty"`
+
+	// CredentialName is the credential to write when the authorization server
+	// redirects to the plugin's callback endpoint.
+	CredentialName string `json:"credential_name,omitempty"`
+
+	// MaximumExpirySeconds is copied to the credential written by the
+	// callback endpoint.
+	MaximumExpirySeconds int `json:"maximum_expiry_seconds,omitempty"`
+
+	// Error and ErrorDescription record an error returned by the authorization
+	// server to the callback endpoint.
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+
+	// ExpiryTime is the time after which this session may no longer be used.
+	ExpiryTime time.Time `json:"expiry_time"`
+}
+
+func (acse *AuthCodeSessionEntry) Expired(ctx context.Context) bool {
+	return !clockctx.Clock(ctx).Now().Before(acse.ExpiryTime)
+}
+
+type AuthCodeSessionKey string
+
+var _ AuthCodeSessionKeyer = AuthCodeSessionKey("")
+
+func (acsk AuthCodeSessionKey) AuthCodeSessionKey() string {
+	return AuthCodeSessionKeyPrefix + string(acsk)
+}
+
+func AuthCodeSessionState(state string) AuthCodeSessionKeyer {
+	hash := sha256.Sum224([]byte(state))
+	first, second, rest := hash[:2], hash[2:4], hash[4:]
+	return AuthCodeSessionKey(fmt.Sprintf("%x/%x/%x", first, second, rest))
+}
+
+type LockedAuthCodeSessionManager struct {
+	storage logical.Storage
+	keyer   AuthCodeSessionKeyer
+}
+
+func (lacsm *LockedAuthCodeSessionManager) ReadAuthCodeSessionEntry(ctx context.Context) (*AuthCodeSessionEntry, error) {
+	se, err := lacsm.storage.Get(ctx, lacsm.keyer.AuthCodeSessionKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &AuthCodeSessionEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lacsm *LockedAuthCodeSessionManager) WriteAuthCodeSessionEntry(ctx context.Context, entry *AuthCodeSessionEntry) error {
+	se, err := logical.StorageEntryJSON(lacsm.keyer.AuthCodeSessionKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lacsm.storage.Put(ctx, se)
+}
+
+func (lacsm *LockedAuthCodeSessionManager) DeleteAuthCodeSessionEntry(ctx context.Context) error {
+	return lacsm.storage.Delete(ctx, lacsm.keyer.AuthCodeSessionKey())
+}
+
+type LockedAuthCodeSessionHolder struct {
+	keyer AuthCodeSessionKeyer
+}
+
+func (lacsh *LockedAuthCodeSessionHolder) Manager(storage logical.Storage) *LockedAuthCodeSessionManager {
+	return &LockedAuthCodeSessionManager{
+		storage: storage,
+		keyer:   lacsh.keyer,
+	}
+}
+
+type AuthCodeSessionLocker interface {
+	WithLock(AuthCodeSessionKeyer, func(*LockedAuthCodeSessionHolder) error) error
+}
+
+type AuthCodeSessionManager struct {
+	storage logical.Storage
+	locker  AuthCodeSessionLocker
+}
+
+func (acsm *AuthCodeSessionManager) ReadAuthCodeSessionEntry(ctx context.Context, keyer AuthCodeSessionKeyer) (*AuthCodeSessionEntry, error) {
+	var entry *AuthCodeSessionEntry
+	err := acsm.locker.WithLock(keyer, func(lacsh *LockedAuthCodeSessionHolder) (err error) {
+		entry, err = lacsh.Manager(acsm.storage).ReadAuthCodeSessionEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (acsm *AuthCodeSessionManager) WriteAuthCodeSessionEntry(ctx context.Context, keyer AuthCodeSessionKeyer, entry *AuthCodeSessionEntry) error {
+	return acsm.locker.WithLock(keyer, func(lacsh *LockedAuthCodeSessionHolder) error {
+		return lacsh.Manager(acsm.storage).WriteAuthCodeSessionEntry(ctx, entry)
+	})
+}
+
+func (acsm *AuthCodeSessionManager) DeleteAuthCodeSessionEntry(ctx context.Context, keyer AuthCodeSessionKeyer) error {
+	return acsm.locker.WithLock(keyer, func(lacsh *LockedAuthCodeSessionHolder) error {
+		return lacsh.Manager(acsm.storage).DeleteAuthCodeSessionEntry(ctx)
+	})
+}
+
+func (acsm *AuthCodeSessionManager) ForEachAuthCodeSessionKey(ctx context.Context, fn func(AuthCodeSessionKeyer) error) error {
+	view := logical.NewStorageView(acsm.storage, AuthCodeSessionKeyPrefix)
+	return vaultext.ScanView(ctx, view, func(path string) error { return fn(AuthCodeSessionKey(path)) })
+}
+
+type AuthCodeSessionHolder struct {
+	locks []*locksutil.LockEntry
+}
+
+func (acsh *AuthCodeSessionHolder) WithLock(keyer AuthCodeSessionKeyer, fn func(*LockedAuthCodeSessionHolder) error) error {
+	lock := locksutil.LockForKey(acsh.locks, keyer.AuthCodeSessionKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedAuthCodeSessionHolder{
+		keyer: keyer,
+	})
+}
+
+func (acsh *AuthCodeSessionHolder) Manager(storage logical.Storage) *AuthCodeSessionManager {
+	return &AuthCodeSessionManager{
+		storage: storage,
+		locker:  acsh,
+	}
+}
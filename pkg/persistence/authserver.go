@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/registration"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/vaultext"
 	"github.com/openbao/openbao/sdk/v2/helper/locksutil"
 	"github.com/openbao/openbao/sdk/v2/logical"
@@ -30,8 +31,129 @@ type AuthServerEntry struct {
 	ProviderName    string            `json:"provider_name"`
 	ProviderVersion int               `json:"provider_version"`
 	ProviderOptions map[string]string `json:"provider_options"`
+
+	// TokenEndpointAuthMethod is the method the client uses to authenticate to
+	// the authorization server. If not specified, the client secrets are used
+	// as directed by the provider.
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+
+	// ClientPrivateKey is a PEM-encoded private key used to sign client
+	// assertions when TokenEndpointAuthMethod is
+	// TokenEndpointAuthMethodPrivateKeyJWT.
+	ClientPrivateKey          string `json:"client_private_key,omitempty"`
+	ClientPrivateKeyID        string `json:"client_private_key_id,omitempty"`
+	ClientPrivateKeyAlgorithm string `json:"client_private_key_alg,omitempty"`
+
+	// TLSClientCertificate and TLSClientKey are a PEM-encoded certificate
+	// chain and private key presented to the authorization server when
+	// establishing TLS connections.
+	TLSClientCertificate string `json:"tls_client_certificate,omitempty"`
+	TLSClientKey         string `json:"tls_client_key,omitempty"`
+
+	// DPoP indicates that tokens issued to credentials for this server should
+	// be bound to a per-credential key using RFC 9449 DPoP.
+	DPoP bool `json:"dpop,omitempty"`
+
+	// RequestObjectSigningKey is a PEM-encoded private key used to sign
+	// authorization request parameters into RFC 9101 request objects. If not
+	// specified, authorization request parameters are sent unsigned.
+	RequestObjectSigningKey       string `json:"request_object_signing_key,omitempty"`
+	RequestObjectSigningKeyID     string `json:"request_object_signing_key_id,omitempty"`
+	RequestObjectSigningAlgorithm string `json:"request_object_signing_alg,omitempty"`
+
+	// PushedAuthRequests indicates that authorization code URLs should be
+	// created using RFC 9126 pushed authorization requests even if the
+	// authorization server does not require them.
+	PushedAuthRequests bool `json:"pushed_auth_requests,omitempty"`
+
+	// PKCE indicates whether authorization code URLs should include an RFC
+	// 7636 code challenge unless the request for the URL specifies otherwise.
+	// If not specified, they include one if the authorization server
+	// advertises support for it.
+	PKCE *bool `json:"pkce,omitempty"`
+
+	// CallbackSuccessTemplate and CallbackFailureTemplate optionally override
+	// the HTML pages returned by the callback endpoint.
+	CallbackSuccessTemplate string `json:"callback_success_template,omitempty"`
+	CallbackFailureTemplate string `json:"callback_failure_template,omitempty"`
+
+	// CallbackCredentialPrefix restricts the names of the credentials that the
+	// unauthenticated callback endpoint may write. If not specified, the
+	// callback endpoint does not write credentials.
+	CallbackCredentialPrefix string `json:"callback_credential_prefix,omitempty"`
+
+	// STSActors are the names of the credentials that may be used as the
+	// actor in token exchanges for credentials of this server. Without it,
+	// callers must provide actor tokens themselves.
+	STSActors []string `json:"sts_actors,omitempty"`
+
+	// Registration is present if the client was registered with the
+	// authorization server by this plugin.
+	Registration *AuthServerRegistration `json:"registration,omitempty"`
+}
+
+// AuthServerRegistration records how a client was registered using RFC 7591
+// dynamic client registration so that it can be updated or deregistered
+// later.
+type AuthServerRegistration struct {
+	URL      string                `json:"url"`
+	Metadata registration.Metadata `json:"metadata"`
+
+	// ClientURI and AccessToken are the RFC 7592 client configuration
+	// endpoint and the token that authorizes requests to it. They are only
+	// present if the authorization server supports client management.
+	ClientURI   string `json:"client_uri,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+
+	ClientSecretExpiresAt int64 `json:"client_secret_expires_at,omitempty"`
 }
 
+// Client returns the registered client described by this entry.
+func (ase *AuthServerEntry) Client() *registration.Client {
+	client := &registration.Client{
+		ClientID: ase.ClientID,
+	}
+	if len(ase.ClientSecrets) > 0 {
+		client.ClientSecret = ase.ClientSecrets[0]
+	}
+	if ase.Registration != nil {
+		client.Metadata = ase.Registration.Metadata
+		client.RegistrationClientURI = ase.Registration.ClientURI
+		client.RegistrationAccessToken = ase.Registration.AccessToken
+		client.ClientSecretExpiresAt = ase.Registration.ClientSecretExpiresAt
+	}
+	return client
+}
+
+// SetRegisteredClient updates the client information of this entry from a
+// registration response.
+func (ase *AuthServerEntry) SetRegisteredClient(registrationURL string, client *registration.Client) {
+	ase.ClientID = client.ClientID
+	ase.ClientSecrets = nil
+	if client.ClientSecret != "" {
+		ase.ClientSecrets = []string{client.ClientSecret}
+	}
+	ase.Registration = &AuthServerRegistration{
+		URL:                   registrationURL,
+		Metadata:              client.Metadata,
+		ClientURI:             client.RegistrationClientURI,
+		AccessToken:           client.RegistrationAccessToken,
+		ClientSecretExpiresAt: client.ClientSecretExpiresAt,
+	}
+}
+
+const (
+	// TokenEndpointAuthMethodPrivateKeyJWT authenticates the client using a
+	// JWT signed with its private key (RFC 7523 § 2.2).
+	TokenEndpointAuthMethodPrivateKeyJWT = "private_key_jwt"
+
+	// TokenEndpointAuthMethodTLSClientAuth and
+	// TokenEndpointAuthMethodSelfSignedTLSClientAuth authenticate the client
+	// using its TLS client certificate (RFC 8705 § 2).
+	TokenEndpointAuthMethodTLSClientAuth           = "tls_client_auth"
+	TokenEndpointAuthMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
 // UPGRADING (v2): LegacyAuthServerName is the name of the default server
 // created by the v2v3 upgrade.
 const LegacyAuthServerName = "legacy"
@@ -33,11 +33,18 @@ type ClientCredsEntry struct {
 	// MaximumExpirySeconds caps issued auth tokens to a desired lifetime.
 	MaximumExpirySeconds int `json:"maximum_expiry_seconds,omitempty"`
 
+	// DPoPKey is the PEM-encoded private key that tokens for this entry are
+	// bound to using RFC 9449 DPoP, if any.
+	DPoPKey string `json:"dpop_key,omitempty"`
+
 	Config struct {
 		Scopes          []string          `json:"scopes"`
 		TokenURLParams  map[string]string `json:"token_url_params"`
 		ProviderOptions map[string]string `json:"provider_options"`
 	} `json:"config"`
+
+	// Cache of successfully exchanged tokens
+	ExchangedTokens map[string]*provider.Token `json:"exchanged_tokens,omitempty"`
 }
 
 func (cce *ClientCredsEntry) SetToken(ctx context.Context, tok *provider.Token) {
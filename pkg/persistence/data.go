@@ -5,17 +5,25 @@ import (
 )
 
 type Holder struct {
-	Config      *ConfigHolder
-	AuthCode    *AuthCodeHolder
-	AuthServer  *AuthServerHolder
-	ClientCreds *ClientCredsHolder
+	Config          *ConfigHolder
+	AuthCode        *AuthCodeHolder
+	AuthCodeSession *AuthCodeSessionHolder
+	AuthServer      *AuthServerHolder
+	ClientCreds     *ClientCredsHolder
+	LogoutToken     *LogoutTokenHolder
+	Revocation      *RevocationHolder
+	STSProfile      *STSProfileHolder
 }
 
 func NewHolder() *Holder {
 	return &Holder{
-		Config:      &ConfigHolder{locks: locksutil.CreateLocks()},
-		AuthCode:    &AuthCodeHolder{locks: locksutil.CreateLocks()},
-		AuthServer:  &AuthServerHolder{locks: locksutil.CreateLocks()},
-		ClientCreds: &ClientCredsHolder{locks: locksutil.CreateLocks()},
+		Config:          &ConfigHolder{locks: locksutil.CreateLocks()},
+		AuthCode:        &AuthCodeHolder{locks: locksutil.CreateLocks()},
+		AuthCodeSession: &AuthCodeSessionHolder{locks: locksutil.CreateLocks()},
+		AuthServer:      &AuthServerHolder{locks: locksutil.CreateLocks()},
+		ClientCreds:     &ClientCredsHolder{locks: locksutil.CreateLocks()},
+		LogoutToken:     &LogoutTokenHolder{locks: locksutil.CreateLocks()},
+		Revocation:      &RevocationHolder{locks: locksutil.CreateLocks()},
+		STSProfile:      &STSProfileHolder{locks: locksutil.CreateLocks()},
 	}
 }
@@ -0,0 +1,142 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/vaultext"
+	"github.com/openbao/openbao/sdk/v2/helper/locksutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const (
+	LogoutTokenKeyPrefix = "logout-tokens/"
+)
+
+type LogoutTokenKeyer interface {
+	// LogoutTokenKey returns the storage key for storing LogoutTokenEntry
+	// objects.
+	LogoutTokenKey() string
+}
+
+// LogoutTokenEntry records that a back-channel logout token has been
+// processed so that it cannot be replayed.
+type LogoutTokenEntry struct {
+	// AuthServerName is the authorization server that issued the token.
+	AuthServerName string `json:"auth_server_name"`
+
+	// ExpiryTime is the time after which the token would be rejected because
+	// it is too old, so it no longer needs to be remembered.
+	ExpiryTime time.Time `json:"expiry_time"`
+}
+
+func (lte *LogoutTokenEntry) Expired(ctx context.Context) bool {
+	return !clockctx.Clock(ctx).Now().Before(lte.ExpiryTime)
+}
+
+type LogoutTokenKey string
+
+var _ LogoutTokenKeyer = LogoutTokenKey("")
+
+func (ltk LogoutTokenKey) LogoutTokenKey() string {
+	return LogoutTokenKeyPrefix + string(ltk)
+}
+
+// LogoutTokenID identifies a logout token by its issuer and its jti claim.
+func LogoutTokenID(serverName, id string) LogoutTokenKeyer {
+	hash := sha256.Sum224([]byte(serverName + "\x00" + id))
+	first, second, rest := hash[:2], hash[2:4], hash[4:]
+	return LogoutTokenKey(fmt.Sprintf("%x/%x/%x", first, second, rest))
+}
+
+type LockedLogoutTokenManager struct {
+	storage logical.Storage
+	keyer   LogoutTokenKeyer
+}
+
+func (lltm *LockedLogoutTokenManager) ReadLogoutTokenEntry(ctx context.Context) (*LogoutTokenEntry, error) {
+	se, err := lltm.storage.Get(ctx, lltm.keyer.LogoutTokenKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &LogoutTokenEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lltm *LockedLogoutTokenManager) WriteLogoutTokenEntry(ctx context.Context, entry *LogoutTokenEntry) error {
+	se, err := logical.StorageEntryJSON(lltm.keyer.LogoutTokenKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lltm.storage.Put(ctx, se)
+}
+
+func (lltm *LockedLogoutTokenManager) DeleteLogoutTokenEntry(ctx context.Context) error {
+	return lltm.storage.Delete(ctx, lltm.keyer.LogoutTokenKey())
+}
+
+type LockedLogoutTokenHolder struct {
+	keyer LogoutTokenKeyer
+}
+
+func (llth *LockedLogoutTokenHolder) Manager(storage logical.Storage) *LockedLogoutTokenManager {
+	return &LockedLogoutTokenManager{
+		storage: storage,
+		keyer:   llth.keyer,
+	}
+}
+
+type LogoutTokenLocker interface {
+	WithLock(LogoutTokenKeyer, func(*LockedLogoutTokenHolder) error) error
+}
+
+type LogoutTokenManager struct {
+	storage logical.Storage
+	locker  LogoutTokenLocker
+}
+
+func (ltm *LogoutTokenManager) ReadLogoutTokenEntry(ctx context.Context, keyer LogoutTokenKeyer) (*LogoutTokenEntry, error) {
+	var entry *LogoutTokenEntry
+	err := ltm.locker.WithLock(keyer, func(llth *LockedLogoutTokenHolder) (err error) {
+		entry, err = llth.Manager(ltm.storage).ReadLogoutTokenEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (ltm *LogoutTokenManager) ForEachLogoutTokenKey(ctx context.Context, fn func(LogoutTokenKeyer) error) error {
+	view := logical.NewStorageView(ltm.storage, LogoutTokenKeyPrefix)
+	return vaultext.ScanView(ctx, view, func(path string) error { return fn(LogoutTokenKey(path)) })
+}
+
+type LogoutTokenHolder struct {
+	locks []*locksutil.LockEntry
+}
+
+func (lth *LogoutTokenHolder) WithLock(keyer LogoutTokenKeyer, fn func(*LockedLogoutTokenHolder) error) error {
+	lock := locksutil.LockForKey(lth.locks, keyer.LogoutTokenKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedLogoutTokenHolder{
+		keyer: keyer,
+	})
+}
+
+func (lth *LogoutTokenHolder) Manager(storage logical.Storage) *LogoutTokenManager {
+	return &LogoutTokenManager{
+		storage: storage,
+		locker:  lth,
+	}
+}
@@ -0,0 +1,193 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/vaultext"
+	"github.com/openbao/openbao/sdk/v2/helper/locksutil"
+	"github.com/openbao/openbao/sdk/v2/logical"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+)
+
+const (
+	RevocationKeyPrefix = "revocations/"
+
+	revocationInitialBackoff = 30 * time.Second
+	revocationMaximumBackoff = time.Hour
+)
+
+type RevocationKeyer interface {
+	// RevocationKey returns the storage key for storing RevocationEntry
+	// objects.
+	RevocationKey() string
+}
+
+// RevocationEntry is a token that could not be revoked at the authorization
+// server when its credential was removed. It is retried in the background.
+type RevocationEntry struct {
+	// AuthServerName is the authorization server that issued the token.
+	AuthServerName string `json:"auth_server_name"`
+
+	Token           string            `json:"token"`
+	TokenTypeHint   string            `json:"token_type_hint,omitempty"`
+	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// Expiry is the time the token expires on its own, if known. There is no
+	// need to revoke it after this time.
+	Expiry time.Time `json:"expiry,omitempty"`
+
+	// Attempts is the number of times revocation has failed.
+	Attempts int `json:"attempts"`
+
+	// LastError is the error returned by the most recent attempt.
+	LastError string `json:"last_error,omitempty"`
+
+	// LastAttemptedTime is the time of the most recent attempt.
+	LastAttemptedTime time.Time `json:"last_attempted_time"`
+}
+
+func (re *RevocationEntry) SetError(ctx context.Context, err string) {
+	re.Attempts++
+	re.LastError = err
+	re.LastAttemptedTime = clockctx.Clock(ctx).Now()
+}
+
+// Expired returns true if the token no longer needs to be revoked because it
+// has expired.
+func (re *RevocationEntry) Expired(ctx context.Context) bool {
+	return !re.Expiry.IsZero() && !clockctx.Clock(ctx).Now().Before(re.Expiry)
+}
+
+// ShouldRetry returns true if enough time has passed since the last attempt
+// to try again. The delay doubles with each attempt.
+func (re *RevocationEntry) ShouldRetry(ctx context.Context) bool {
+	if re.Attempts <= 0 {
+		return true
+	}
+
+	backoff := revocationMaximumBackoff
+	if re.Attempts < 8 {
+		backoff = revocationInitialBackoff << (re.Attempts - 1)
+		if backoff > revocationMaximumBackoff {
+			backoff = revocationMaximumBackoff
+		}
+	}
+
+	return !clockctx.Clock(ctx).Now().Before(re.LastAttemptedTime.Add(backoff))
+}
+
+type RevocationKey string
+
+var _ RevocationKeyer = RevocationKey("")
+
+func (rk RevocationKey) RevocationKey() string { return RevocationKeyPrefix + string(rk) }
+
+func RevocationToken(token string) RevocationKeyer {
+	hash := sha256.Sum224([]byte(token))
+	first, second, rest := hash[:2], hash[2:4], hash[4:]
+	return RevocationKey(fmt.Sprintf("%x/%x/%x", first, second, rest))
+}
+
+type LockedRevocationManager struct {
+	storage logical.Storage
+	keyer   RevocationKeyer
+}
+
+func (lrm *LockedRevocationManager) ReadRevocationEntry(ctx context.Context) (*RevocationEntry, error) {
+	se, err := lrm.storage.Get(ctx, lrm.keyer.RevocationKey())
+	if err != nil {
+		return nil, err
+	} else if se == nil {
+		return nil, nil
+	}
+
+	entry := &RevocationEntry{}
+	if err := se.DecodeJSON(entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (lrm *LockedRevocationManager) WriteRevocationEntry(ctx context.Context, entry *RevocationEntry) error {
+	se, err := logical.StorageEntryJSON(lrm.keyer.RevocationKey(), entry)
+	if err != nil {
+		return err
+	}
+
+	return lrm.storage.Put(ctx, se)
+}
+
+func (lrm *LockedRevocationManager) DeleteRevocationEntry(ctx context.Context) error {
+	return lrm.storage.Delete(ctx, lrm.keyer.RevocationKey())
+}
+
+type LockedRevocationHolder struct {
+	keyer RevocationKeyer
+}
+
+func (lrh *LockedRevocationHolder) Manager(storage logical.Storage) *LockedRevocationManager {
+	return &LockedRevocationManager{
+		storage: storage,
+		keyer:   lrh.keyer,
+	}
+}
+
+type RevocationLocker interface {
+	WithLock(RevocationKeyer, func(*LockedRevocationHolder) error) error
+}
+
+type RevocationManager struct {
+	storage logical.Storage
+	locker  RevocationLocker
+}
+
+func (rm *RevocationManager) ReadRevocationEntry(ctx context.Context, keyer RevocationKeyer) (*RevocationEntry, error) {
+	var entry *RevocationEntry
+	err := rm.locker.WithLock(keyer, func(lrh *LockedRevocationHolder) (err error) {
+		entry, err = lrh.Manager(rm.storage).ReadRevocationEntry(ctx)
+		return
+	})
+	return entry, err
+}
+
+func (rm *RevocationManager) WriteRevocationEntry(ctx context.Context, keyer RevocationKeyer, entry *RevocationEntry) error {
+	return rm.locker.WithLock(keyer, func(lrh *LockedRevocationHolder) error {
+		return lrh.Manager(rm.storage).WriteRevocationEntry(ctx, entry)
+	})
+}
+
+func (rm *RevocationManager) DeleteRevocationEntry(ctx context.Context, keyer RevocationKeyer) error {
+	return rm.locker.WithLock(keyer, func(lrh *LockedRevocationHolder) error {
+		return lrh.Manager(rm.storage).DeleteRevocationEntry(ctx)
+	})
+}
+
+func (rm *RevocationManager) ForEachRevocationKey(ctx context.Context, fn func(RevocationKeyer) error) error {
+	view := logical.NewStorageView(rm.storage, RevocationKeyPrefix)
+	return vaultext.ScanView(ctx, view, func(path string) error { return fn(RevocationKey(path)) })
+}
+
+type RevocationHolder struct {
+	locks []*locksutil.LockEntry
+}
+
+func (rh *RevocationHolder) WithLock(keyer RevocationKeyer, fn func(*LockedRevocationHolder) error) error {
+	lock := locksutil.LockForKey(rh.locks, keyer.RevocationKey())
+	lock.Lock()
+	defer lock.Unlock()
+
+	return fn(&LockedRevocationHolder{
+		keyer: keyer,
+	})
+}
+
+func (rh *RevocationHolder) Manager(storage logical.Storage) *RevocationManager {
+	return &RevocationManager{
+		storage: storage,
+		locker:  rh,
+	}
+}
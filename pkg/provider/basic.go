@@ -2,13 +2,23 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"strings"
 
 	gooidc "github.com/coreos/go-oidc"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/assertion"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientctx"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jar"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/par"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/revocation"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"golang.org/x/oauth2"
@@ -40,43 +50,222 @@ type basicOperations struct {
 	clientSecret    string
 }
 
-func (bo *basicOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+// endpoint returns the endpoint for the given provider options, taking into
+// account whether the client presents a TLS certificate.
+func (bo *basicOperations) endpoint(ctx context.Context, opts map[string]string) Endpoint {
+	endpoint := bo.endpointFactory(opts)
+	if clientctx.HasTLSClientCertificate(ctx) {
+		endpoint = endpoint.ForMTLS()
+	}
+	return endpoint
+}
+
+// authenticator returns the client authenticator to use in place of sending
+// the client secret, if any. An authenticator carried by the context takes
+// precedence over one derived from the endpoint configuration.
+func (bo *basicOperations) authenticator(ctx context.Context, endpoint Endpoint) (clientauth.Authenticator, bool) {
+	if a, ok := clientauth.AuthenticatorFromContext(ctx); ok {
+		return a, true
+	}
+
+	if endpoint.ClientSecretJWT && bo.clientSecret != "" {
+		return &clientauth.ClientSecretJWT{
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+		}, true
+	}
+
+	return nil, false
+}
+
+// clientAuth returns the context, client secret, and authentication style to
+// use for requests to the token endpoint. If the client uses an
+// authenticator, it replaces the client secret. If the context carries a
+// DPoP key, requests also carry proofs of possession of it.
+//
+// Proofs are added outside of client authentication so that a request retried
+// with a DPoP nonce is authenticated again with a new client assertion.
+func (bo *basicOperations) clientAuth(ctx context.Context, endpoint Endpoint) (context.Context, string, oauth2.AuthStyle) {
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		return dpop.WithProofs(clientauth.WithAuthenticatedRequests(ctx, a)), "", oauth2.AuthStyleInParams
+	}
+
+	return dpop.WithProofs(ctx), bo.clientSecret, endpoint.AuthStyle
+}
+
+// hasClientCredentials returns true if this client can authenticate itself
+// to the token endpoint.
+func (bo *basicOperations) hasClientCredentials(ctx context.Context) bool {
+	if bo.clientSecret != "" {
+		return true
+	}
+
+	_, ok := clientauth.AuthenticatorFromContext(ctx)
+	return ok
+}
+
+// requestObjectConfig returns the configuration to use to sign request
+// objects, or nil if request objects are not requested.
+func requestObjectConfig(cfg *oauth2.Config, endpoint Endpoint, key *jwtkey.Key) *jar.Config {
+	if key == nil {
+		return nil
+	}
+
+	// The audience should be the issuer identifier, but not all providers
+	// know it. Fall back to the authorization endpoint in that case.
+	aud := endpoint.Issuer
+	if aud == "" {
+		aud = endpoint.AuthURL
+	}
+
+	return &jar.Config{
+		Config:   cfg,
+		Key:      key,
+		Audience: aud,
+	}
+}
+
+func (bo *basicOperations) authCodeURLConfig(opts []AuthCodeURLOption) (*oauth2.Config, Endpoint, []oauth2.AuthCodeOption, *jwtkey.Key) {
 	o := &AuthCodeURLOptions{}
 	o.ApplyOptions(opts)
 
 	endpoint := bo.endpointFactory(o.ProviderOptions)
-	if endpoint.AuthURL == "" {
+
+	cfg := &oauth2.Config{
+		Endpoint:     endpoint.Endpoint,
+		ClientID:     bo.clientID,
+		ClientSecret: bo.clientSecret,
+		Scopes:       o.Scopes,
+		RedirectURL:  o.RedirectURL,
+	}
+
+	if o.CodeVerifier != "" {
+		o.AuthCodeOptions = append(o.AuthCodeOptions, oauth2.S256ChallengeOption(o.CodeVerifier))
+	}
+
+	return cfg, endpoint, o.AuthCodeOptions, o.RequestObjectKey
+}
+
+func (bo *basicOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	cfg, endpoint, authCodeOptions, key := bo.authCodeURLConfig(opts)
+	if endpoint.AuthURL == "" || endpoint.PushedAuthRequired {
+		return "", false, nil
+	}
+
+	if jc := requestObjectConfig(cfg, endpoint, key); jc != nil {
+		u, err := jc.AuthCodeURL(state, authCodeOptions...)
+		if err != nil {
+			return "", false, err
+		}
+
+		return u, true, nil
+	}
+
+	return cfg.AuthCodeURL(state, authCodeOptions...), true, nil
+}
+
+func (bo *basicOperations) PKCESupported(opts ...AuthCodeURLOption) bool {
+	o := &AuthCodeURLOptions{}
+	o.ApplyOptions(opts)
+
+	return bo.endpointFactory(o.ProviderOptions).PKCESupported
+}
+
+func (bo *basicOperations) LogoutURL(idTokenHint string, opts ...LogoutURLOption) (string, bool) {
+	o := &LogoutURLOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpointFactory(o.ProviderOptions)
+	if endpoint.EndSessionURL == "" {
 		return "", false
 	}
 
-	cfg := &oauth2.Config{
-		Endpoint:    endpoint.Endpoint,
-		ClientID:    bo.clientID,
-		Scopes:      o.Scopes,
-		RedirectURL: o.RedirectURL,
+	u, err := url.Parse(endpoint.EndSessionURL)
+	if err != nil {
+		return "", false
+	}
+
+	// OpenID Connect RP-Initiated Logout 1.0 § 2: the client ID identifies
+	// the client when the ID token hint is absent and is otherwise redundant
+	// but harmless.
+	v := u.Query()
+	v.Set("client_id", bo.clientID)
+	if idTokenHint != "" {
+		v.Set("id_token_hint", idTokenHint)
+	}
+	if o.RedirectURL != "" {
+		v.Set("post_logout_redirect_uri", o.RedirectURL)
+	}
+	if o.State != "" {
+		v.Set("state", o.State)
+	}
+	u.RawQuery = v.Encode()
+
+	return u.String(), true
+}
+
+func (bo *basicOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	cfg, endpoint, authCodeOptions, key := bo.authCodeURLConfig(opts)
+	if endpoint.AuthURL == "" || endpoint.PushedAuthURL == "" {
+		return "", false, nil
 	}
 
-	return cfg.AuthCodeURL(state, o.AuthCodeOptions...), true
+	if clientctx.HasTLSClientCertificate(ctx) {
+		endpoint = endpoint.ForMTLS()
+	}
+
+	pc := &par.Config{
+		Config:        cfg,
+		PushedAuthURL: endpoint.PushedAuthURL,
+	}
+
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticator(ctx, a)
+	}
+
+	u, err := url.Parse(cfg.AuthCodeURL(state, authCodeOptions...))
+	if err != nil {
+		return "", false, err
+	}
+
+	v := u.Query()
+	if jc := requestObjectConfig(cfg, endpoint, key); jc != nil {
+		if v, err = jc.Values(v); err != nil {
+			return "", false, err
+		}
+	}
+
+	pu, err := pc.AuthCodeURLFromValues(ctx, v)
+	if err != nil {
+		return "", false, semerr.Map(err)
+	}
+
+	return pu, true, nil
 }
 
 func (bo *basicOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
 	o := &DeviceCodeAuthOptions{}
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
 	if endpoint.DeviceURL == "" {
 		return nil, false, nil
 	}
 
 	cfg := &devicecode.Config{
 		Config: &oauth2.Config{
-			Endpoint: endpoint.Endpoint,
-			ClientID: bo.clientID,
-			Scopes:   o.Scopes,
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+			Scopes:       o.Scopes,
 		},
 		DeviceURL: endpoint.DeviceURL,
 	}
 
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticator(ctx, a)
+	}
+
 	auth, err := cfg.DeviceCodeAuth(ctx)
 	return auth, err == nil, semerr.Map(err)
 }
@@ -85,17 +274,22 @@ func (bo *basicOperations) DeviceCodeExchange(ctx context.Context, deviceCode st
 	o := &DeviceCodeExchangeOptions{}
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
 
 	cfg := &devicecode.Config{
 		Config: &oauth2.Config{
-			Endpoint: endpoint.Endpoint,
-			ClientID: bo.clientID,
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
 		},
 		DeviceURL: endpoint.DeviceURL,
 	}
 
-	tok, err := cfg.DeviceCodeExchange(ctx, deviceCode)
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticatedRequests(clientauth.WithAuthenticator(ctx, a), a)
+	}
+
+	tok, err := cfg.DeviceCodeExchange(dpop.WithProofs(ctx), deviceCode)
 	if err != nil {
 		err = semerr.Map(err)
 		err = errmark.MarkUserIf(
@@ -117,22 +311,126 @@ func (bo *basicOperations) DeviceCodeExchange(ctx context.Context, deviceCode st
 	}, nil
 }
 
-func (bo *basicOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
-	if bo.clientSecret == "" {
+func (bo *basicOperations) BackchannelAuth(ctx context.Context, opts ...BackchannelAuthOption) (*ciba.Auth, bool, error) {
+	if !bo.hasClientCredentials(ctx) {
+		return nil, false, errmark.MarkUser(ErrMissingClientSecret)
+	}
+
+	o := &BackchannelAuthOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+	if endpoint.BackchannelAuthURL == "" {
+		return nil, false, nil
+	}
+
+	cfg := &ciba.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+			Scopes:       o.Scopes,
+		},
+		BackchannelAuthURL: endpoint.BackchannelAuthURL,
+	}
+
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticator(ctx, a)
+	}
+
+	auth, err := cfg.BackchannelAuth(ctx, ciba.AuthOptions{
+		LoginHint:      o.LoginHint,
+		BindingMessage: o.BindingMessage,
+	})
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(
+			err,
+			errmark.RuleAny(
+				semerr.RuleCode("invalid_request"),
+				semerr.RuleCode("invalid_scope"),
+				semerr.RuleCode("unknown_user_id"),
+				semerr.RuleCode("unauthorized_client"),
+				semerr.RuleCode("access_denied"),
+			),
+		)
+
+		return nil, false, err
+	}
+
+	return auth, true, nil
+}
+
+func (bo *basicOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...BackchannelAuthExchangeOption) (*Token, error) {
+	if !bo.hasClientCredentials(ctx) {
 		return nil, errmark.MarkUser(ErrMissingClientSecret)
 	}
 
+	o := &BackchannelAuthExchangeOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
+
+	cfg := &ciba.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: clientSecret,
+		},
+		BackchannelAuthURL: endpoint.BackchannelAuthURL,
+	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	tok, err := cfg.Exchange(ctx, authReqID)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(
+			err,
+			errmark.RuleAny(
+				semerr.RuleCode("access_denied"),
+				semerr.RuleCode("expired_token"),
+				semerr.RuleCode("transaction_failed"),
+			),
+		)
+
+		return nil, err
+	}
+
+	return &Token{
+		Token: tok,
+
+		ProviderVersion: bo.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}, nil
+}
+
+func (bo *basicOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
 	o := &AuthCodeExchangeOptions{}
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	// Public clients may exchange a code without a secret as long as they
+	// prove possession of the PKCE code verifier.
+	if !bo.hasClientCredentials(ctx) && o.CodeVerifier == "" {
+		return nil, errmark.MarkUser(ErrMissingClientSecret)
+	}
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
 
 	cfg := &oauth2.Config{
 		Endpoint:     endpoint.Endpoint,
 		ClientID:     bo.clientID,
-		ClientSecret: bo.clientSecret,
+		ClientSecret: clientSecret,
 		RedirectURL:  o.RedirectURL,
 	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	if o.CodeVerifier != "" {
+		o.AuthCodeOptions = append(o.AuthCodeOptions, oauth2.VerifierOption(o.CodeVerifier))
+	}
 
 	tok, err := cfg.Exchange(ctx, code, o.AuthCodeOptions...)
 	if err != nil {
@@ -152,13 +450,16 @@ func (bo *basicOperations) RefreshToken(ctx context.Context, t *Token, opts ...R
 	WithProviderOptions(t.ProviderOptions).ApplyToRefreshTokenOptions(o)
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
 
 	cfg := &oauth2.Config{
 		Endpoint:     endpoint.Endpoint,
 		ClientID:     bo.clientID,
-		ClientSecret: bo.clientSecret,
+		ClientSecret: clientSecret,
 	}
+	cfg.Endpoint.AuthStyle = authStyle
 
 	tok, err := cfg.TokenSource(ctx, &oauth2.Token{
 		RefreshToken: t.RefreshToken,
@@ -175,21 +476,27 @@ func (bo *basicOperations) RefreshToken(ctx context.Context, t *Token, opts ...R
 	}, nil
 }
 
+func (bo *basicOperations) VerifyLogoutToken(ctx context.Context, token string) (*LogoutToken, bool, error) {
+	return nil, false, nil
+}
+
 func (bo *basicOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
-	if bo.clientSecret == "" {
+	if !bo.hasClientCredentials(ctx) {
 		return nil, errmark.MarkUser(ErrMissingClientSecret)
 	}
 
 	o := &ClientCredentialsOptions{}
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
 
 	cc := &clientcredentials.Config{
 		ClientID:       bo.clientID,
-		ClientSecret:   bo.clientSecret,
+		ClientSecret:   clientSecret,
 		TokenURL:       endpoint.TokenURL,
-		AuthStyle:      endpoint.AuthStyle,
+		AuthStyle:      authStyle,
 		Scopes:         o.Scopes,
 		EndpointParams: o.EndpointParams,
 	}
@@ -208,20 +515,23 @@ func (bo *basicOperations) ClientCredentials(ctx context.Context, opts ...Client
 }
 
 func (bo *basicOperations) TokenExchange(ctx context.Context, t *Token, opts ...TokenExchangeOption) (*Token, error) {
-	if bo.clientSecret == "" {
+	if !bo.hasClientCredentials(ctx) {
 		return nil, errmark.MarkUser(ErrMissingClientSecret)
 	}
 
 	o := &TokenExchangeOptions{}
 	o.ApplyOptions(opts)
 
-	endpoint := bo.endpointFactory(o.ProviderOptions)
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
 
 	cfg := &oauth2.Config{
 		Endpoint:     endpoint.Endpoint,
 		ClientID:     bo.clientID,
-		ClientSecret: bo.clientSecret,
+		ClientSecret: clientSecret,
 	}
+	cfg.Endpoint.AuthStyle = authStyle
 
 	// Add audiences and resources, which require multiple instances of the same
 	// parameter to be specified, and therefore can't use oauth2.AuthCodeOption.
@@ -241,14 +551,42 @@ func (bo *basicOperations) TokenExchange(ctx context.Context, t *Token, opts ...
 		return []byte(vs.Encode()), nil
 	})
 
+	subjectTokenType := o.SubjectTokenType
+	if subjectTokenType == "" {
+		subjectTokenType = TokenTypeAccessToken
+	}
+
+	subjectToken, ok := t.TokenOfType(subjectTokenType)
+	if !ok {
+		return nil, errmark.MarkUser(ErrMissingSubjectToken)
+	}
+
+	requestedTokenType := o.RequestedTokenType
+	if requestedTokenType == "" {
+		requestedTokenType = TokenTypeAccessToken
+	}
+
 	o.AuthCodeOptions = append(
 		o.AuthCodeOptions,
 		oauth2.SetAuthURLParam("grant_type", "urn:ietf:params:oauth:grant-type:token-exchange"),
-		oauth2.SetAuthURLParam("subject_token_type", "urn:ietf:params:oauth:token-type:access_token"),
-		oauth2.SetAuthURLParam("subject_token", t.AccessToken),
-		oauth2.SetAuthURLParam("requested_token_type", "urn:ietf:params:oauth:token-type:access_token"),
+		oauth2.SetAuthURLParam("subject_token_type", subjectTokenType),
+		oauth2.SetAuthURLParam("subject_token", subjectToken),
+		oauth2.SetAuthURLParam("requested_token_type", requestedTokenType),
 	)
 
+	if o.ActorToken != "" {
+		actorTokenType := o.ActorTokenType
+		if actorTokenType == "" {
+			actorTokenType = TokenTypeAccessToken
+		}
+
+		o.AuthCodeOptions = append(
+			o.AuthCodeOptions,
+			oauth2.SetAuthURLParam("actor_token", o.ActorToken),
+			oauth2.SetAuthURLParam("actor_token_type", actorTokenType),
+		)
+	}
+
 	if len(o.Scopes) > 0 {
 		o.AuthCodeOptions = append(o.AuthCodeOptions, oauth2.SetAuthURLParam("scope", strings.Join(o.Scopes, " ")))
 	}
@@ -260,6 +598,279 @@ func (bo *basicOperations) TokenExchange(ctx context.Context, t *Token, opts ...
 		return nil, semerr.Map(err)
 	}
 
+	var extraData map[string]interface{}
+	if issuedTokenType, ok := tok.Extra("issued_token_type").(string); ok && issuedTokenType != "" {
+		extraData = map[string]interface{}{
+			"issued_token_type": issuedTokenType,
+		}
+	}
+
+	return &Token{
+		Token:     tok,
+		ExtraData: extraData,
+
+		ProviderVersion: bo.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}, nil
+}
+
+func (bo *basicOperations) Revoke(ctx context.Context, token string, opts ...RevokeOption) (bool, error) {
+	o := &RevokeOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+	if endpoint.RevocationURL == "" {
+		return false, nil
+	}
+
+	cfg := &revocation.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+		},
+		RevocationURL: endpoint.RevocationURL,
+	}
+
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticator(ctx, a)
+	}
+
+	if err := cfg.Revoke(ctx, token, o.TokenTypeHint); err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("unsupported_token_type"))
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (bo *basicOperations) Introspect(ctx context.Context, token string, opts ...IntrospectOption) (*introspection.Result, bool, error) {
+	if !bo.hasClientCredentials(ctx) {
+		return nil, false, errmark.MarkUser(ErrMissingClientSecret)
+	}
+
+	o := &IntrospectOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+	if endpoint.IntrospectionURL == "" {
+		return nil, false, nil
+	}
+
+	cfg := &introspection.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: bo.clientSecret,
+		},
+		IntrospectionURL: endpoint.IntrospectionURL,
+	}
+
+	if a, ok := bo.authenticator(ctx, endpoint); ok {
+		ctx = clientauth.WithAuthenticator(ctx, a)
+	}
+
+	r, err := cfg.Introspect(ctx, token, o.TokenTypeHint)
+	if err != nil {
+		return nil, false, semerr.Map(err)
+	}
+
+	return r, true, nil
+}
+
+func (bo *basicOperations) OnBehalfOf(ctx context.Context, t *Token, opts ...OnBehalfOfOption) (*Token, error) {
+	if !bo.hasClientCredentials(ctx) {
+		return nil, errmark.MarkUser(ErrMissingClientSecret)
+	}
+
+	o := &OnBehalfOfOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
+
+	cfg := &assertion.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: clientSecret,
+			Scopes:       o.Scopes,
+		},
+	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	// https://learn.microsoft.com/en-us/entra/identity-platform/v2-oauth2-on-behalf-of-flow#first-case-access-token-request-with-a-shared-secret
+	tok, err := cfg.Token(
+		ctx,
+		assertion.GrantTypeJWTBearer,
+		t.AccessToken,
+		oauth2.SetAuthURLParam("requested_token_use", "on_behalf_of"),
+	)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(
+			err,
+			errmark.RuleAny(
+				semerr.RuleCode("invalid_grant"),
+				semerr.RuleCode("interaction_required"),
+			),
+		)
+
+		return nil, err
+	}
+
+	return &Token{
+		Token: tok,
+
+		ProviderVersion: bo.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}, nil
+}
+
+func (bo *basicOperations) JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...JWTBearerOption) (*Token, error) {
+	o := &JWTBearerOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	aud := o.Audience
+	if aud == "" {
+		aud = endpoint.TokenURL
+	}
+
+	claims, err := clientauth.AssertionClaims(ctx, bo.clientID, aud)
+	if err != nil {
+		return nil, err
+	}
+	if o.Issuer != "" {
+		claims.Issuer = o.Issuer
+	}
+	if o.Subject != "" {
+		claims.Subject = o.Subject
+	}
+
+	merged, err := mergeClaims(o.Claims, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := key.Sign(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
+
+	cfg := &assertion.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: clientSecret,
+			Scopes:       o.Scopes,
+		},
+	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	tok, err := cfg.Token(ctx, assertion.GrantTypeJWTBearer, a)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
+	return &Token{
+		Token: tok,
+
+		ProviderVersion: bo.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}, nil
+}
+
+// mergeClaims returns a claims set with the given extra claims overlaid by
+// the registered claims.
+func mergeClaims(extra map[string]interface{}, registered interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(registered)
+	if err != nil {
+		return nil, err
+	}
+
+	var rm map[string]interface{}
+	if err := json.Unmarshal(b, &rm); err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]interface{}, len(extra)+len(rm))
+	for k, v := range extra {
+		merged[k] = v
+	}
+	for k, v := range rm {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+func (bo *basicOperations) SAML2Bearer(ctx context.Context, a string, opts ...SAML2BearerOption) (*Token, error) {
+	o := &SAML2BearerOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
+
+	cfg := &assertion.Config{
+		Config: &oauth2.Config{
+			Endpoint:     endpoint.Endpoint,
+			ClientID:     bo.clientID,
+			ClientSecret: clientSecret,
+			Scopes:       o.Scopes,
+		},
+	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	tok, err := cfg.Token(ctx, assertion.GrantTypeSAML2Bearer, a)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
+	return &Token{
+		Token: tok,
+
+		ProviderVersion: bo.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}, nil
+}
+
+func (bo *basicOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...PasswordCredentialsOption) (*Token, error) {
+	o := &PasswordCredentialsOptions{}
+	o.ApplyOptions(opts)
+
+	endpoint := bo.endpoint(ctx, o.ProviderOptions)
+
+	ctx, clientSecret, authStyle := bo.clientAuth(ctx, endpoint)
+
+	cfg := &oauth2.Config{
+		Endpoint:     endpoint.Endpoint,
+		ClientID:     bo.clientID,
+		ClientSecret: clientSecret,
+		Scopes:       o.Scopes,
+	}
+	cfg.Endpoint.AuthStyle = authStyle
+
+	tok, err := cfg.PasswordCredentialsToken(ctx, username, password)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
 	return &Token{
 		Token: tok,
 
@@ -372,28 +983,62 @@ func CustomFactory(ctx context.Context, vsn int, opts map[string]string) (Provid
 		return nil, ErrNoProviderWithVersion
 	}
 
-	if opts["token_url"] == "" {
+	// RFC 8414 authorization server metadata provides defaults for any
+	// option that is not set explicitly.
+	md := &serverMetadata{}
+	if issuerURL := opts["issuer_url"]; issuerURL != "" {
+		var err error
+		md, err = fetchServerMetadata(ctx, issuerURL)
+		if err != nil {
+			return nil, &OptionError{Option: "issuer_url", Cause: err}
+		}
+	}
+
+	opt := func(name, def string) string {
+		if v := opts[name]; v != "" {
+			return v
+		}
+		return def
+	}
+
+	tokenURL := opt("token_url", md.TokenEndpoint)
+	if tokenURL == "" {
 		return nil, &OptionError{Option: "token_url", Cause: fmt.Errorf("token URL is required")}
 	}
 
 	authStyle := oauth2.AuthStyleAutoDetect
+	clientSecretJWT := false
 	switch opts["auth_style"] {
 	case "in_header":
 		authStyle = oauth2.AuthStyleInHeader
 	case "in_params":
 		authStyle = oauth2.AuthStyleInParams
+	case "client_secret_jwt":
+		clientSecretJWT = true
 	case "":
+		if len(md.TokenEndpointAuthMethodsSupported) > 0 {
+			authStyle, clientSecretJWT = md.authStyle()
+		}
 	default:
-		return nil, &OptionError{Option: "auth_style", Cause: fmt.Errorf(`unknown authentication style; expected one of "in_header" or "in_params"`)}
+		return nil, &OptionError{Option: "auth_style", Cause: fmt.Errorf(`unknown authentication style; expected one of "in_header", "in_params", or "client_secret_jwt"`)}
 	}
 
 	endpoint := Endpoint{
 		Endpoint: oauth2.Endpoint{
-			AuthURL:   opts["auth_code_url"],
-			TokenURL:  opts["token_url"],
+			AuthURL:   opt("auth_code_url", md.AuthorizationEndpoint),
+			TokenURL:  tokenURL,
 			AuthStyle: authStyle,
 		},
-		DeviceURL: opts["device_code_url"],
+		DeviceURL:          opt("device_code_url", md.DeviceAuthorizationEndpoint),
+		PushedAuthURL:      opt("pushed_auth_url", md.PushedAuthorizationRequestEndpoint),
+		PushedAuthRequired: opts["pushed_auth_url"] != "" || md.RequirePushedAuthorizationRequests,
+		BackchannelAuthURL: opt("backchannel_auth_url", md.BackchannelAuthenticationEndpoint),
+		RevocationURL:      opt("revocation_url", md.RevocationEndpoint),
+		IntrospectionURL:   opt("introspection_url", md.IntrospectionEndpoint),
+		Issuer:             opt("issuer", md.Issuer),
+		ClientSecretJWT:    clientSecretJWT,
+		PKCESupported:      md.pkceSupported(),
+		MTLSAliases:        md.mtlsAliases(),
 	}
 
 	p := &basic{
@@ -2,17 +2,29 @@ package provider_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/assertion"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
 )
 
 var basicTestFactory = provider.BasicFactory(provider.Endpoint{
@@ -34,12 +46,13 @@ func TestBasicPublic(t *testing.T) {
 
 	ops := basicTest.Public("foo")
 
-	authCodeURL, ok := ops.AuthCodeURL(
+	authCodeURL, ok, err := ops.AuthCodeURL(
 		"state",
 		provider.WithRedirectURL("http://example.com/redirect"),
 		provider.WithScopes{"a", "b", "c"},
 		provider.WithURLParams{"baz": "quux"},
 	)
+	require.NoError(t, err)
 	require.True(t, ok)
 
 	u, err := url.Parse(authCodeURL)
@@ -159,51 +172,936 @@ func TestBasicPrivate(t *testing.T) {
 	require.True(t, token.Valid())
 }
 
-func TestAzureADEndpoint(t *testing.T) {
+func TestBasicTokenExchangeDelegation(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	tests := []struct {
-		Name                string
-		PluginOptions       map[string]string
-		AuthCodeURLOptions  map[string]string
-		ExpectedAuthCodeURL string
-	}{
-		{
-			Name: "plugin tenant without attempted override",
-			PluginOptions: map[string]string{
-				"tenant": "northwind",
-			},
-			ExpectedAuthCodeURL: "https://login.microsoftonline.com/northwind/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := url.ParseQuery(string(b))
+		require.NoError(t, err)
+
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", data.Get("grant_type"))
+		assert.Equal(t, "efgh", data.Get("subject_token"))
+		assert.Equal(t, provider.TokenTypeRefreshToken, data.Get("subject_token_type"))
+		assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", data.Get("requested_token_type"))
+		assert.Equal(t, "actor", data.Get("actor_token"))
+		assert.Equal(t, provider.TokenTypeIDToken, data.Get("actor_token_type"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"qrst","issued_token_type":"urn:ietf:params:oauth:token-type:jwt","token_type":"N_A","refresh_token":"uvwx","expires_in":120}`))
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	subject := &provider.Token{
+		Token: &oauth2.Token{
+			AccessToken:  "abcd",
+			RefreshToken: "efgh",
 		},
-		{
-			Name: "plugin tenant with attempted override",
-			PluginOptions: map[string]string{
-				"tenant": "northwind",
-			},
-			AuthCodeURLOptions: map[string]string{
-				"tenant": "contoso",
-			},
-			ExpectedAuthCodeURL: "https://login.microsoftonline.com/northwind/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+	}
+
+	token, err := ops.TokenExchange(
+		ctx,
+		subject,
+		provider.WithSubjectTokenType(provider.TokenTypeRefreshToken),
+		provider.WithRequestedTokenType("urn:ietf:params:oauth:token-type:jwt"),
+		provider.WithActorToken{Token: "actor", TokenType: provider.TokenTypeIDToken},
+	)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "qrst", token.AccessToken)
+	assert.Equal(t, "uvwx", token.RefreshToken)
+	assert.Equal(t, "urn:ietf:params:oauth:token-type:jwt", token.ExtraData["issued_token_type"])
+
+	// The subject does not have an ID token.
+	_, err = ops.TokenExchange(ctx, subject, provider.WithSubjectTokenType(provider.TokenTypeIDToken))
+	require.ErrorIs(t, err, provider.ErrMissingSubjectToken)
+}
+
+func TestBasicDeviceCodeClientAuthentication(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", provider.BasicFactory(provider.Endpoint{
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  "http://localhost/token",
+			AuthStyle: oauth2.AuthStyleInHeader,
 		},
+		DeviceURL: "http://localhost/device",
+	}))
+
+	var expectSecret bool
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		data, err := url.ParseQuery(string(b))
+		require.NoError(t, err)
+
+		assert.Equal(t, "foo", data.Get("client_id"))
+		assert.Empty(t, data.Get("client_secret"))
+
+		user, pass, ok := r.BasicAuth()
+		if expectSecret {
+			assert.True(t, ok)
+			assert.Equal(t, "foo", user)
+			assert.Equal(t, "bar", pass)
+		} else {
+			assert.False(t, ok)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/device":
+			assert.Equal(t, "a b", data.Get("scope"))
+
+			_, _ = w.Write([]byte(`{"device_code":"dc","user_code":"ABCD-1234","verification_uri":"http://localhost/verify","expires_in":300}`))
+		case "/token":
+			assert.Equal(t, devicecode.GrantType, data.Get("grant_type"))
+			assert.Equal(t, "dc", data.Get("device_code"))
+
+			_, _ = w.Write([]byte(`{"access_token":"abcd","token_type":"Bearer","expires_in":60}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		Name         string
+		Ops          provider.PublicOperations
+		ExpectSecret bool
+	}{
 		{
-			Name: "no plugin tenant",
-			AuthCodeURLOptions: map[string]string{
-				"tenant": "contoso",
-			},
-			ExpectedAuthCodeURL: "https://login.microsoftonline.com/contoso/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+			Name: "Public",
+			Ops:  basicTest.Public("foo"),
 		},
 		{
-			Name:                "no tenant",
-			ExpectedAuthCodeURL: "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+			Name:         "Confidential",
+			Ops:          basicTest.Private("foo", "bar"),
+			ExpectSecret: true,
 		},
-	}
-	for _, test := range tests {
+	} {
 		t.Run(test.Name, func(t *testing.T) {
-			p, err := provider.GlobalRegistry.New(ctx, "microsoft_azure_ad", test.PluginOptions)
+			expectSecret = test.ExpectSecret
+
+			auth, ok, err := test.Ops.DeviceCodeAuth(ctx, provider.WithScopes{"a", "b"})
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, "ABCD-1234", auth.UserCode)
+
+			token, err := test.Ops.DeviceCodeExchange(ctx, auth.DeviceCode)
+			require.NoError(t, err)
+			assert.Equal(t, "abcd", token.AccessToken)
+		})
+	}
+}
+
+func TestBasicPrivateKeyJWT(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Empty(t, data.Get("client_secret"))
+			assert.Equal(t, clientauth.ClientAssertionTypeJWTBearer, data.Get("client_assertion_type"))
+
+			tok, err := jwt.ParseSigned(data.Get("client_assertion"))
+			require.NoError(t, err)
+
+			var claims jwt.Claims
+			require.NoError(t, tok.Claims(&pk.PublicKey, &claims))
+			assert.Equal(t, "foo", claims.Issuer)
+			assert.Equal(t, "foo", claims.Subject)
+			assert.Equal(t, jwt.Audience{"http://localhost/token"}, claims.Audience)
+			assert.NotEmpty(t, claims.ID)
+
+			_, _ = w.Write([]byte(`access_token=abcd&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "")
+
+	// Without an authenticator, the client has no credentials.
+	_, err = ops.ClientCredentials(ctx)
+	require.ErrorIs(t, err, provider.ErrMissingClientSecret)
+
+	ctx = clientauth.WithAuthenticator(ctx, &clientauth.PrivateKeyJWT{
+		ClientID: "foo",
+		Key:      &jwtkey.Key{Signer: pk, Algorithm: jose.ES256},
+	})
+
+	token, err := ops.AuthCodeExchange(ctx, "123456")
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+
+	token, err = ops.ClientCredentials(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestBasicPrivateKeyJWTDPoPNonce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	dk, err := dpop.GenerateKey()
+	require.NoError(t, err)
+
+	key, err := dpop.ParseKey(dk)
+	require.NoError(t, err)
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	var ids []string
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			tok, err := jwt.ParseSigned(data.Get("client_assertion"))
+			require.NoError(t, err)
+
+			var claims jwt.Claims
+			require.NoError(t, tok.Claims(&pk.PublicKey, &claims))
+			ids = append(ids, claims.ID)
+
+			proof, err := jwt.ParseSigned(r.Header.Get(dpop.HeaderName))
+			require.NoError(t, err)
+
+			var proofClaims struct {
+				Nonce string `json:"nonce"`
+			}
+			require.NoError(t, proof.UnsafeClaimsWithoutVerification(&proofClaims))
+
+			w.Header().Set("Content-Type", "application/json")
+			if proofClaims.Nonce != "server-nonce" {
+				w.Header().Set(dpop.NonceHeaderName, "server-nonce")
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"use_dpop_nonce"}`))
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"access_token":"abcd","token_type":"DPoP","expires_in":60}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+	ctx = clientauth.WithAuthenticator(ctx, &clientauth.PrivateKeyJWT{
+		ClientID: "foo",
+		Key:      &jwtkey.Key{Signer: pk, Algorithm: jose.ES256},
+	})
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "")
+
+	// Each request is retried once with the nonce the server provides, and the
+	// retry must not reuse the client assertion.
+	token, err := ops.ClientCredentials(dpop.WithKey(ctx, key))
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+	require.Len(t, ids, 2)
+	assert.NotEqual(t, ids[0], ids[1])
+
+	ids = nil
+
+	token, err = ops.DeviceCodeExchange(dpop.WithKey(ctx, key), "123456")
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+	require.Len(t, ids, 2)
+	assert.NotEqual(t, ids[0], ids[1])
+}
+
+func TestBasicJWTBearer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, assertion.GrantTypeJWTBearer, data.Get("grant_type"))
+			assert.Equal(t, "read write", data.Get("scope"))
+
+			tok, err := jwt.ParseSigned(data.Get("assertion"))
+			require.NoError(t, err)
+
+			var claims jwt.Claims
+			var extra struct {
+				Tenant string `json:"tenant"`
+			}
+			require.NoError(t, tok.Claims(&pk.PublicKey, &claims, &extra))
+			assert.Equal(t, "foo", claims.Issuer)
+			assert.Equal(t, "user@example.com", claims.Subject)
+			assert.Equal(t, jwt.Audience{"http://localhost/token"}, claims.Audience)
+			assert.NotEmpty(t, claims.ID)
+			assert.Equal(t, "acme", extra.Tenant)
+
+			_, _ = w.Write([]byte(`access_token=abcd&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	// Client authentication is optional for this grant.
+	ops := basicTest.Private("foo", "")
+
+	token, err := ops.JWTBearer(
+		ctx,
+		&jwtkey.Key{Signer: pk, Algorithm: jose.ES256},
+		provider.WithAssertionClaims{
+			Subject: "user@example.com",
+			Claims: map[string]interface{}{
+				"tenant": "acme",
+				"sub":    "ignored",
+			},
+		},
+		provider.WithScopes{"read", "write"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestBasicSAML2Bearer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
 			require.NoError(t, err)
 
-			u, ok := p.Public("foo").AuthCodeURL("123456", provider.WithProviderOptions(test.AuthCodeURLOptions))
+			assert.Equal(t, assertion.GrantTypeSAML2Bearer, data.Get("grant_type"))
+			assert.Equal(t, "PHNhbWw6QXNzZXJ0aW9uLz4", data.Get("assertion"))
+			assert.Equal(t, "read", data.Get("scope"))
+
+			_, _ = w.Write([]byte(`access_token=abcd&refresh_token=efgh&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	token, err := ops.SAML2Bearer(ctx, "PHNhbWw6QXNzZXJ0aW9uLz4", provider.WithScopes{"read"})
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+	require.Equal(t, "efgh", token.RefreshToken)
+}
+
+func TestBasicOnBehalfOf(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, assertion.GrantTypeJWTBearer, data.Get("grant_type"))
+			assert.Equal(t, "upstream", data.Get("assertion"))
+			assert.Equal(t, "on_behalf_of", data.Get("requested_token_use"))
+			assert.Equal(t, "api://downstream/.default", data.Get("scope"))
+
+			_, _ = w.Write([]byte(`access_token=abcd&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	token, err := ops.OnBehalfOf(ctx, &provider.Token{Token: &oauth2.Token{AccessToken: "upstream"}}, provider.WithScopes{"api://downstream/.default"})
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestBasicRevoke(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", provider.BasicFactory(provider.Endpoint{
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  "http://localhost/token",
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+		RevocationURL: "http://localhost/revoke",
+	}))
+	r.MustRegister("norevoke", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/revoke":
+			user, pass, ok := r.BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "foo", user)
+			assert.Equal(t, "bar", pass)
+
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "efgh", r.PostForm.Get("token"))
+			assert.Equal(t, "refresh_token", r.PostForm.Get("token_type_hint"))
+
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ok, err := basicTest.Private("foo", "bar").Revoke(ctx, "efgh", provider.WithTokenTypeHint("refresh_token"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	noRevokeTest, err := r.New(ctx, "norevoke", map[string]string{})
+	require.NoError(t, err)
+
+	ok, err = noRevokeTest.Private("foo", "bar").Revoke(ctx, "efgh")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestBasicIntrospect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", provider.BasicFactory(provider.Endpoint{
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  "http://localhost/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		IntrospectionURL: "http://localhost/introspect",
+	}))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/introspect":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "foo", r.PostForm.Get("client_id"))
+			assert.Equal(t, "bar", r.PostForm.Get("client_secret"))
+			assert.Equal(t, "abcd", r.PostForm.Get("token"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"active":true,"scope":"read write","sub":"alice","exp":1419356238,"aud":["a","b"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	res, ok, err := basicTest.Private("foo", "bar").Introspect(ctx, "abcd")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, res.Active)
+	assert.Equal(t, "read write", res.Scope)
+	assert.Equal(t, "alice", res.Subject)
+	assert.Equal(t, int64(1419356238), res.Expiry)
+
+	_, _, err = basicTest.Private("foo", "").Introspect(ctx, "abcd")
+	require.ErrorIs(t, err, provider.ErrMissingClientSecret)
+}
+
+func TestBasicPasswordCredentials(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "password", data.Get("grant_type"))
+			assert.Equal(t, "svc", data.Get("username"))
+			assert.Equal(t, "hunter2", data.Get("password"))
+			assert.Equal(t, "read", data.Get("scope"))
+
+			_, _ = w.Write([]byte(`access_token=abcd&refresh_token=efgh&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	token, err := ops.PasswordCredentials(ctx, "svc", "hunter2", provider.WithScopes{"read"})
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+	require.Equal(t, "efgh", token.RefreshToken)
+}
+
+func TestBasicBackchannelAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", provider.BasicFactory(provider.Endpoint{
+		Endpoint: oauth2.Endpoint{
+			TokenURL:  "http://localhost/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		BackchannelAuthURL: "http://localhost/bc-authorize",
+	}))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "foo", r.PostForm.Get("client_id"))
+		assert.Equal(t, "bar", r.PostForm.Get("client_secret"))
+
+		switch r.URL.Path {
+		case "/bc-authorize":
+			assert.Equal(t, "openid", r.PostForm.Get("scope"))
+			assert.Equal(t, "user@example.com", r.PostForm.Get("login_hint"))
+			assert.Equal(t, "W4SCT", r.PostForm.Get("binding_message"))
+
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"auth_req_id":"abc","expires_in":120,"interval":2}`))
+		case "/token":
+			assert.Equal(t, ciba.GrantType, r.PostForm.Get("grant_type"))
+			assert.Equal(t, "abc", r.PostForm.Get("auth_req_id"))
+
+			_, _ = w.Write([]byte(`access_token=abcd&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	ops := basicTest.Private("foo", "bar")
+
+	auth, ok, err := ops.BackchannelAuth(
+		ctx,
+		provider.WithLoginHint("user@example.com"),
+		provider.WithBindingMessage("W4SCT"),
+		provider.WithScopes{"openid"},
+	)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, &ciba.Auth{AuthReqID: "abc", ExpiresIn: 120, Interval: 2}, auth)
+
+	token, err := ops.BackchannelAuthExchange(ctx, auth.AuthReqID)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestBasicPKCE(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	r := provider.NewRegistry()
+	r.MustRegister("basic", basicTestFactory)
+
+	verifier := oauth2.GenerateVerifier()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "authorization_code", data.Get("grant_type"))
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Empty(t, data.Get("client_secret"))
+			assert.Equal(t, verifier, data.Get("code_verifier"))
+
+			_, _ = w.Write([]byte(`access_token=abcd&token_type=bearer&expires_in=60`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	basicTest, err := r.New(ctx, "basic", map[string]string{})
+	require.NoError(t, err)
+
+	authCodeURL, ok, err := basicTest.Public("foo").AuthCodeURL("state", provider.WithCodeVerifier(verifier))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+
+	qs := u.Query()
+	assert.Equal(t, oauth2.S256ChallengeFromVerifier(verifier), qs.Get("code_challenge"))
+	assert.Equal(t, "S256", qs.Get("code_challenge_method"))
+
+	// A public client can only exchange a code if it has a verifier.
+	ops := basicTest.Private("foo", "")
+
+	_, err = ops.AuthCodeExchange(ctx, "123456")
+	require.ErrorIs(t, err, provider.ErrMissingClientSecret)
+
+	token, err := ops.AuthCodeExchange(ctx, "123456", provider.WithCodeVerifier(verifier))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	require.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestCustomPushedAuthCodeURL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/par":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Equal(t, "bar", data.Get("client_secret"))
+			assert.Equal(t, "code", data.Get("response_type"))
+			assert.Equal(t, "state", data.Get("state"))
+			assert.Equal(t, "http://example.com/redirect", data.Get("redirect_uri"))
+			assert.Equal(t, "a b", data.Get("scope"))
+			assert.Equal(t, "S256", data.Get("code_challenge_method"))
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"request_uri":"urn:ietf:params:oauth:request_uri:abcd","expires_in":60}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"auth_code_url":   "http://localhost/authorize",
+		"token_url":       "http://localhost/token",
+		"pushed_auth_url": "http://localhost/par",
+		"auth_style":      "in_params",
+	})
+	require.NoError(t, err)
+
+	authCodeURL, ok, err := customTest.Private("foo", "bar").PushedAuthCodeURL(
+		ctx,
+		"state",
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithScopes{"a", "b"},
+		provider.WithCodeVerifier(oauth2.GenerateVerifier()),
+	)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "http://localhost/authorize?client_id=foo&request_uri=urn%3Aietf%3Aparams%3Aoauth%3Arequest_uri%3Aabcd", authCodeURL)
+
+	// Without a pushed authorization request URL, the operation is not
+	// supported.
+	customTest, err = provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"auth_code_url": "http://localhost/authorize",
+		"token_url":     "http://localhost/token",
+	})
+	require.NoError(t, err)
+
+	_, ok, err = customTest.Private("foo", "bar").PushedAuthCodeURL(ctx, "state")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestCustomServerMetadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/oauth-authorization-server/tenant":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"issuer": "http://localhost/tenant",
+				"authorization_endpoint": "http://localhost/tenant/authorize",
+				"token_endpoint": "http://localhost/tenant/token",
+				"revocation_endpoint": "http://localhost/tenant/revoke",
+				"pushed_authorization_request_endpoint": "http://localhost/tenant/par",
+				"token_endpoint_auth_methods_supported": ["client_secret_basic", "client_secret_post"],
+				"code_challenge_methods_supported": ["plain", "S256"]
+			}`))
+		case "/.well-known/oauth-authorization-server/strict":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{
+				"issuer": "http://localhost/strict",
+				"authorization_endpoint": "http://localhost/strict/authorize",
+				"token_endpoint": "http://localhost/strict/token",
+				"pushed_authorization_request_endpoint": "http://localhost/strict/par",
+				"require_pushed_authorization_requests": true
+			}`))
+		case "/revoke":
+			require.NoError(t, r.ParseForm())
+			assert.Equal(t, "foo", r.PostForm.Get("client_id"))
+			assert.Equal(t, "bar", r.PostForm.Get("client_secret"))
+			assert.Equal(t, "abcd", r.PostForm.Get("token"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	// Explicit options take precedence over the metadata. Advertising a
+	// pushed authorization request endpoint does not make it mandatory.
+	customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"issuer_url":     "http://localhost/tenant",
+		"revocation_url": "http://localhost/revoke",
+	})
+	require.NoError(t, err)
+
+	authCodeURL, ok, err := customTest.Public("foo").AuthCodeURL("state")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.True(t, strings.HasPrefix(authCodeURL, "http://localhost/tenant/authorize?"), authCodeURL)
+	assert.True(t, customTest.Public("foo").PKCESupported())
+
+	// If the server requires pushed authorization requests, a plain URL is
+	// not available.
+	strictTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"issuer_url": "http://localhost/strict",
+	})
+	require.NoError(t, err)
+
+	_, ok, err = strictTest.Public("foo").AuthCodeURL("state")
+	require.NoError(t, err)
+	require.False(t, ok)
+	assert.False(t, strictTest.Public("foo").PKCESupported())
+
+	ok, err = customTest.Private("foo", "bar").Revoke(ctx, "abcd")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// The issuer in the metadata must match.
+	_, err = provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"issuer_url": "http://localhost/tenant/",
+	})
+	require.Error(t, err)
+}
+
+func TestCustomRequestObject(t *testing.T) {
+	ctx := context.Background()
+
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	key := &jwtkey.Key{Signer: pk, KeyID: "k1", Algorithm: jose.ES256}
+
+	customTest, err := provider.GlobalRegistry.New(ctx, "custom", map[string]string{
+		"auth_code_url": "http://localhost/authorize",
+		"token_url":     "http://localhost/token",
+		"issuer":        "http://localhost",
+	})
+	require.NoError(t, err)
+
+	authCodeURL, ok, err := customTest.Public("foo").AuthCodeURL(
+		"state",
+		provider.WithRedirectURL("http://example.com/redirect"),
+		provider.WithScopes{"a", "b"},
+		provider.WithURLParams{"prompt": "consent"},
+		provider.WithRequestObjectKey{Key: key},
+	)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", u.Host)
+	assert.Equal(t, "/authorize", u.Path)
+
+	q := u.Query()
+	assert.Equal(t, "foo", q.Get("client_id"))
+	assert.Equal(t, "code", q.Get("response_type"))
+	assert.Equal(t, "a b", q.Get("scope"))
+	assert.Empty(t, q.Get("state"))
+	assert.Empty(t, q.Get("redirect_uri"))
+	assert.Empty(t, q.Get("prompt"))
+
+	tok, err := jwt.ParseSigned(q.Get("request"))
+	require.NoError(t, err)
+	require.Len(t, tok.Headers, 1)
+	assert.Equal(t, "k1", tok.Headers[0].KeyID)
+	assert.Equal(t, "ES256", tok.Headers[0].Algorithm)
+
+	claims := make(map[string]interface{})
+	require.NoError(t, tok.Claims(&pk.PublicKey, &claims))
+	assert.Equal(t, "foo", claims["iss"])
+	assert.Equal(t, "http://localhost", claims["aud"])
+	assert.Equal(t, "foo", claims["client_id"])
+	assert.Equal(t, "state", claims["state"])
+	assert.Equal(t, "http://example.com/redirect", claims["redirect_uri"])
+	assert.Equal(t, "consent", claims["prompt"])
+	assert.Equal(t, "a b", claims["scope"])
+	assert.Contains(t, claims, "exp")
+
+	// A key that cannot sign the request object is reported instead of
+	// silently failing to produce a URL.
+	_, ok, err = customTest.Public("foo").AuthCodeURL(
+		"state",
+		provider.WithRequestObjectKey{Key: &jwtkey.Key{Signer: pk, Algorithm: jose.RS256}},
+	)
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestAzureADEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		Name                string
+		PluginOptions       map[string]string
+		AuthCodeURLOptions  map[string]string
+		ExpectedAuthCodeURL string
+	}{
+		{
+			Name: "plugin tenant without attempted override",
+			PluginOptions: map[string]string{
+				"tenant": "northwind",
+			},
+			ExpectedAuthCodeURL: "https://login.microsoftonline.com/northwind/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+		},
+		{
+			Name: "plugin tenant with attempted override",
+			PluginOptions: map[string]string{
+				"tenant": "northwind",
+			},
+			AuthCodeURLOptions: map[string]string{
+				"tenant": "contoso",
+			},
+			ExpectedAuthCodeURL: "https://login.microsoftonline.com/northwind/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+		},
+		{
+			Name: "no plugin tenant",
+			AuthCodeURLOptions: map[string]string{
+				"tenant": "contoso",
+			},
+			ExpectedAuthCodeURL: "https://login.microsoftonline.com/contoso/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+		},
+		{
+			Name:                "no tenant",
+			ExpectedAuthCodeURL: "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize?client_id=foo&response_type=code&state=123456",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			p, err := provider.GlobalRegistry.New(ctx, "microsoft_azure_ad", test.PluginOptions)
+			require.NoError(t, err)
+
+			u, ok, err := p.Public("foo").AuthCodeURL("123456", provider.WithProviderOptions(test.AuthCodeURLOptions))
+			require.NoError(t, err)
 			require.True(t, ok)
 			require.Equal(t, test.ExpectedAuthCodeURL, u)
 		})
@@ -10,6 +10,7 @@ var (
 	ErrNoProviderWithVersion = errors.New("version not supported")
 	ErrNoOptions             = errors.New("options provided but none accepted")
 	ErrMissingClientSecret   = errors.New("missing client secret in configuration")
+	ErrMissingSubjectToken   = errors.New("token does not have a subject token of the requested type")
 )
 
 type OptionError struct {
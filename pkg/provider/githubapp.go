@@ -0,0 +1,412 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
+	"github.com/openbao/openbao/sdk/v2/helper/parseutil"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const (
+	githubAppDefaultBaseURL = "https://api.github.com"
+	githubAppAPIVersion     = "2022-11-28"
+
+	// GitHub rejects app JWTs that are valid for more than 10 minutes. We
+	// backdate them slightly to allow for clock drift, as GitHub recommends.
+	githubAppJWTClockSkew = 60 * time.Second
+	githubAppJWTLifetime  = 9 * time.Minute
+)
+
+var (
+	ErrGitHubAppUnsupportedOperation = errors.New("github_app: operation not supported; use a client credentials (self) entry")
+	ErrGitHubAppMissingInstallation  = errors.New("github_app: installation_id is required")
+	ErrGitHubAppScopes               = errors.New("github_app: scopes and token URL parameters are not supported; use the permissions option instead")
+)
+
+func init() {
+	GlobalRegistry.MustRegister("github_app", GitHubAppFactory)
+}
+
+// githubAppInstallationTokenRequest is the body of a request to create an
+// installation access token.
+//
+// https://docs.github.com/en/rest/apps/apps#create-an-installation-access-token-for-an-app
+type githubAppInstallationTokenRequest struct {
+	Repositories  []string          `json:"repositories,omitempty"`
+	RepositoryIDs []int64           `json:"repository_ids,omitempty"`
+	Permissions   map[string]string `json:"permissions,omitempty"`
+}
+
+type githubAppInstallationTokenResponse struct {
+	Token               string            `json:"token"`
+	ExpiresAt           time.Time         `json:"expires_at"`
+	Permissions         map[string]string `json:"permissions"`
+	RepositorySelection string            `json:"repository_selection"`
+}
+
+type githubAppErrorResponse struct {
+	Message          string `json:"message"`
+	DocumentationURL string `json:"documentation_url"`
+}
+
+// githubAppMapError converts an error from the GitHub REST API, which does not
+// use the OAuth 2.0 error response format, into a descriptive error. Requests
+// rejected because of the app configuration are marked as user errors.
+func githubAppMapError(err error) error {
+	var rerr *oauth2.RetrieveError
+	if !errors.As(err, &rerr) {
+		return semerr.Map(err)
+	}
+
+	msg := fmt.Sprintf("github_app: server rejected request: %s", rerr.Response.Status)
+
+	var env githubAppErrorResponse
+	if json.Unmarshal(rerr.Body, &env) == nil && env.Message != "" {
+		msg += ": " + env.Message
+		if env.DocumentationURL != "" {
+			msg += " (see " + env.DocumentationURL + ")"
+		}
+	}
+
+	switch rerr.Response.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusUnprocessableEntity:
+		return errmark.MarkUser(errors.New(msg))
+	default:
+		return errors.New(msg)
+	}
+}
+
+type githubAppCredentialOptions struct {
+	InstallationID string
+	Request        githubAppInstallationTokenRequest
+}
+
+func parseGitHubAppCredentialOptions(defaultInstallationID string, opts map[string]string) (*githubAppCredentialOptions, error) {
+	o := &githubAppCredentialOptions{
+		InstallationID: defaultInstallationID,
+	}
+
+	if id, ok := opts["installation_id"]; ok && id != "" {
+		o.InstallationID = id
+	}
+	if o.InstallationID == "" {
+		return nil, ErrGitHubAppMissingInstallation
+	} else if _, err := strconv.ParseInt(o.InstallationID, 10, 64); err != nil {
+		return nil, &OptionError{Option: "installation_id", Cause: fmt.Errorf("must be an integer")}
+	}
+
+	if repos := opts["repositories"]; repos != "" {
+		names, err := parseutil.ParseCommaStringSlice(repos)
+		if err != nil {
+			return nil, &OptionError{Option: "repositories", Cause: fmt.Errorf("invalid format (expected a comma-separated list): %w", err)}
+		}
+
+		o.Request.Repositories = names
+	}
+
+	if repoIDs := opts["repository_ids"]; repoIDs != "" {
+		ids, err := parseutil.ParseCommaStringSlice(repoIDs)
+		if err != nil {
+			return nil, &OptionError{Option: "repository_ids", Cause: fmt.Errorf("invalid format (expected a comma-separated list): %w", err)}
+		}
+
+		for _, id := range ids {
+			n, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return nil, &OptionError{Option: "repository_ids", Cause: fmt.Errorf("repository ID %q must be an integer", id)}
+			}
+
+			o.Request.RepositoryIDs = append(o.Request.RepositoryIDs, n)
+		}
+	}
+
+	if perms := opts["permissions"]; perms != "" {
+		pairs, err := parseutil.ParseCommaStringSlice(perms)
+		if err != nil {
+			return nil, &OptionError{Option: "permissions", Cause: fmt.Errorf("invalid format (expected a comma-separated list): %w", err)}
+		}
+
+		o.Request.Permissions = make(map[string]string, len(pairs))
+		for _, pair := range pairs {
+			name, level, found := strings.Cut(pair, ":")
+			if !found || name == "" || level == "" {
+				return nil, &OptionError{Option: "permissions", Cause: fmt.Errorf("permission %q must have the form name:level", pair)}
+			}
+
+			o.Request.Permissions[name] = level
+		}
+	}
+
+	return o, nil
+}
+
+type githubAppOperations struct {
+	vsn                   int
+	baseURL               string
+	defaultInstallationID string
+	clientID              string
+	clientSecret          string
+}
+
+var _ PrivateOperations = &githubAppOperations{}
+
+func (gao *githubAppOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	return "", false, nil
+}
+
+func (gao *githubAppOperations) LogoutURL(idTokenHint string, opts ...LogoutURLOption) (string, bool) {
+	return "", false
+}
+
+func (gao *githubAppOperations) PKCESupported(opts ...AuthCodeURLOption) bool {
+	return false
+}
+
+func (gao *githubAppOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
+	return nil, false, nil
+}
+
+func (gao *githubAppOperations) DeviceCodeExchange(ctx context.Context, deviceCode string, opts ...DeviceCodeExchangeOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) VerifyLogoutToken(ctx context.Context, token string) (*LogoutToken, bool, error) {
+	return nil, false, nil
+}
+
+func (gao *githubAppOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	return "", false, nil
+}
+
+func (gao *githubAppOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+// appJWT signs a JWT that authenticates as the GitHub App itself using its
+// private key, which is configured as the client secret.
+//
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (gao *githubAppOperations) appJWT(ctx context.Context) (string, error) {
+	key, err := jwtkey.ParsePEM(gao.clientSecret, "", "RS256")
+	if err != nil {
+		return "", errmark.MarkUser(fmt.Errorf("github_app: invalid private key: %w", err))
+	}
+
+	now := clockctx.Clock(ctx).Now()
+	return key.Sign(&jwt.Claims{
+		Issuer:   gao.clientID,
+		IssuedAt: jwt.NewNumericDate(now.Add(-githubAppJWTClockSkew)),
+		Expiry:   jwt.NewNumericDate(now.Add(githubAppJWTLifetime)),
+	})
+}
+
+func (gao *githubAppOperations) ClientCredentials(ctx context.Context, opts ...ClientCredentialsOption) (*Token, error) {
+	if gao.clientSecret == "" {
+		return nil, errmark.MarkUser(ErrMissingClientSecret)
+	}
+
+	o := &ClientCredentialsOptions{}
+	o.ApplyOptions(opts)
+
+	if len(o.Scopes) > 0 || len(o.EndpointParams) > 0 {
+		return nil, errmark.MarkUser(ErrGitHubAppScopes)
+	}
+
+	co, err := parseGitHubAppCredentialOptions(gao.defaultInstallationID, o.ProviderOptions)
+	if err != nil {
+		return nil, errmark.MarkUser(err)
+	}
+
+	assertion, err := gao.appJWT(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(co.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, gao.baseURL+"/app/installations/"+co.InstallationID+"/access_tokens", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+assertion)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Api-Version", githubAppAPIVersion)
+
+	b, err := clientauth.Retrieve(ctx, req)
+	if err != nil {
+		return nil, githubAppMapError(err)
+	}
+
+	var resp githubAppInstallationTokenResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, fmt.Errorf("github_app: cannot parse response: %w", err)
+	} else if resp.Token == "" {
+		return nil, errors.New("github_app: server response missing token")
+	}
+
+	tok := &Token{
+		Token: &oauth2.Token{
+			AccessToken: resp.Token,
+			TokenType:   "Bearer",
+			Expiry:      resp.ExpiresAt,
+		},
+		ExtraData: make(map[string]interface{}),
+
+		ProviderVersion: gao.vsn,
+		ProviderOptions: o.ProviderOptions,
+	}
+	if len(resp.Permissions) > 0 {
+		tok.ExtraData["permissions"] = resp.Permissions
+	}
+	if resp.RepositorySelection != "" {
+		tok.ExtraData["repository_selection"] = resp.RepositorySelection
+	}
+
+	return tok, nil
+}
+
+func (gao *githubAppOperations) TokenExchange(ctx context.Context, t *Token, opts ...TokenExchangeOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...JWTBearerOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) SAML2Bearer(ctx context.Context, assertion string, opts ...SAML2BearerOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...PasswordCredentialsOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) BackchannelAuth(ctx context.Context, opts ...BackchannelAuthOption) (*ciba.Auth, bool, error) {
+	return nil, false, nil
+}
+
+func (gao *githubAppOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...BackchannelAuthExchangeOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+func (gao *githubAppOperations) OnBehalfOf(ctx context.Context, t *Token, opts ...OnBehalfOfOption) (*Token, error) {
+	return nil, errmark.MarkUser(ErrGitHubAppUnsupportedOperation)
+}
+
+// Revoke invalidates an installation access token. The token authorizes its
+// own revocation, so the app private key is not needed.
+//
+// https://docs.github.com/en/rest/apps/installations#revoke-an-installation-access-token
+func (gao *githubAppOperations) Revoke(ctx context.Context, token string, opts ...RevokeOption) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, gao.baseURL+"/installation/token", nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-GitHub-Api-Version", githubAppAPIVersion)
+
+	if _, err := clientauth.Retrieve(ctx, req); err != nil {
+		return false, githubAppMapError(err)
+	}
+
+	return true, nil
+}
+
+func (gao *githubAppOperations) Introspect(ctx context.Context, token string, opts ...IntrospectOption) (*introspection.Result, bool, error) {
+	return nil, false, nil
+}
+
+type githubApp struct {
+	vsn                   int
+	baseURL               string
+	defaultInstallationID string
+}
+
+func (ga *githubApp) Version() int {
+	return ga.vsn
+}
+
+func (ga *githubApp) Public(clientID string) PublicOperations {
+	return ga.Private(clientID, "")
+}
+
+func (ga *githubApp) Private(clientID, clientSecret string) PrivateOperations {
+	return &githubAppOperations{
+		vsn:                   ga.vsn,
+		baseURL:               ga.baseURL,
+		defaultInstallationID: ga.defaultInstallationID,
+		clientID:              clientID,
+		clientSecret:          clientSecret,
+	}
+}
+
+// GitHubAppFactory creates providers that issue GitHub App installation access
+// tokens. The client ID is the app ID (or the app's client ID) and the client
+// secret is the PEM-encoded private key of the app.
+func GitHubAppFactory(ctx context.Context, vsn int, opts map[string]string) (Provider, error) {
+	vsn = selectVersion(vsn, 1)
+
+	switch vsn {
+	case 1:
+	default:
+		return nil, ErrNoProviderWithVersion
+	}
+
+	p := &githubApp{
+		vsn:     vsn,
+		baseURL: githubAppDefaultBaseURL,
+	}
+
+	for k, v := range opts {
+		switch k {
+		case "base_url":
+			if v == "" {
+				continue
+			}
+
+			u, err := url.Parse(v)
+			if err != nil || !u.IsAbs() || u.Host == "" {
+				return nil, &OptionError{Option: k, Cause: fmt.Errorf("must be an absolute URL")}
+			}
+
+			p.baseURL = strings.TrimSuffix(u.String(), "/")
+		case "installation_id":
+			if _, err := strconv.ParseInt(v, 10, 64); v != "" && err != nil {
+				return nil, &OptionError{Option: k, Cause: fmt.Errorf("must be an integer")}
+			}
+
+			p.defaultInstallationID = v
+		default:
+			return nil, &OptionError{Option: k, Cause: fmt.Errorf("unknown option")}
+		}
+	}
+
+	return p, nil
+}
@@ -0,0 +1,144 @@
+package provider_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/testutil"
+	"github.com/puppetlabs/leg/errmap/pkg/errmark"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkPEM := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(pk),
+	}))
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "ghe.example.com", r.Host)
+
+		switch r.URL.Path {
+		case "/api/v3/app/installations/1234/access_tokens":
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/vnd.github+json", r.Header.Get("Accept"))
+
+			tok, err := jwt.ParseSigned(r.Header.Get("Authorization")[len("Bearer "):])
+			require.NoError(t, err)
+
+			var claims jwt.Claims
+			require.NoError(t, tok.Claims(&pk.PublicKey, &claims))
+			assert.Equal(t, "42", claims.Issuer)
+			assert.NoError(t, claims.Validate(jwt.Expected{Time: time.Now()}))
+			assert.LessOrEqual(t, claims.Expiry.Time().Sub(claims.IssuedAt.Time()), 10*time.Minute)
+
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			var body map[string]interface{}
+			require.NoError(t, json.Unmarshal(b, &body))
+			assert.Equal(t, []interface{}{"hello-world"}, body["repositories"])
+			assert.Equal(t, []interface{}{float64(5678)}, body["repository_ids"])
+			assert.Equal(t, map[string]interface{}{"contents": "read", "issues": "write"}, body["permissions"])
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"token":                "ghs_abcd",
+				"expires_at":           expiry,
+				"permissions":          map[string]string{"contents": "read", "issues": "write"},
+				"repository_selection": "selected",
+			})
+		case "/api/v3/app/installations/9999/access_tokens":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Not Found","documentation_url":"https://docs.github.com/rest"}`))
+		case "/api/v3/installation/token":
+			assert.Equal(t, http.MethodDelete, r.Method)
+
+			if r.Header.Get("Authorization") != "Bearer ghs_abcd" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"message":"Bad credentials","documentation_url":"https://docs.github.com/rest"}`))
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	p, err := provider.GlobalRegistry.New(ctx, "github_app", map[string]string{
+		"base_url":        "https://ghe.example.com/api/v3/",
+		"installation_id": "1234",
+	})
+	require.NoError(t, err)
+
+	ops := p.Private("42", pkPEM)
+
+	token, err := ops.ClientCredentials(ctx, provider.WithProviderOptions{
+		"repositories":   "hello-world",
+		"repository_ids": "5678",
+		"permissions":    "contents:read,issues:write",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_abcd", token.AccessToken)
+	assert.Equal(t, "Bearer", token.TokenType)
+	assert.True(t, expiry.Equal(token.Expiry))
+	assert.Equal(t, "selected", token.ExtraData["repository_selection"])
+
+	ok, err := ops.Revoke(ctx, token.AccessToken)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// A failed revocation is reported as such.
+	ok, err = ops.Revoke(ctx, "ghs_invalid")
+	require.Error(t, err)
+	assert.False(t, ok)
+
+	// A missing installation is a configuration problem.
+	_, err = ops.ClientCredentials(ctx, provider.WithProviderOptions{"installation_id": "9999"})
+	require.Error(t, err)
+	assert.True(t, errmark.MarkedUser(err))
+	assert.Contains(t, err.Error(), "Not Found")
+
+	// So are invalid options.
+	_, err = ops.ClientCredentials(ctx, provider.WithProviderOptions{"permissions": "contents"})
+	require.Error(t, err)
+	assert.True(t, errmark.MarkedUser(err))
+
+	_, err = ops.ClientCredentials(ctx, provider.WithScopes{"repo"})
+	require.Error(t, err)
+	assert.True(t, errmark.MarkedUser(err))
+
+	// Without the private key, no tokens can be issued.
+	_, err = p.Private("42", "").ClientCredentials(ctx)
+	require.ErrorIs(t, err, provider.ErrMissingClientSecret)
+
+	_, err = provider.GlobalRegistry.New(ctx, "github_app", map[string]string{"base_url": "ghe.example.com"})
+	require.Error(t, err)
+	assert.True(t, errmark.MarkedUser(err))
+}
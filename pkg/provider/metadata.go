@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/openbao/openbao/sdk/v2/helper/strutil"
+	"golang.org/x/oauth2"
+)
+
+// serverMetadata is the subset of RFC 8414 authorization server metadata that
+// we use. OpenID Connect Discovery 1.0 uses the same members.
+type serverMetadata struct {
+	Issuer                             string   `json:"issuer"`
+	AuthorizationEndpoint              string   `json:"authorization_endpoint"`
+	TokenEndpoint                      string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint        string   `json:"device_authorization_endpoint"`
+	PushedAuthorizationRequestEndpoint string   `json:"pushed_authorization_request_endpoint"`
+	RequirePushedAuthorizationRequests bool     `json:"require_pushed_authorization_requests"`
+	BackchannelAuthenticationEndpoint  string   `json:"backchannel_authentication_endpoint"`
+	RevocationEndpoint                 string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint              string   `json:"introspection_endpoint"`
+	EndSessionEndpoint                 string   `json:"end_session_endpoint"`
+	TokenEndpointAuthMethodsSupported  []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported      []string `json:"code_challenge_methods_supported"`
+	MTLSEndpointAliases                struct {
+		TokenEndpoint                      string `json:"token_endpoint"`
+		DeviceAuthorizationEndpoint        string `json:"device_authorization_endpoint"`
+		PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
+		BackchannelAuthenticationEndpoint  string `json:"backchannel_authentication_endpoint"`
+		RevocationEndpoint                 string `json:"revocation_endpoint"`
+		IntrospectionEndpoint              string `json:"introspection_endpoint"`
+	} `json:"mtls_endpoint_aliases"`
+}
+
+// authStyle picks how to send client secrets to the token endpoint from the
+// advertised "token_endpoint_auth_methods_supported". If it returns true,
+// client secrets should be used to sign a client assertion instead.
+func (sm *serverMetadata) authStyle() (oauth2.AuthStyle, bool) {
+	methods := sm.TokenEndpointAuthMethodsSupported
+
+	switch {
+	case strutil.StrListContains(methods, "client_secret_post"):
+		return oauth2.AuthStyleInParams, false
+	case !strutil.StrListContains(methods, "client_secret_basic") && strutil.StrListContains(methods, "client_secret_jwt"):
+		// Only use signed assertions if the server does not support sending
+		// the secret directly, as they are more expensive to produce.
+		return oauth2.AuthStyleAutoDetect, true
+	default:
+		return oauth2.AuthStyleInHeader, false
+	}
+}
+
+// pkceSupported returns true if the server advertises support for the S256
+// code challenge method in "code_challenge_methods_supported".
+func (sm *serverMetadata) pkceSupported() bool {
+	return strutil.StrListContains(sm.CodeChallengeMethodsSupported, "S256")
+}
+
+// mtlsAliases returns the RFC 8705 § 5 endpoint aliases, or nil if the server
+// does not advertise any.
+func (sm *serverMetadata) mtlsAliases() *Endpoint {
+	aliases := sm.MTLSEndpointAliases
+
+	ep := &Endpoint{
+		Endpoint:           oauth2.Endpoint{TokenURL: aliases.TokenEndpoint},
+		DeviceURL:          aliases.DeviceAuthorizationEndpoint,
+		PushedAuthURL:      aliases.PushedAuthorizationRequestEndpoint,
+		BackchannelAuthURL: aliases.BackchannelAuthenticationEndpoint,
+		RevocationURL:      aliases.RevocationEndpoint,
+		IntrospectionURL:   aliases.IntrospectionEndpoint,
+	}
+	if *ep == (Endpoint{}) {
+		return nil
+	}
+	return ep
+}
+
+// fetchServerMetadata retrieves the RFC 8414 authorization server metadata for
+// the given issuer identifier.
+func fetchServerMetadata(ctx context.Context, issuer string) (*serverMetadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer URL: %w", err)
+	}
+
+	// RFC 8414 § 3.1: the well-known URI suffix goes between the host and the
+	// path of the issuer.
+	u.Path = "/.well-known/oauth-authorization-server" + strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read authorization server metadata: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s retrieving authorization server metadata", resp.Status)
+	}
+
+	sm := &serverMetadata{}
+	if err := json.Unmarshal(body, sm); err != nil {
+		return nil, fmt.Errorf("error decoding authorization server metadata: %w", err)
+	}
+
+	// RFC 8414 § 3.3: the issuer must be identical to the one we used to
+	// construct the request.
+	if sm.Issuer != issuer {
+		return nil, fmt.Errorf("authorization server metadata issuer %q does not match %q", sm.Issuer, issuer)
+	}
+
+	return sm, nil
+}
@@ -3,13 +3,17 @@ package provider
 import (
 	"context"
 	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 
 	gooidc "github.com/coreos/go-oidc"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/dpop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
 	"github.com/openbao/openbao/sdk/v2/helper/parseutil"
-	"github.com/openbao/openbao/sdk/v2/helper/strutil"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
 	"golang.org/x/oauth2"
 )
@@ -20,9 +24,18 @@ const (
 	oidcExtraDataFieldUserInfo      = "user_info"
 )
 
+// oidcBackchannelLogoutEvent is the member of the events claim that
+// identifies a logout token as described by OpenID Connect Back-Channel Logout
+// 1.0 § 2.4.
+const oidcBackchannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
 var (
-	ErrOIDCMissingIDToken = errors.New("oidc: missing ID token in response")
-	ErrOIDCNonceMismatch  = errors.New("oidc: nonce does not match")
+	ErrOIDCMissingIDToken        = errors.New("oidc: missing ID token in response")
+	ErrOIDCNonceMismatch         = errors.New("oidc: nonce does not match")
+	ErrOIDCInvalidLogoutToken    = errors.New("oidc: not a back-channel logout token")
+	ErrOIDCLogoutTokenNoIdentity = errors.New("oidc: logout token must contain a sub or sid claim")
+	ErrOIDCLogoutTokenNoID       = errors.New("oidc: logout token must contain a jti claim")
+	ErrOIDCLogoutTokenNoIssuedAt = errors.New("oidc: logout token must contain an iat claim")
 )
 
 func init() {
@@ -35,7 +48,7 @@ type oidcOperations struct {
 	extraDataFields []string
 }
 
-func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token) error {
+func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token, nonce string) error {
 	rawIDToken, ok := t.Extra("id_token").(string)
 	if !ok || rawIDToken == "" {
 		return ErrOIDCMissingIDToken
@@ -46,10 +59,20 @@ func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token) err
 		return fmt.Errorf("oidc: verification error: %w", err)
 	}
 
+	var sessionClaims struct {
+		SessionID string `json:"sid"`
+	}
+	if err := idToken.Claims(&sessionClaims); err != nil {
+		return fmt.Errorf("oidc: error parsing token claims: %w", err)
+	}
+
+	t.Subject = idToken.Subject
+	t.SessionID = sessionClaims.SessionID
+
 	// If nonce is configured, make sure it matches the nonce in the ID token.
 	// It is not configured when refresh_token is sent in from an external
 	// source.
-	if nonce := t.ProviderOptions["nonce"]; nonce != "" &&
+	if nonce != "" &&
 		(subtle.ConstantTimeEq(int32(len(idToken.Nonce)), int32(len(nonce))) == 0 ||
 			subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) == 0) {
 		return ErrOIDCNonceMismatch
@@ -73,6 +96,9 @@ func (oo *oidcOperations) verifyUpdateIDToken(ctx context.Context, t *Token) err
 }
 
 func (oo *oidcOperations) copyIDToken(ctx context.Context, p, n *Token) {
+	n.Subject = p.Subject
+	n.SessionID = p.SessionID
+
 	for _, field := range oo.extraDataFields {
 		switch field {
 		case oidcExtraDataFieldIDToken, oidcExtraDataFieldIDTokenClaims:
@@ -87,7 +113,8 @@ func (oo *oidcOperations) updateUserInfo(ctx context.Context, t *Token) error {
 			continue
 		}
 
-		userInfo, err := oo.p.UserInfo(ctx, oauth2.StaticTokenSource(t.Token))
+		// DPoP-bound tokens must be presented with a proof.
+		userInfo, err := oo.p.UserInfo(dpop.WithProofs(ctx), oauth2.StaticTokenSource(t.Token))
 		if err != nil {
 			return fmt.Errorf("oidc: error fetching user info: %w", err)
 		}
@@ -104,9 +131,77 @@ func (oo *oidcOperations) updateUserInfo(ctx context.Context, t *Token) error {
 	return nil
 }
 
-func (oo *oidcOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+func (oo *oidcOperations) VerifyLogoutToken(ctx context.Context, token string) (*LogoutToken, bool, error) {
+	// OpenID Connect Back-Channel Logout 1.0 § 2.6: the token is validated
+	// like an ID token.
+	logoutToken, err := oo.p.Verifier(&gooidc.Config{ClientID: oo.delegate.clientID}).Verify(ctx, token)
+	if err != nil {
+		return nil, true, errmark.MarkUser(fmt.Errorf("oidc: verification error: %w", err))
+	}
+
+	var claims struct {
+		ID        string                     `json:"jti"`
+		SessionID string                     `json:"sid"`
+		Nonce     *string                    `json:"nonce"`
+		Events    map[string]json.RawMessage `json:"events"`
+	}
+	if err := logoutToken.Claims(&claims); err != nil {
+		return nil, true, errmark.MarkUser(fmt.Errorf("oidc: error parsing token claims: %w", err))
+	}
+
+	// The events claim distinguishes logout tokens from ID tokens, which
+	// additionally must not contain a nonce.
+	if _, ok := claims.Events[oidcBackchannelLogoutEvent]; !ok || claims.Nonce != nil {
+		return nil, true, errmark.MarkUser(ErrOIDCInvalidLogoutToken)
+	}
+
+	if logoutToken.Subject == "" && claims.SessionID == "" {
+		return nil, true, errmark.MarkUser(ErrOIDCLogoutTokenNoIdentity)
+	}
+
+	// The jti and iat claims let the caller detect replayed tokens.
+	if claims.ID == "" {
+		return nil, true, errmark.MarkUser(ErrOIDCLogoutTokenNoID)
+	} else if logoutToken.IssuedAt.IsZero() {
+		return nil, true, errmark.MarkUser(ErrOIDCLogoutTokenNoIssuedAt)
+	}
+
+	return &LogoutToken{
+		ID:        claims.ID,
+		IssuedAt:  logoutToken.IssuedAt,
+		Subject:   logoutToken.Subject,
+		SessionID: claims.SessionID,
+	}, true, nil
+}
+
+func (oo *oidcOperations) authCodeURLOptions(opts []AuthCodeURLOption) []AuthCodeURLOption {
+	o := &AuthCodeURLOptions{}
+	o.ApplyOptions(opts)
+
 	opts = append([]AuthCodeURLOption{WithScopes{"openid"}}, opts...)
-	return oo.delegate.AuthCodeURL(state, opts...)
+	if o.Nonce != "" {
+		// Prepend so that an explicitly configured nonce parameter takes
+		// precedence.
+		opts = append([]AuthCodeURLOption{WithURLParams{"nonce": o.Nonce}}, opts...)
+	}
+
+	return opts
+}
+
+func (oo *oidcOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	return oo.delegate.AuthCodeURL(state, oo.authCodeURLOptions(opts)...)
+}
+
+func (oo *oidcOperations) LogoutURL(idTokenHint string, opts ...LogoutURLOption) (string, bool) {
+	return oo.delegate.LogoutURL(idTokenHint, opts...)
+}
+
+func (oo *oidcOperations) PKCESupported(opts ...AuthCodeURLOption) bool {
+	return oo.delegate.PKCESupported(opts...)
+}
+
+func (oo *oidcOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	return oo.delegate.PushedAuthCodeURL(ctx, state, oo.authCodeURLOptions(opts)...)
 }
 
 func (oo *oidcOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
@@ -127,7 +222,7 @@ func (oo *oidcOperations) DeviceCodeExchange(ctx context.Context, deviceCode str
 	// Nonce doesn't even make sense here, so just make sure it isn't set.
 	delete(t.ProviderOptions, "nonce")
 
-	if err := oo.verifyUpdateIDToken(ctx, t); err != nil {
+	if err := oo.verifyUpdateIDToken(ctx, t, ""); err != nil {
 		return nil, errmark.MarkUser(err)
 	}
 
@@ -139,6 +234,9 @@ func (oo *oidcOperations) DeviceCodeExchange(ctx context.Context, deviceCode str
 }
 
 func (oo *oidcOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
+	o := &AuthCodeExchangeOptions{}
+	o.ApplyOptions(opts)
+
 	t, err := oo.delegate.AuthCodeExchange(ctx, code, opts...)
 	if err != nil {
 		return nil, err
@@ -148,7 +246,14 @@ func (oo *oidcOperations) AuthCodeExchange(ctx context.Context, code string, opt
 		t.ExtraData = make(map[string]interface{})
 	}
 
-	if err := oo.verifyUpdateIDToken(ctx, t); err != nil {
+	// A nonce provided by an authorization session takes precedence over one
+	// passed in using provider options.
+	nonce := o.Nonce
+	if nonce == "" {
+		nonce = t.ProviderOptions["nonce"]
+	}
+
+	if err := oo.verifyUpdateIDToken(ctx, t, nonce); err != nil {
 		return nil, errmark.MarkUser(err)
 	}
 
@@ -177,7 +282,7 @@ func (oo *oidcOperations) RefreshToken(ctx context.Context, t *Token, opts ...Re
 	// providing an ID token as part of a refresh is optional. We will only
 	// revalidate the token if a new one is provided.
 	if rawIDToken, ok := nt.Extra("id_token").(string); ok && rawIDToken != "" {
-		if err := oo.verifyUpdateIDToken(ctx, nt); err != nil {
+		if err := oo.verifyUpdateIDToken(ctx, nt, nt.ProviderOptions["nonce"]); err != nil {
 			return nil, errmark.MarkUser(err)
 		}
 	} else {
@@ -202,18 +307,91 @@ func (oo *oidcOperations) TokenExchange(ctx context.Context, t *Token, opts ...T
 	return oo.delegate.TokenExchange(ctx, t, opts...)
 }
 
+func (oo *oidcOperations) JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...JWTBearerOption) (*Token, error) {
+	return oo.delegate.JWTBearer(ctx, key, opts...)
+}
+
+func (oo *oidcOperations) SAML2Bearer(ctx context.Context, assertion string, opts ...SAML2BearerOption) (*Token, error) {
+	return oo.delegate.SAML2Bearer(ctx, assertion, opts...)
+}
+
+func (oo *oidcOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...PasswordCredentialsOption) (*Token, error) {
+	return oo.delegate.PasswordCredentials(ctx, username, password, opts...)
+}
+
+func (oo *oidcOperations) BackchannelAuth(ctx context.Context, opts ...BackchannelAuthOption) (*ciba.Auth, bool, error) {
+	opts = append([]BackchannelAuthOption{WithScopes{"openid"}}, opts...)
+	return oo.delegate.BackchannelAuth(ctx, opts...)
+}
+
+func (oo *oidcOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...BackchannelAuthExchangeOption) (*Token, error) {
+	t, err := oo.delegate.BackchannelAuthExchange(ctx, authReqID, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.ExtraData == nil {
+		t.ExtraData = make(map[string]interface{})
+	}
+
+	// As with the device code flow, there is no nonce to check.
+	delete(t.ProviderOptions, "nonce")
+
+	if err := oo.verifyUpdateIDToken(ctx, t, ""); err != nil {
+		return nil, errmark.MarkUser(err)
+	}
+
+	if err := oo.updateUserInfo(ctx, t); err != nil {
+		return nil, errmark.MarkUser(err)
+	}
+
+	return t, nil
+}
+
+func (oo *oidcOperations) OnBehalfOf(ctx context.Context, t *Token, opts ...OnBehalfOfOption) (*Token, error) {
+	return oo.delegate.OnBehalfOf(ctx, t, opts...)
+}
+
+func (oo *oidcOperations) Revoke(ctx context.Context, token string, opts ...RevokeOption) (bool, error) {
+	return oo.delegate.Revoke(ctx, token, opts...)
+}
+
+func (oo *oidcOperations) Introspect(ctx context.Context, token string, opts ...IntrospectOption) (*introspection.Result, bool, error) {
+	return oo.delegate.Introspect(ctx, token, opts...)
+}
+
 type oidc struct {
-	vsn             int
-	p               *gooidc.Provider
-	authStyle       oauth2.AuthStyle
-	deviceURL       string
-	extraDataFields []string
+	vsn                int
+	p                  *gooidc.Provider
+	authStyle          oauth2.AuthStyle
+	clientSecretJWT    bool
+	pkceSupported      bool
+	mtlsAliases        *Endpoint
+	deviceURL          string
+	pushedAuthURL      string
+	pushedAuthRequired bool
+	backchannelAuthURL string
+	revocationURL      string
+	introspectionURL   string
+	endSessionURL      string
+	issuer             string
+	extraDataFields    []string
 }
 
 func (o *oidc) endpointFactory(opts map[string]string) Endpoint {
 	ep := Endpoint{
-		Endpoint:  o.p.Endpoint(),
-		DeviceURL: o.deviceURL,
+		Endpoint:           o.p.Endpoint(),
+		DeviceURL:          o.deviceURL,
+		PushedAuthURL:      o.pushedAuthURL,
+		PushedAuthRequired: o.pushedAuthRequired,
+		BackchannelAuthURL: o.backchannelAuthURL,
+		RevocationURL:      o.revocationURL,
+		IntrospectionURL:   o.introspectionURL,
+		EndSessionURL:      o.endSessionURL,
+		Issuer:             o.issuer,
+		ClientSecretJWT:    o.clientSecretJWT,
+		PKCESupported:      o.pkceSupported,
+		MTLSAliases:        o.mtlsAliases,
 	}
 	ep.AuthStyle = o.authStyle
 	return ep
@@ -246,10 +424,7 @@ func newOIDC(ctx context.Context, vsn int, issuerURL string, extraDataFields []s
 		return nil, fmt.Errorf("error creating OIDC provider with given issuer URL: %w", err)
 	}
 
-	var metadata struct {
-		DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
-		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
-	}
+	var metadata serverMetadata
 	if err := delegate.Claims(&metadata); err != nil {
 		return nil, fmt.Errorf("error decoding OIDC provider metadata: %w", err)
 	}
@@ -257,20 +432,27 @@ func newOIDC(ctx context.Context, vsn int, issuerURL string, extraDataFields []s
 	// For some reason, the upstream provider does not check the
 	// "token_endpoint_auth_methods_supported" value.
 	authStyle := delegate.Endpoint().AuthStyle
+	clientSecretJWT := false
 	if authStyle == oauth2.AuthStyleAutoDetect {
-		if strutil.StrListContains(metadata.TokenEndpointAuthMethodsSupported, "client_secret_post") {
-			authStyle = oauth2.AuthStyleInParams
-		} else {
-			authStyle = oauth2.AuthStyleInHeader
-		}
+		authStyle, clientSecretJWT = metadata.authStyle()
 	}
 
 	return &oidc{
-		vsn:             vsn,
-		p:               delegate,
-		deviceURL:       metadata.DeviceAuthorizationEndpoint,
-		authStyle:       authStyle,
-		extraDataFields: extraDataFields,
+		vsn:                vsn,
+		p:                  delegate,
+		deviceURL:          metadata.DeviceAuthorizationEndpoint,
+		pushedAuthURL:      metadata.PushedAuthorizationRequestEndpoint,
+		pushedAuthRequired: metadata.RequirePushedAuthorizationRequests,
+		backchannelAuthURL: metadata.BackchannelAuthenticationEndpoint,
+		revocationURL:      metadata.RevocationEndpoint,
+		introspectionURL:   metadata.IntrospectionEndpoint,
+		endSessionURL:      metadata.EndSessionEndpoint,
+		issuer:             metadata.Issuer,
+		authStyle:          authStyle,
+		clientSecretJWT:    clientSecretJWT,
+		pkceSupported:      metadata.pkceSupported(),
+		mtlsAliases:        metadata.mtlsAliases(),
+		extraDataFields:    extraDataFields,
 	}, nil
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
@@ -13,6 +14,8 @@ import (
 	"time"
 
 	oidc "github.com/coreos/go-oidc"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientctx"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
@@ -31,6 +34,7 @@ const testOIDCConfiguration = `
 	"token_endpoint": "http://localhost/token",
 	"device_authorization_endpoint": "http://localhost/device",
 	"userinfo_endpoint": "http://localhost/userinfo",
+	"end_session_endpoint": "http://localhost/logout?ui=compact",
 	"jwks_uri": "http://localhost/.well-known/jwks.json",
 	"response_types_supported": ["code", "token", "id_token", "code token", "code id_token", "token id_token", "code token id_token"],
 	"id_token_signing_alg_values_supported": ["RS256"],
@@ -134,6 +138,7 @@ func TestOIDCFlow(t *testing.T) {
 	assert.Equal(t, "efgh", token.RefreshToken)
 	assert.NotEmpty(t, token.Expiry)
 	assert.Empty(t, token.ProviderOptions) // "nonce" option should be stripped!
+	assert.Equal(t, "test-user", token.Subject)
 	require.Contains(t, token.ExtraData, "id_token")
 	require.Contains(t, token.ExtraData, "id_token_claims")
 	require.Contains(t, token.ExtraData, "user_info")
@@ -150,6 +155,245 @@ func TestOIDCFlow(t *testing.T) {
 	assert.Equal(t, "test-user@example.com", userInfo["email"])
 }
 
+func TestOIDCLogoutURL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, testOIDCConfiguration)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": "http://localhost",
+	})
+	require.NoError(t, err)
+
+	logoutURL, ok := oidcTest.Public("foo").LogoutURL(
+		"id-token",
+		provider.WithRedirectURL("http://example.com/logged-out"),
+		provider.WithState("bar"),
+	)
+	require.True(t, ok)
+
+	u, err := url.Parse(logoutURL)
+	require.NoError(t, err)
+	assert.Equal(t, "/logout", u.Path)
+	assert.Equal(t, url.Values{
+		"ui":                       {"compact"},
+		"client_id":                {"foo"},
+		"id_token_hint":            {"id-token"},
+		"post_logout_redirect_uri": {"http://example.com/logged-out"},
+		"state":                    {"bar"},
+	}, u.Query())
+}
+
+func TestOIDCVerifyLogoutToken(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       privateKey,
+	}, (&jose.SignerOptions{}).WithType("logout+jwt"))
+	require.NoError(t, err)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, testOIDCConfiguration)
+		case "/.well-known/jwks.json":
+			_ = json.NewEncoder(w).Encode(&jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{
+					{
+						Key:   &privateKey.PublicKey,
+						KeyID: "key",
+						Use:   "sig",
+					},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": "http://localhost",
+	})
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+
+	sign := func(extra map[string]interface{}) string {
+		tok, err := jwt.Signed(signer).
+			Claims(jwt.Claims{
+				Issuer:   "http://localhost",
+				Audience: jwt.Audience{"foo"},
+				Subject:  "test-user",
+				IssuedAt: jwt.NewNumericDate(now),
+				Expiry:   jwt.NewNumericDate(time.Now().Add(2 * time.Minute)),
+				ID:       "logout-1",
+			}).
+			Claims(extra).
+			CompactSerialize()
+		require.NoError(t, err)
+		return tok
+	}
+
+	events := map[string]interface{}{
+		"http://schemas.openid.net/event/backchannel-logout": map[string]interface{}{},
+	}
+
+	lt, ok, err := oidcTest.Public("foo").VerifyLogoutToken(ctx, sign(map[string]interface{}{
+		"sid":    "session-1",
+		"events": events,
+	}))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "logout-1", lt.ID)
+	assert.True(t, now.Equal(lt.IssuedAt))
+	assert.Equal(t, "test-user", lt.Subject)
+	assert.Equal(t, "session-1", lt.SessionID)
+
+	// Logout tokens must be identifiable so replays can be detected.
+	_, ok, err = oidcTest.Public("foo").VerifyLogoutToken(ctx, sign(map[string]interface{}{
+		"jti":    "",
+		"events": events,
+	}))
+	require.True(t, ok)
+	require.ErrorIs(t, err, provider.ErrOIDCLogoutTokenNoID)
+
+	// ID tokens are not logout tokens.
+	_, ok, err = oidcTest.Public("foo").VerifyLogoutToken(ctx, sign(map[string]interface{}{
+		"nonce": "baz",
+	}))
+	require.True(t, ok)
+	require.ErrorIs(t, err, provider.ErrOIDCInvalidLogoutToken)
+
+	// Logout tokens for other clients are rejected.
+	_, ok, err = oidcTest.Public("bar").VerifyLogoutToken(ctx, sign(map[string]interface{}{
+		"events": events,
+	}))
+	require.True(t, ok)
+	require.Error(t, err)
+}
+
+func TestOIDCClientSecretJWT(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, `{
+				"issuer": "http://localhost",
+				"authorization_endpoint": "http://localhost/authorize",
+				"token_endpoint": "http://localhost/token",
+				"jwks_uri": "http://localhost/.well-known/jwks.json",
+				"token_endpoint_auth_methods_supported": ["client_secret_jwt", "private_key_jwt"]
+			}`)
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			_, _, ok := r.BasicAuth()
+			assert.False(t, ok)
+			assert.Equal(t, "client_credentials", data.Get("grant_type"))
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Empty(t, data.Get("client_secret"))
+			assert.Equal(t, "urn:ietf:params:oauth:client-assertion-type:jwt-bearer", data.Get("client_assertion_type"))
+
+			tok, err := jwt.ParseSigned(data.Get("client_assertion"))
+			require.NoError(t, err)
+
+			var claims jwt.Claims
+			require.NoError(t, tok.Claims([]byte("a client secret of sufficient length"), &claims))
+			assert.Equal(t, "foo", claims.Subject)
+			assert.Equal(t, jwt.Audience{"http://localhost/token"}, claims.Audience)
+
+			_, _ = io.WriteString(w, `access_token=abcd&token_type=bearer&expires_in=900`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": "http://localhost",
+	})
+	require.NoError(t, err)
+
+	token, err := oidcTest.Private("foo", "a client secret of sufficient length").ClientCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", token.AccessToken)
+}
+
+func TestOIDCMTLSEndpointAliases(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, `{
+				"issuer": "http://localhost",
+				"authorization_endpoint": "http://localhost/authorize",
+				"token_endpoint": "http://localhost/token",
+				"jwks_uri": "http://localhost/.well-known/jwks.json",
+				"token_endpoint_auth_methods_supported": ["tls_client_auth"],
+				"mtls_endpoint_aliases": {
+					"token_endpoint": "http://mtls.localhost/token"
+				}
+			}`)
+		case "/token":
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+
+			data, err := url.ParseQuery(string(b))
+			require.NoError(t, err)
+
+			assert.Equal(t, "mtls.localhost", r.Host)
+			assert.Equal(t, "foo", data.Get("client_id"))
+			assert.Empty(t, data.Get("client_secret"))
+
+			_, _ = io.WriteString(w, `access_token=abcd&token_type=bearer&expires_in=900`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": "http://localhost",
+	})
+	require.NoError(t, err)
+
+	// The mock transport can't present a certificate, but the aliases are
+	// still used.
+	ctx = clientctx.WithTLSClientCertificate(ctx, tls.Certificate{})
+	ctx = clientauth.WithAuthenticator(ctx, &clientauth.TLSClientAuth{ClientID: "foo"})
+
+	token, err := oidcTest.Private("foo", "").ClientCredentials(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", token.AccessToken)
+}
+
 func TestOIDCRefreshWithIDToken(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -508,3 +752,82 @@ func TestOIDCRefreshWithoutIDToken(t *testing.T) {
 	require.Contains(t, token.ExtraData, "id_token_claims")
 	assert.Equal(t, initialIDToken, token.ExtraData["id_token"])
 }
+
+func TestOIDCNonce(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       privateKey,
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	require.NoError(t, err)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = io.WriteString(w, testOIDCConfiguration)
+		case "/.well-known/jwks.json":
+			_ = json.NewEncoder(w).Encode(&jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{
+					{
+						Key:   &privateKey.PublicKey,
+						KeyID: "key",
+						Use:   "sig",
+					},
+				},
+			})
+		case "/token":
+			idClaims := jwt.Claims{
+				Issuer:   "http://localhost",
+				Audience: jwt.Audience{"foo"},
+				Subject:  "test-user",
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			}
+
+			idToken, err := jwt.Signed(signer).
+				Claims(idClaims).
+				Claims(map[string]interface{}{"nonce": "baz"}).
+				CompactSerialize()
+			require.NoError(t, err)
+
+			resp := make(url.Values)
+			resp.Set("access_token", "abcd")
+			resp.Set("token_type", "bearer")
+			resp.Set("id_token", idToken)
+			resp.Set("expires_in", "900")
+
+			_, _ = io.WriteString(w, resp.Encode())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &http.Client{Transport: &testutil.MockRoundTripper{Handler: h}}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, c)
+
+	oidcTest, err := provider.GlobalRegistry.New(ctx, "oidc", map[string]string{
+		"issuer_url": "http://localhost",
+	})
+	require.NoError(t, err)
+
+	ops := oidcTest.Private("foo", "bar")
+
+	authCodeURL, ok, err := ops.AuthCodeURL("state", provider.WithNonce("baz"))
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	u, err := url.Parse(authCodeURL)
+	require.NoError(t, err)
+	assert.Equal(t, "baz", u.Query().Get("nonce"))
+
+	token, err := ops.AuthCodeExchange(ctx, "123456", provider.WithNonce("baz"))
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "abcd", token.AccessToken)
+
+	_, err = ops.AuthCodeExchange(ctx, "123456", provider.WithNonce("quux"))
+	require.ErrorIs(t, err, provider.ErrOIDCNonceMismatch)
+}
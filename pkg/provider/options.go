@@ -3,6 +3,8 @@ package provider
 import (
 	"net/url"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
+
 	"golang.org/x/oauth2"
 )
 
@@ -11,6 +13,7 @@ type WithRedirectURL string
 var (
 	_ AuthCodeURLOption      = WithRedirectURL("")
 	_ AuthCodeExchangeOption = WithRedirectURL("")
+	_ LogoutURLOption        = WithRedirectURL("")
 )
 
 func (wru WithRedirectURL) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
@@ -21,13 +24,132 @@ func (wru WithRedirectURL) ApplyToAuthCodeExchangeOptions(target *AuthCodeExchan
 	target.RedirectURL = string(wru)
 }
 
+func (wru WithRedirectURL) ApplyToLogoutURLOptions(target *LogoutURLOptions) {
+	target.RedirectURL = string(wru)
+}
+
+// WithState sets the state to return to the post-logout redirect URL.
+type WithState string
+
+var _ LogoutURLOption = WithState("")
+
+func (ws WithState) ApplyToLogoutURLOptions(target *LogoutURLOptions) {
+	target.State = string(ws)
+}
+
+// WithCodeVerifier sets the RFC 7636 (PKCE) code verifier for an
+// authorization code flow. The authorization code URL will include the S256
+// challenge derived from the verifier, and the exchange will send the verifier
+// itself.
+type WithCodeVerifier string
+
+var (
+	_ AuthCodeURLOption      = WithCodeVerifier("")
+	_ AuthCodeExchangeOption = WithCodeVerifier("")
+)
+
+func (wcv WithCodeVerifier) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
+	target.CodeVerifier = string(wcv)
+}
+
+func (wcv WithCodeVerifier) ApplyToAuthCodeExchangeOptions(target *AuthCodeExchangeOptions) {
+	target.CodeVerifier = string(wcv)
+}
+
+// WithNonce sets the nonce to bind an ID token to an authorization request.
+// Providers that do not issue ID tokens ignore it.
+type WithNonce string
+
+var (
+	_ AuthCodeURLOption      = WithNonce("")
+	_ AuthCodeExchangeOption = WithNonce("")
+)
+
+func (wn WithNonce) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
+	target.Nonce = string(wn)
+}
+
+func (wn WithNonce) ApplyToAuthCodeExchangeOptions(target *AuthCodeExchangeOptions) {
+	target.Nonce = string(wn)
+}
+
+// WithRequestObjectKey signs the parameters of an authorization code URL into
+// an RFC 9101 request object using the given key.
+type WithRequestObjectKey struct {
+	Key *jwtkey.Key
+}
+
+var _ AuthCodeURLOption = WithRequestObjectKey{}
+
+func (wrok WithRequestObjectKey) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
+	target.RequestObjectKey = wrok.Key
+}
+
+// WithAssertionClaims sets the claims of a JWT bearer assertion.
+type WithAssertionClaims struct {
+	Issuer   string
+	Subject  string
+	Audience string
+	Claims   map[string]interface{}
+}
+
+var _ JWTBearerOption = WithAssertionClaims{}
+
+func (wac WithAssertionClaims) ApplyToJWTBearerOptions(target *JWTBearerOptions) {
+	target.Issuer = wac.Issuer
+	target.Subject = wac.Subject
+	target.Audience = wac.Audience
+	target.Claims = wac.Claims
+}
+
+// WithLoginHint identifies the end-user to authenticate using CIBA.
+type WithLoginHint string
+
+var _ BackchannelAuthOption = WithLoginHint("")
+
+func (wlh WithLoginHint) ApplyToBackchannelAuthOptions(target *BackchannelAuthOptions) {
+	target.LoginHint = string(wlh)
+}
+
+// WithBindingMessage sets a short message displayed to the end-user on both
+// the consumption and authentication devices during CIBA.
+type WithBindingMessage string
+
+var _ BackchannelAuthOption = WithBindingMessage("")
+
+func (wbm WithBindingMessage) ApplyToBackchannelAuthOptions(target *BackchannelAuthOptions) {
+	target.BindingMessage = string(wbm)
+}
+
+// WithTokenTypeHint tells the authorization server what kind of token is
+// being revoked or introspected.
+type WithTokenTypeHint string
+
+var (
+	_ RevokeOption     = WithTokenTypeHint("")
+	_ IntrospectOption = WithTokenTypeHint("")
+)
+
+func (wtth WithTokenTypeHint) ApplyToRevokeOptions(target *RevokeOptions) {
+	target.TokenTypeHint = string(wtth)
+}
+
+func (wtth WithTokenTypeHint) ApplyToIntrospectOptions(target *IntrospectOptions) {
+	target.TokenTypeHint = string(wtth)
+}
+
 type WithScopes []string
 
 var (
-	_ AuthCodeURLOption       = WithScopes(nil)
-	_ DeviceCodeAuthOption    = WithScopes(nil)
-	_ ClientCredentialsOption = WithScopes(nil)
-	_ TokenExchangeOption     = WithScopes(nil)
+	_ AuthCodeURLOption         = WithScopes(nil)
+	_ DeviceCodeAuthOption      = WithScopes(nil)
+	_ ClientCredentialsOption   = WithScopes(nil)
+	_ TokenExchangeOption       = WithScopes(nil)
+	_ JWTBearerOption           = WithScopes(nil)
+	_ SAML2BearerOption         = WithScopes(nil)
+	_ PasswordCredentialsOption = WithScopes(nil)
+	_ BackchannelAuthOption     = WithScopes(nil)
+	_ OnBehalfOfOption          = WithScopes(nil)
 )
 
 func (ws WithScopes) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
@@ -46,6 +168,26 @@ func (ws WithScopes) ApplyToTokenExchangeOptions(target *TokenExchangeOptions) {
 	target.Scopes = append(target.Scopes, ws...)
 }
 
+func (ws WithScopes) ApplyToJWTBearerOptions(target *JWTBearerOptions) {
+	target.Scopes = append(target.Scopes, ws...)
+}
+
+func (ws WithScopes) ApplyToSAML2BearerOptions(target *SAML2BearerOptions) {
+	target.Scopes = append(target.Scopes, ws...)
+}
+
+func (ws WithScopes) ApplyToPasswordCredentialsOptions(target *PasswordCredentialsOptions) {
+	target.Scopes = append(target.Scopes, ws...)
+}
+
+func (ws WithScopes) ApplyToBackchannelAuthOptions(target *BackchannelAuthOptions) {
+	target.Scopes = append(target.Scopes, ws...)
+}
+
+func (ws WithScopes) ApplyToOnBehalfOfOptions(target *OnBehalfOfOptions) {
+	target.Scopes = append(target.Scopes, ws...)
+}
+
 type WithAudiences []string
 
 var _ TokenExchangeOption = WithAudiences(nil)
@@ -62,6 +204,36 @@ func (wr WithResources) ApplyToTokenExchangeOptions(target *TokenExchangeOptions
 	target.Resources = append(target.Resources, wr...)
 }
 
+type WithSubjectTokenType string
+
+var _ TokenExchangeOption = WithSubjectTokenType("")
+
+func (wstt WithSubjectTokenType) ApplyToTokenExchangeOptions(target *TokenExchangeOptions) {
+	target.SubjectTokenType = string(wstt)
+}
+
+type WithRequestedTokenType string
+
+var _ TokenExchangeOption = WithRequestedTokenType("")
+
+func (wrtt WithRequestedTokenType) ApplyToTokenExchangeOptions(target *TokenExchangeOptions) {
+	target.RequestedTokenType = string(wrtt)
+}
+
+// WithActorToken sets the token of the party acting on behalf of the subject
+// as described by RFC 8693 § 1.1.
+type WithActorToken struct {
+	Token     string
+	TokenType string
+}
+
+var _ TokenExchangeOption = WithActorToken{}
+
+func (wat WithActorToken) ApplyToTokenExchangeOptions(target *TokenExchangeOptions) {
+	target.ActorToken = wat.Token
+	target.ActorTokenType = wat.TokenType
+}
+
 type WithURLParams map[string]string
 
 var (
@@ -102,13 +274,22 @@ func (wup WithURLParams) ApplyToTokenExchangeOptions(target *TokenExchangeOption
 type WithProviderOptions map[string]string
 
 var (
-	_ AuthCodeURLOption        = WithProviderOptions(nil)
-	_ DeviceCodeAuthOption     = WithProviderOptions(nil)
-	_ DeviceCodeExchangeOption = WithProviderOptions(nil)
-	_ AuthCodeExchangeOption   = WithProviderOptions(nil)
-	_ RefreshTokenOption       = WithProviderOptions(nil)
-	_ ClientCredentialsOption  = WithProviderOptions(nil)
-	_ TokenExchangeOption      = WithProviderOptions(nil)
+	_ AuthCodeURLOption             = WithProviderOptions(nil)
+	_ DeviceCodeAuthOption          = WithProviderOptions(nil)
+	_ DeviceCodeExchangeOption      = WithProviderOptions(nil)
+	_ AuthCodeExchangeOption        = WithProviderOptions(nil)
+	_ RefreshTokenOption            = WithProviderOptions(nil)
+	_ ClientCredentialsOption       = WithProviderOptions(nil)
+	_ TokenExchangeOption           = WithProviderOptions(nil)
+	_ JWTBearerOption               = WithProviderOptions(nil)
+	_ SAML2BearerOption             = WithProviderOptions(nil)
+	_ PasswordCredentialsOption     = WithProviderOptions(nil)
+	_ BackchannelAuthOption         = WithProviderOptions(nil)
+	_ BackchannelAuthExchangeOption = WithProviderOptions(nil)
+	_ OnBehalfOfOption              = WithProviderOptions(nil)
+	_ RevokeOption                  = WithProviderOptions(nil)
+	_ IntrospectOption              = WithProviderOptions(nil)
+	_ LogoutURLOption               = WithProviderOptions(nil)
 )
 
 func (wpo WithProviderOptions) ApplyToAuthCodeURLOptions(target *AuthCodeURLOptions) {
@@ -180,3 +361,93 @@ func (wpo WithProviderOptions) ApplyToTokenExchangeOptions(target *TokenExchange
 		target.ProviderOptions[k] = v
 	}
 }
+
+func (wpo WithProviderOptions) ApplyToJWTBearerOptions(target *JWTBearerOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToSAML2BearerOptions(target *SAML2BearerOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToPasswordCredentialsOptions(target *PasswordCredentialsOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToBackchannelAuthOptions(target *BackchannelAuthOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToBackchannelAuthExchangeOptions(target *BackchannelAuthExchangeOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToOnBehalfOfOptions(target *OnBehalfOfOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToRevokeOptions(target *RevokeOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToIntrospectOptions(target *IntrospectOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
+
+func (wpo WithProviderOptions) ApplyToLogoutURLOptions(target *LogoutURLOptions) {
+	if target.ProviderOptions == nil {
+		target.ProviderOptions = make(map[string]string, len(wpo))
+	}
+
+	for k, v := range wpo {
+		target.ProviderOptions[k] = v
+	}
+}
@@ -3,8 +3,12 @@ package provider
 import (
 	"context"
 	"net/url"
+	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
 	"golang.org/x/oauth2"
 )
 
@@ -13,7 +17,68 @@ import (
 type Endpoint struct {
 	oauth2.Endpoint
 
-	DeviceURL string
+	DeviceURL          string
+	PushedAuthURL      string
+	BackchannelAuthURL string
+	RevocationURL      string
+	IntrospectionURL   string
+
+	// PushedAuthRequired indicates that authorization requests must be pushed
+	// to PushedAuthURL instead of being sent in the authorization code URL,
+	// either because the authorization server requires it or because the
+	// provider was configured to do so.
+	PushedAuthRequired bool
+
+	// EndSessionURL is the OpenID Connect RP-initiated logout endpoint. The
+	// end-user's browser is sent to it, so it has no mTLS alias.
+	EndSessionURL string
+
+	// Issuer is the issuer identifier of the authorization server, if known.
+	Issuer string
+
+	// ClientSecretJWT indicates that the client secret should be used to sign
+	// a client assertion instead of being sent to the authorization server
+	// directly. If set, AuthStyle is ignored.
+	ClientSecretJWT bool
+
+	// PKCESupported indicates that the authorization server advertises support
+	// for RFC 7636 code challenges using the S256 method.
+	PKCESupported bool
+
+	// MTLSAliases are the alternative URLs to use when the client presents a
+	// TLS certificate to the authorization server (RFC 8705 § 5). Only
+	// non-empty URLs are used.
+	MTLSAliases *Endpoint
+}
+
+// ForMTLS returns the endpoint to use when the client presents a TLS
+// certificate to the authorization server.
+func (e Endpoint) ForMTLS() Endpoint {
+	a := e.MTLSAliases
+	if a == nil {
+		return e
+	}
+
+	e.MTLSAliases = nil
+	if a.TokenURL != "" {
+		e.TokenURL = a.TokenURL
+	}
+	if a.DeviceURL != "" {
+		e.DeviceURL = a.DeviceURL
+	}
+	if a.PushedAuthURL != "" {
+		e.PushedAuthURL = a.PushedAuthURL
+	}
+	if a.BackchannelAuthURL != "" {
+		e.BackchannelAuthURL = a.BackchannelAuthURL
+	}
+	if a.RevocationURL != "" {
+		e.RevocationURL = a.RevocationURL
+	}
+	if a.IntrospectionURL != "" {
+		e.IntrospectionURL = a.IntrospectionURL
+	}
+	return e
 }
 
 // EndpointFactoryFunc returns an Endpoint given some provider configuration.
@@ -27,6 +92,14 @@ func StaticEndpointFactory(endpoint Endpoint) EndpointFactoryFunc {
 	}
 }
 
+// Token type identifiers defined by RFC 8693 § 3 that can be used as the
+// subject or actor of a token exchange.
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+)
+
 // Token is an extension of *oauth2.Token that also provides complementary data
 // to store (usually from the token's own raw data).
 type Token struct {
@@ -46,14 +119,54 @@ type Token struct {
 	// ProviderOptions are the set of persistent options to use for this token
 	// when configuring a provider.
 	ProviderOptions map[string]string `json:"provider_options,omitempty"`
+
+	// Subject and SessionID identify the end-user and their session at the
+	// authorization server as reported by an OpenID Connect ID token. They
+	// are used to match back-channel logout requests and may be unspecified.
+	Subject   string `json:"subject,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// TokenOfType returns the token with the given RFC 8693 token type identifier.
+// ID tokens are only available if the provider stores them as extra data. It
+// returns false if this token does not have a token of the given type.
+func (t *Token) TokenOfType(tokenType string) (string, bool) {
+	var s string
+	switch tokenType {
+	case TokenTypeAccessToken:
+		s = t.AccessToken
+	case TokenTypeRefreshToken:
+		s = t.RefreshToken
+	case TokenTypeIDToken:
+		s, _ = t.ExtraData["id_token"].(string)
+	}
+
+	return s, s != ""
+}
+
+// LogoutToken contains the claims of a verified OpenID Connect back-channel
+// logout token that identify the end-user session to terminate. At least one
+// of the fields is specified.
+type LogoutToken struct {
+	// ID is the unique identifier of the token (the jti claim).
+	ID        string
+	IssuedAt  time.Time
+	Subject   string
+	SessionID string
 }
 
 // AuthCodeURLOptions are options for the AuthCodeURL operation.
 type AuthCodeURLOptions struct {
 	RedirectURL     string
 	Scopes          []string
+	CodeVerifier    string
+	Nonce           string
 	AuthCodeOptions []oauth2.AuthCodeOption
 	ProviderOptions map[string]string
+
+	// RequestObjectKey, if set, signs the authorization request parameters
+	// into an RFC 9101 request object.
+	RequestObjectKey *jwtkey.Key
 }
 
 type AuthCodeURLOption interface {
@@ -97,14 +210,46 @@ func (o *DeviceCodeExchangeOptions) ApplyOptions(opts []DeviceCodeExchangeOption
 	}
 }
 
+// LogoutURLOptions are options for the LogoutURL operation.
+type LogoutURLOptions struct {
+	RedirectURL     string
+	State           string
+	ProviderOptions map[string]string
+}
+
+type LogoutURLOption interface {
+	ApplyToLogoutURLOptions(target *LogoutURLOptions)
+}
+
+func (o *LogoutURLOptions) ApplyOptions(opts []LogoutURLOption) {
+	for _, opt := range opts {
+		opt.ApplyToLogoutURLOptions(o)
+	}
+}
+
 // PublicOperations defines the operations for a client that only require
 // knowledge of the client ID.
 type PublicOperations interface {
 	// AuthCodeURL returns a URL to send a user to for initial authentication.
 	//
-	// If this provider does not define an authorization code endpoint URL, this
+	// If this provider does not define an authorization code endpoint URL or
+	// the authorization server only accepts pushed authorization requests,
+	// this method returns false. If a request object is requested but cannot
+	// be signed, this method returns an error.
+	AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool, error)
+
+	// LogoutURL returns a URL to send a user to for ending their session at
+	// the authorization server using OpenID Connect RP-initiated logout. The
+	// ID token hint is optional.
+	//
+	// If this provider does not define an end session endpoint URL, this
 	// method returns false.
-	AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool)
+	LogoutURL(idTokenHint string, opts ...LogoutURLOption) (string, bool)
+
+	// PKCESupported returns true if the authorization server advertises
+	// support for the RFC 7636 S256 code challenge method that authorization
+	// code URLs use when given a code verifier.
+	PKCESupported(opts ...AuthCodeURLOption) bool
 
 	// DeviceCodeAuth performs the RFC 8628 device code authorization operation.
 	//
@@ -125,11 +270,20 @@ type PublicOperations interface {
 	// secret. However, for implicit and device code grants, it only requires
 	// the client ID.
 	RefreshToken(ctx context.Context, t *Token, opts ...RefreshTokenOption) (*Token, error)
+
+	// VerifyLogoutToken validates an OpenID Connect back-channel logout token
+	// issued to this client and returns the session it identifies.
+	//
+	// If this provider does not support back-channel logout, this method
+	// returns false.
+	VerifyLogoutToken(ctx context.Context, token string) (*LogoutToken, bool, error)
 }
 
 // AuthCodeExchangeOptions are options for the AuthCodeExchange operation.
 type AuthCodeExchangeOptions struct {
 	RedirectURL     string
+	CodeVerifier    string
+	Nonce           string
 	AuthCodeOptions []oauth2.AuthCodeOption
 	ProviderOptions map[string]string
 }
@@ -178,11 +332,15 @@ func (o *ClientCredentialsOptions) ApplyOptions(opts []ClientCredentialsOption)
 
 // TokenExchangeOptions are options for the TokenExchange operation.
 type TokenExchangeOptions struct {
-	Scopes          []string
-	Audiences       []string
-	Resources       []string
-	AuthCodeOptions []oauth2.AuthCodeOption
-	ProviderOptions map[string]string
+	Scopes             []string
+	Audiences          []string
+	Resources          []string
+	SubjectTokenType   string
+	RequestedTokenType string
+	ActorToken         string
+	ActorTokenType     string
+	AuthCodeOptions    []oauth2.AuthCodeOption
+	ProviderOptions    map[string]string
 }
 
 type TokenExchangeOption interface {
@@ -195,11 +353,159 @@ func (o *TokenExchangeOptions) ApplyOptions(opts []TokenExchangeOption) {
 	}
 }
 
+// JWTBearerOptions are options for the JWTBearer operation.
+type JWTBearerOptions struct {
+	// Issuer and Subject default to the client ID. Audience defaults to the
+	// token endpoint URL.
+	Issuer   string
+	Subject  string
+	Audience string
+
+	// Claims are additional claims to include in the assertion. They cannot
+	// override the registered claims set by this package.
+	Claims map[string]interface{}
+
+	Scopes          []string
+	ProviderOptions map[string]string
+}
+
+type JWTBearerOption interface {
+	ApplyToJWTBearerOptions(target *JWTBearerOptions)
+}
+
+func (o *JWTBearerOptions) ApplyOptions(opts []JWTBearerOption) {
+	for _, opt := range opts {
+		opt.ApplyToJWTBearerOptions(o)
+	}
+}
+
+// SAML2BearerOptions are options for the SAML2Bearer operation.
+type SAML2BearerOptions struct {
+	Scopes          []string
+	ProviderOptions map[string]string
+}
+
+type SAML2BearerOption interface {
+	ApplyToSAML2BearerOptions(target *SAML2BearerOptions)
+}
+
+func (o *SAML2BearerOptions) ApplyOptions(opts []SAML2BearerOption) {
+	for _, opt := range opts {
+		opt.ApplyToSAML2BearerOptions(o)
+	}
+}
+
+// PasswordCredentialsOptions are options for the PasswordCredentials
+// operation.
+type PasswordCredentialsOptions struct {
+	Scopes          []string
+	ProviderOptions map[string]string
+}
+
+type PasswordCredentialsOption interface {
+	ApplyToPasswordCredentialsOptions(target *PasswordCredentialsOptions)
+}
+
+func (o *PasswordCredentialsOptions) ApplyOptions(opts []PasswordCredentialsOption) {
+	for _, opt := range opts {
+		opt.ApplyToPasswordCredentialsOptions(o)
+	}
+}
+
+// BackchannelAuthOptions are options for the BackchannelAuth operation.
+type BackchannelAuthOptions struct {
+	Scopes          []string
+	LoginHint       string
+	BindingMessage  string
+	ProviderOptions map[string]string
+}
+
+type BackchannelAuthOption interface {
+	ApplyToBackchannelAuthOptions(target *BackchannelAuthOptions)
+}
+
+func (o *BackchannelAuthOptions) ApplyOptions(opts []BackchannelAuthOption) {
+	for _, opt := range opts {
+		opt.ApplyToBackchannelAuthOptions(o)
+	}
+}
+
+// BackchannelAuthExchangeOptions are options for the BackchannelAuthExchange
+// operation.
+type BackchannelAuthExchangeOptions struct {
+	ProviderOptions map[string]string
+}
+
+type BackchannelAuthExchangeOption interface {
+	ApplyToBackchannelAuthExchangeOptions(target *BackchannelAuthExchangeOptions)
+}
+
+func (o *BackchannelAuthExchangeOptions) ApplyOptions(opts []BackchannelAuthExchangeOption) {
+	for _, opt := range opts {
+		opt.ApplyToBackchannelAuthExchangeOptions(o)
+	}
+}
+
+// OnBehalfOfOptions are options for the OnBehalfOf operation.
+type OnBehalfOfOptions struct {
+	Scopes          []string
+	ProviderOptions map[string]string
+}
+
+type OnBehalfOfOption interface {
+	ApplyToOnBehalfOfOptions(target *OnBehalfOfOptions)
+}
+
+func (o *OnBehalfOfOptions) ApplyOptions(opts []OnBehalfOfOption) {
+	for _, opt := range opts {
+		opt.ApplyToOnBehalfOfOptions(o)
+	}
+}
+
+// RevokeOptions are options for the Revoke operation.
+type RevokeOptions struct {
+	TokenTypeHint   string
+	ProviderOptions map[string]string
+}
+
+type RevokeOption interface {
+	ApplyToRevokeOptions(target *RevokeOptions)
+}
+
+func (o *RevokeOptions) ApplyOptions(opts []RevokeOption) {
+	for _, opt := range opts {
+		opt.ApplyToRevokeOptions(o)
+	}
+}
+
+// IntrospectOptions are options for the Introspect operation.
+type IntrospectOptions struct {
+	TokenTypeHint   string
+	ProviderOptions map[string]string
+}
+
+type IntrospectOption interface {
+	ApplyToIntrospectOptions(target *IntrospectOptions)
+}
+
+func (o *IntrospectOptions) ApplyOptions(opts []IntrospectOption) {
+	for _, opt := range opts {
+		opt.ApplyToIntrospectOptions(o)
+	}
+}
+
 // PrivateOperations defines the operations for a client that require knowledge
 // of the client ID and client secret.
 type PrivateOperations interface {
 	PublicOperations
 
+	// PushedAuthCodeURL performs an RFC 9126 pushed authorization request
+	// and returns a URL that references the pushed parameters.
+	//
+	// If this provider does not define a pushed authorization request
+	// endpoint URL, this method returns false.
+	PushedAuthCodeURL(ctx context.Context, state string, opts ...AuthCodeURLOption) (string, bool, error)
+
 	// AuthCodeExchange performs an authorization code flow exchange request.
 	AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error)
 
@@ -208,6 +514,50 @@ type PrivateOperations interface {
 
 	// TokenExchange performs an RFC 8693 token exchange operation.
 	TokenExchange(ctx context.Context, t *Token, opts ...TokenExchangeOption) (*Token, error)
+
+	// JWTBearer performs an RFC 7523 § 2.1 authorization grant request using a
+	// JWT assertion signed by the given key.
+	//
+	// Client authentication is optional for this grant, so this method does
+	// not require the client secret.
+	JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...JWTBearerOption) (*Token, error)
+
+	// SAML2Bearer performs an RFC 7522 § 2.1 authorization grant request using
+	// the given base64url-encoded SAML 2.0 assertion.
+	//
+	// Like JWTBearer, this method does not require the client secret.
+	SAML2Bearer(ctx context.Context, assertion string, opts ...SAML2BearerOption) (*Token, error)
+
+	// PasswordCredentials performs an RFC 6749 § 4.3 resource owner password
+	// credentials grant request. Public clients may use this grant, so this
+	// method does not require the client secret.
+	PasswordCredentials(ctx context.Context, username, password string, opts ...PasswordCredentialsOption) (*Token, error)
+
+	// BackchannelAuth performs the OpenID Connect CIBA authentication request.
+	//
+	// If this provider does not support client-initiated backchannel
+	// authentication, this method returns false.
+	BackchannelAuth(ctx context.Context, opts ...BackchannelAuthOption) (*ciba.Auth, bool, error)
+
+	// BackchannelAuthExchange polls for the result of a CIBA authentication
+	// request with the given ID.
+	BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...BackchannelAuthExchangeOption) (*Token, error)
+
+	// OnBehalfOf performs a Microsoft identity platform on-behalf-of request,
+	// which exchanges the given access token for one to call a downstream
+	// API using the JWT bearer grant.
+	OnBehalfOf(ctx context.Context, t *Token, opts ...OnBehalfOfOption) (*Token, error)
+
+	// Revoke invalidates the given access or refresh token at the
+	// authorization server using RFC 7009 token revocation. If the
+	// authorization server does not have a revocation endpoint, it returns
+	// false.
+	Revoke(ctx context.Context, token string, opts ...RevokeOption) (bool, error)
+
+	// Introspect asks the authorization server for the state of the given
+	// token using RFC 7662 token introspection. If the authorization server
+	// does not have an introspection endpoint, it returns false.
+	Introspect(ctx context.Context, token string, opts ...IntrospectOption) (*introspection.Result, bool, error)
 }
 
 const VersionLatest = -1
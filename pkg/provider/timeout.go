@@ -5,7 +5,10 @@ import (
 	"math"
 	"time"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
 	"github.com/puppetlabs/leg/timeutil/pkg/clockctx"
 )
 
@@ -145,10 +148,18 @@ type publicTimeoutOperations struct {
 	alg      TimeoutAlgorithm
 }
 
-func (pto *publicTimeoutOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool) {
+func (pto *publicTimeoutOperations) AuthCodeURL(state string, opts ...AuthCodeURLOption) (string, bool, error) {
 	return pto.delegate.AuthCodeURL(state, opts...)
 }
 
+func (pto *publicTimeoutOperations) LogoutURL(idTokenHint string, opts ...LogoutURLOption) (string, bool) {
+	return pto.delegate.LogoutURL(idTokenHint, opts...)
+}
+
+func (pto *publicTimeoutOperations) PKCESupported(opts ...AuthCodeURLOption) bool {
+	return pto.delegate.PKCESupported(opts...)
+}
+
 func (pto *publicTimeoutOperations) DeviceCodeAuth(ctx context.Context, opts ...DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
 	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
 	defer cancel()
@@ -170,11 +181,25 @@ func (pto *publicTimeoutOperations) RefreshToken(ctx context.Context, t *Token,
 	return pto.delegate.RefreshToken(ctx, t, opts...)
 }
 
+func (pto *publicTimeoutOperations) VerifyLogoutToken(ctx context.Context, token string) (*LogoutToken, bool, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.VerifyLogoutToken(ctx, token)
+}
+
 type privateTimeoutOperations struct {
 	*publicTimeoutOperations
 	delegate PrivateOperations
 }
 
+func (pto *privateTimeoutOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...AuthCodeURLOption) (string, bool, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.PushedAuthCodeURL(ctx, state, opts...)
+}
+
 func (pto *privateTimeoutOperations) AuthCodeExchange(ctx context.Context, code string, opts ...AuthCodeExchangeOption) (*Token, error) {
 	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
 	defer cancel()
@@ -196,6 +221,62 @@ func (pto *privateTimeoutOperations) TokenExchange(ctx context.Context, t *Token
 	return pto.delegate.TokenExchange(ctx, t, opts...)
 }
 
+func (pto *privateTimeoutOperations) JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...JWTBearerOption) (*Token, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.JWTBearer(ctx, key, opts...)
+}
+
+func (pto *privateTimeoutOperations) SAML2Bearer(ctx context.Context, assertion string, opts ...SAML2BearerOption) (*Token, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.SAML2Bearer(ctx, assertion, opts...)
+}
+
+func (pto *privateTimeoutOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...PasswordCredentialsOption) (*Token, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.PasswordCredentials(ctx, username, password, opts...)
+}
+
+func (pto *privateTimeoutOperations) BackchannelAuth(ctx context.Context, opts ...BackchannelAuthOption) (*ciba.Auth, bool, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.BackchannelAuth(ctx, opts...)
+}
+
+func (pto *privateTimeoutOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...BackchannelAuthExchangeOption) (*Token, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.BackchannelAuthExchange(ctx, authReqID, opts...)
+}
+
+func (pto *privateTimeoutOperations) Revoke(ctx context.Context, token string, opts ...RevokeOption) (bool, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.Revoke(ctx, token, opts...)
+}
+
+func (pto *privateTimeoutOperations) Introspect(ctx context.Context, token string, opts ...IntrospectOption) (*introspection.Result, bool, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.Introspect(ctx, token, opts...)
+}
+
+func (pto *privateTimeoutOperations) OnBehalfOf(ctx context.Context, t *Token, opts ...OnBehalfOfOption) (*Token, error) {
+	ctx, cancel := contextWithTimeout(ctx, pto.alg, nil)
+	defer cancel()
+
+	return pto.delegate.OnBehalfOf(ctx, t, opts...)
+}
+
 type TimeoutProvider struct {
 	delegate Provider
 	alg      TimeoutAlgorithm
@@ -88,6 +88,9 @@ func (acc *AuthCodeChecker) Check(ctx context.Context, entry *persistence.AuthCo
 	case entry.RefreshToken != "":
 		// Token expires, but it has a valid refresh token.
 		return nil
+	case entry.JWTBearer != nil:
+		// Token expires, but we can issue a new one using a new assertion.
+		return nil
 	case acc.nonRefreshableTTL <= 0, entry.Expiry.Add(acc.nonRefreshableTTL).After(now):
 		// Token expires, but it is not yet ready to be reaped.
 		return nil
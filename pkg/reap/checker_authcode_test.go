@@ -75,6 +75,20 @@ func TestAuthCodeChecker(t *testing.T) {
 			Step:          time.Duration(persistence.DefaultConfigTuningEntry.ReapNonRefreshableSeconds) * time.Second,
 			ExpectedError: "token expired",
 		},
+		{
+			Name:              "Reissuable using JWT bearer grant, expired",
+			ConfigTuningEntry: persistence.DefaultConfigTuningEntry,
+			AuthCodeEntry: &persistence.AuthCodeEntry{
+				Token: &provider.Token{
+					Token: &oauth2.Token{
+						AccessToken: "test",
+						Expiry:      clk.Now(),
+					},
+				},
+				JWTBearer: &persistence.JWTBearerConfig{},
+			},
+			Step: time.Duration(persistence.DefaultConfigTuningEntry.ReapNonRefreshableSeconds) * time.Second,
+		},
 		{
 			Name: "Non-refreshable, expired, and non-refreshable reap criterion disabled",
 			ConfigTuningEntry: persistence.ConfigTuningEntry{
@@ -5,10 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"sync"
 
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/clientauth"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/devicecode"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/introspection"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/jwtkey"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/semerr"
 	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
 	"github.com/puppetlabs/leg/errmap/pkg/errmark"
@@ -41,33 +46,85 @@ func MockErrorResponse(statusCode int, obj interface{}) *oauth2.RetrieveError {
 }
 
 type (
-	MockAuthCodeExchangeFunc   func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error)
-	MockClientCredentialsFunc  func(opts *provider.ClientCredentialsOptions) (*provider.Token, error)
-	MockDeviceCodeAuthFunc     func(opts *provider.DeviceCodeAuthOptions) (*devicecode.Auth, error)
-	MockDeviceCodeExchangeFunc func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error)
-	MockTokenExchangeFunc      func(t *provider.Token, opts *provider.TokenExchangeOptions) (*provider.Token, error)
+	MockAuthCodeExchangeFunc        func(code string, opts *provider.AuthCodeExchangeOptions) (*provider.Token, error)
+	MockClientCredentialsFunc       func(opts *provider.ClientCredentialsOptions) (*provider.Token, error)
+	MockDeviceCodeAuthFunc          func(opts *provider.DeviceCodeAuthOptions) (*devicecode.Auth, error)
+	MockDeviceCodeExchangeFunc      func(deviceCode string, opts *provider.DeviceCodeExchangeOptions) (*provider.Token, error)
+	MockTokenExchangeFunc           func(t *provider.Token, opts *provider.TokenExchangeOptions) (*provider.Token, error)
+	MockJWTBearerFunc               func(opts *provider.JWTBearerOptions) (*provider.Token, error)
+	MockSAML2BearerFunc             func(assertion string, opts *provider.SAML2BearerOptions) (*provider.Token, error)
+	MockPasswordCredentialsFunc     func(username, password string, opts *provider.PasswordCredentialsOptions) (*provider.Token, error)
+	MockBackchannelAuthFunc         func(opts *provider.BackchannelAuthOptions) (*ciba.Auth, error)
+	MockBackchannelAuthExchangeFunc func(authReqID string, opts *provider.BackchannelAuthExchangeOptions) (*provider.Token, error)
+	MockIntrospectFunc              func(token string, opts *provider.IntrospectOptions) (*introspection.Result, error)
+	MockRevokeFunc                  func(token string, opts *provider.RevokeOptions) error
+	MockOnBehalfOfFunc              func(t *provider.Token, opts *provider.OnBehalfOfOptions) (*provider.Token, error)
+	MockVerifyLogoutTokenFunc       func(token string) (*provider.LogoutToken, error)
 )
 
 type mockOperations struct {
-	clientID             string
-	owner                *mock
-	authCodeExchangeFn   MockAuthCodeExchangeFunc
-	clientCredentialsFn  MockClientCredentialsFunc
-	deviceCodeAuthFn     MockDeviceCodeAuthFunc
-	deviceCodeExchangeFn MockDeviceCodeExchangeFunc
-	tokenExchangeFn      MockTokenExchangeFunc
+	clientID                  string
+	clientSecret              string
+	owner                     *mock
+	authCodeExchangeFn        MockAuthCodeExchangeFunc
+	clientCredentialsFn       MockClientCredentialsFunc
+	deviceCodeAuthFn          MockDeviceCodeAuthFunc
+	deviceCodeExchangeFn      MockDeviceCodeExchangeFunc
+	tokenExchangeFn           MockTokenExchangeFunc
+	jwtBearerFn               MockJWTBearerFunc
+	saml2BearerFn             MockSAML2BearerFunc
+	passwordCredentialsFn     MockPasswordCredentialsFunc
+	backchannelAuthFn         MockBackchannelAuthFunc
+	backchannelAuthExchangeFn MockBackchannelAuthExchangeFunc
+	onBehalfOfFn              MockOnBehalfOfFunc
+	revokeFn                  MockRevokeFunc
+	introspectFn              MockIntrospectFunc
+	verifyLogoutTokenFn       MockVerifyLogoutTokenFunc
 }
 
-func (mo *mockOperations) AuthCodeURL(state string, opts ...provider.AuthCodeURLOption) (string, bool) {
+func (mo *mockOperations) AuthCodeURL(state string, opts ...provider.AuthCodeURLOption) (string, bool, error) {
 	o := &provider.AuthCodeURLOptions{}
 	o.ApplyOptions(opts)
 
+	if o.CodeVerifier != "" {
+		o.AuthCodeOptions = append(o.AuthCodeOptions, oauth2.S256ChallengeOption(o.CodeVerifier))
+	}
+	if o.Nonce != "" {
+		o.AuthCodeOptions = append([]oauth2.AuthCodeOption{oauth2.SetAuthURLParam("nonce", o.Nonce)}, o.AuthCodeOptions...)
+	}
+
 	return (&oauth2.Config{
 		ClientID:    mo.clientID,
 		Endpoint:    MockEndpoint.Endpoint,
 		Scopes:      o.Scopes,
 		RedirectURL: o.RedirectURL,
-	}).AuthCodeURL(state, o.AuthCodeOptions...), true
+	}).AuthCodeURL(state, o.AuthCodeOptions...), true, nil
+}
+
+func (mo *mockOperations) PKCESupported(opts ...provider.AuthCodeURLOption) bool {
+	return mo.owner.pkceSupported
+}
+
+func (mo *mockOperations) LogoutURL(idTokenHint string, opts ...provider.LogoutURLOption) (string, bool) {
+	o := &provider.LogoutURLOptions{}
+	o.ApplyOptions(opts)
+
+	v := url.Values{"client_id": {mo.clientID}}
+	if idTokenHint != "" {
+		v.Set("id_token_hint", idTokenHint)
+	}
+	if o.RedirectURL != "" {
+		v.Set("post_logout_redirect_uri", o.RedirectURL)
+	}
+	if o.State != "" {
+		v.Set("state", o.State)
+	}
+
+	return MockEndpoint.EndSessionURL + "?" + v.Encode(), true
+}
+
+func (mo *mockOperations) PushedAuthCodeURL(ctx context.Context, state string, opts ...provider.AuthCodeURLOption) (string, bool, error) {
+	return "", false, nil
 }
 
 func (mo *mockOperations) DeviceCodeAuth(ctx context.Context, opts ...provider.DeviceCodeAuthOption) (*devicecode.Auth, bool, error) {
@@ -123,6 +180,10 @@ func (mo *mockOperations) AuthCodeExchange(ctx context.Context, code string, opt
 	o := &provider.AuthCodeExchangeOptions{}
 	o.ApplyOptions(opts)
 
+	if _, ok := clientauth.AuthenticatorFromContext(ctx); !ok && mo.clientSecret == "" && o.CodeVerifier == "" {
+		return nil, errmark.MarkUser(provider.ErrMissingClientSecret)
+	}
+
 	tok, err := mo.authCodeExchangeFn(code, o)
 	if err != nil {
 		return nil, semerr.Map(err)
@@ -208,6 +269,197 @@ func (mo *mockOperations) TokenExchange(ctx context.Context, t *provider.Token,
 	return tok, nil
 }
 
+func (mo *mockOperations) JWTBearer(ctx context.Context, key *jwtkey.Key, opts ...provider.JWTBearerOption) (*provider.Token, error) {
+	if mo.jwtBearerFn == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	} else if key == nil || key.Signer == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: "invalid_grant"}))
+	}
+
+	o := &provider.JWTBearerOptions{}
+	o.ApplyOptions(opts)
+
+	tok, err := mo.jwtBearerFn(o)
+	if err != nil {
+		return nil, semerr.Map(err)
+	}
+
+	tok.ProviderVersion = mo.owner.vsn
+	tok.ProviderOptions = o.ProviderOptions
+
+	return tok, nil
+}
+
+func (mo *mockOperations) SAML2Bearer(ctx context.Context, assertion string, opts ...provider.SAML2BearerOption) (*provider.Token, error) {
+	if mo.saml2BearerFn == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	}
+
+	o := &provider.SAML2BearerOptions{}
+	o.ApplyOptions(opts)
+
+	tok, err := mo.saml2BearerFn(assertion, o)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
+	// Refreshing the token replays the assertion through the authorization
+	// code exchange function, if any.
+	if tok.RefreshToken != "" {
+		mo.owner.putRefreshTokenCode(tok.RefreshToken, assertion)
+	}
+
+	tok.ProviderVersion = mo.owner.vsn
+	tok.ProviderOptions = o.ProviderOptions
+
+	return tok, nil
+}
+
+func (mo *mockOperations) PasswordCredentials(ctx context.Context, username, password string, opts ...provider.PasswordCredentialsOption) (*provider.Token, error) {
+	if mo.passwordCredentialsFn == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	}
+
+	o := &provider.PasswordCredentialsOptions{}
+	o.ApplyOptions(opts)
+
+	tok, err := mo.passwordCredentialsFn(username, password, o)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
+	// Refreshing the token passes the username to the authorization code
+	// exchange function, if any.
+	if tok.RefreshToken != "" {
+		mo.owner.putRefreshTokenCode(tok.RefreshToken, username)
+	}
+
+	tok.ProviderVersion = mo.owner.vsn
+	tok.ProviderOptions = o.ProviderOptions
+
+	return tok, nil
+}
+
+func (mo *mockOperations) BackchannelAuth(ctx context.Context, opts ...provider.BackchannelAuthOption) (*ciba.Auth, bool, error) {
+	if mo.backchannelAuthFn == nil {
+		return nil, false, nil
+	}
+
+	o := &provider.BackchannelAuthOptions{}
+	o.ApplyOptions(opts)
+
+	auth, err := mo.backchannelAuthFn(o)
+	if err != nil {
+		return nil, false, semerr.Map(err)
+	}
+
+	return auth, true, nil
+}
+
+func (mo *mockOperations) BackchannelAuthExchange(ctx context.Context, authReqID string, opts ...provider.BackchannelAuthExchangeOption) (*provider.Token, error) {
+	if mo.backchannelAuthExchangeFn == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	}
+
+	o := &provider.BackchannelAuthExchangeOptions{}
+	o.ApplyOptions(opts)
+
+	tok, err := mo.backchannelAuthExchangeFn(authReqID, o)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(
+			err,
+			errmark.RuleAny(
+				semerr.RuleCode("access_denied"),
+				semerr.RuleCode("expired_token"),
+				semerr.RuleCode("transaction_failed"),
+			),
+		)
+
+		return nil, err
+	}
+
+	tok.ProviderVersion = mo.owner.vsn
+	tok.ProviderOptions = o.ProviderOptions
+
+	return tok, nil
+}
+
+func (mo *mockOperations) OnBehalfOf(ctx context.Context, t *provider.Token, opts ...provider.OnBehalfOfOption) (*provider.Token, error) {
+	if mo.onBehalfOfFn == nil {
+		return nil, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	}
+
+	o := &provider.OnBehalfOfOptions{}
+	o.ApplyOptions(opts)
+
+	tok, err := mo.onBehalfOfFn(t, o)
+	if err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("invalid_grant"))
+
+		return nil, err
+	}
+
+	tok.ProviderVersion = mo.owner.vsn
+	tok.ProviderOptions = o.ProviderOptions
+
+	return tok, nil
+}
+
+func (mo *mockOperations) Revoke(ctx context.Context, token string, opts ...provider.RevokeOption) (bool, error) {
+	if mo.revokeFn == nil {
+		return false, nil
+	}
+
+	o := &provider.RevokeOptions{}
+	o.ApplyOptions(opts)
+
+	if err := mo.revokeFn(token, o); err != nil {
+		err = semerr.Map(err)
+		err = errmark.MarkUserIf(err, semerr.RuleCode("unsupported_token_type"))
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (mo *mockOperations) Introspect(ctx context.Context, token string, opts ...provider.IntrospectOption) (*introspection.Result, bool, error) {
+	if mo.introspectFn == nil {
+		return nil, false, semerr.Map(MockErrorResponse(http.StatusUnauthorized, &interop.JSONError{Error: "invalid_client"}))
+	}
+
+	o := &provider.IntrospectOptions{}
+	o.ApplyOptions(opts)
+
+	r, err := mo.introspectFn(token, o)
+	if err != nil {
+		return nil, false, semerr.Map(err)
+	}
+
+	return r, true, nil
+}
+
+func (mo *mockOperations) VerifyLogoutToken(ctx context.Context, token string) (*provider.LogoutToken, bool, error) {
+	if mo.verifyLogoutTokenFn == nil {
+		return nil, false, nil
+	}
+
+	lt, err := mo.verifyLogoutTokenFn(token)
+	if err != nil {
+		return nil, true, errmark.MarkUser(err)
+	}
+
+	return lt, true, nil
+}
+
 type mockProvider struct {
 	owner *mock
 }
@@ -224,26 +476,46 @@ func (mp *mockProvider) Private(clientID, clientSecret string) provider.PrivateO
 	mc := MockClient{ID: clientID, Secret: clientSecret}
 
 	return &mockOperations{
-		clientID:             clientID,
-		authCodeExchangeFn:   mp.owner.authCodeExchangeFns[mc],
-		clientCredentialsFn:  mp.owner.clientCredentialsFns[mc],
-		deviceCodeAuthFn:     mp.owner.deviceCodeAuthFns[mc],
-		deviceCodeExchangeFn: mp.owner.deviceCodeExchangeFns[mc],
-		tokenExchangeFn:      mp.owner.tokenExchangeFns[mc],
-		owner:                mp.owner,
+		clientID:                  clientID,
+		clientSecret:              clientSecret,
+		authCodeExchangeFn:        mp.owner.authCodeExchangeFns[mc],
+		clientCredentialsFn:       mp.owner.clientCredentialsFns[mc],
+		deviceCodeAuthFn:          mp.owner.deviceCodeAuthFns[mc],
+		deviceCodeExchangeFn:      mp.owner.deviceCodeExchangeFns[mc],
+		tokenExchangeFn:           mp.owner.tokenExchangeFns[mc],
+		jwtBearerFn:               mp.owner.jwtBearerFns[mc],
+		saml2BearerFn:             mp.owner.saml2BearerFns[mc],
+		passwordCredentialsFn:     mp.owner.passwordCredentialsFns[mc],
+		backchannelAuthFn:         mp.owner.backchannelAuthFns[mc],
+		backchannelAuthExchangeFn: mp.owner.backchannelAuthExchangeFns[mc],
+		onBehalfOfFn:              mp.owner.onBehalfOfFns[mc],
+		revokeFn:                  mp.owner.revokeFns[mc],
+		introspectFn:              mp.owner.introspectFns[mc],
+		verifyLogoutTokenFn:       mp.owner.verifyLogoutTokenFns[clientID],
+		owner:                     mp.owner,
 	}
 }
 
 type mock struct {
-	vsn                   int
-	expectedOpts          map[string]string
-	authCodeExchangeFns   map[MockClient]MockAuthCodeExchangeFunc
-	clientCredentialsFns  map[MockClient]MockClientCredentialsFunc
-	deviceCodeAuthFns     map[MockClient]MockDeviceCodeAuthFunc
-	deviceCodeExchangeFns map[MockClient]MockDeviceCodeExchangeFunc
-	tokenExchangeFns      map[MockClient]MockTokenExchangeFunc
-	refresh               map[string]string
-	refreshMut            sync.RWMutex
+	vsn                        int
+	expectedOpts               map[string]string
+	pkceSupported              bool
+	authCodeExchangeFns        map[MockClient]MockAuthCodeExchangeFunc
+	clientCredentialsFns       map[MockClient]MockClientCredentialsFunc
+	deviceCodeAuthFns          map[MockClient]MockDeviceCodeAuthFunc
+	deviceCodeExchangeFns      map[MockClient]MockDeviceCodeExchangeFunc
+	tokenExchangeFns           map[MockClient]MockTokenExchangeFunc
+	jwtBearerFns               map[MockClient]MockJWTBearerFunc
+	saml2BearerFns             map[MockClient]MockSAML2BearerFunc
+	passwordCredentialsFns     map[MockClient]MockPasswordCredentialsFunc
+	backchannelAuthFns         map[MockClient]MockBackchannelAuthFunc
+	backchannelAuthExchangeFns map[MockClient]MockBackchannelAuthExchangeFunc
+	onBehalfOfFns              map[MockClient]MockOnBehalfOfFunc
+	revokeFns                  map[MockClient]MockRevokeFunc
+	introspectFns              map[MockClient]MockIntrospectFunc
+	verifyLogoutTokenFns       map[string]MockVerifyLogoutTokenFunc
+	refresh                    map[string]string
+	refreshMut                 sync.RWMutex
 }
 
 func (m *mock) factory(ctx context.Context, vsn int, options map[string]string) (provider.Provider, error) {
@@ -305,6 +577,12 @@ func MockWithExpectedOptionValue(opt, value string) MockOption {
 	}
 }
 
+func MockWithPKCESupported() MockOption {
+	return func(m *mock) {
+		m.pkceSupported = true
+	}
+}
+
 func MockWithAuthCodeExchange(client MockClient, fn MockAuthCodeExchangeFunc) MockOption {
 	return func(m *mock) {
 		m.authCodeExchangeFns[client] = fn
@@ -335,15 +613,78 @@ func MockWithTokenExchange(client MockClient, fn MockTokenExchangeFunc) MockOpti
 	}
 }
 
+func MockWithJWTBearer(client MockClient, fn MockJWTBearerFunc) MockOption {
+	return func(m *mock) {
+		m.jwtBearerFns[client] = fn
+	}
+}
+
+func MockWithSAML2Bearer(client MockClient, fn MockSAML2BearerFunc) MockOption {
+	return func(m *mock) {
+		m.saml2BearerFns[client] = fn
+	}
+}
+
+func MockWithPasswordCredentials(client MockClient, fn MockPasswordCredentialsFunc) MockOption {
+	return func(m *mock) {
+		m.passwordCredentialsFns[client] = fn
+	}
+}
+
+func MockWithBackchannelAuth(client MockClient, fn MockBackchannelAuthFunc) MockOption {
+	return func(m *mock) {
+		m.backchannelAuthFns[client] = fn
+	}
+}
+
+func MockWithBackchannelAuthExchange(client MockClient, fn MockBackchannelAuthExchangeFunc) MockOption {
+	return func(m *mock) {
+		m.backchannelAuthExchangeFns[client] = fn
+	}
+}
+
+func MockWithOnBehalfOf(client MockClient, fn MockOnBehalfOfFunc) MockOption {
+	return func(m *mock) {
+		m.onBehalfOfFns[client] = fn
+	}
+}
+
+func MockWithRevoke(client MockClient, fn MockRevokeFunc) MockOption {
+	return func(m *mock) {
+		m.revokeFns[client] = fn
+	}
+}
+
+func MockWithIntrospect(client MockClient, fn MockIntrospectFunc) MockOption {
+	return func(m *mock) {
+		m.introspectFns[client] = fn
+	}
+}
+
+func MockWithVerifyLogoutToken(clientID string, fn MockVerifyLogoutTokenFunc) MockOption {
+	return func(m *mock) {
+		m.verifyLogoutTokenFns[clientID] = fn
+	}
+}
+
 func MockFactory(opts ...MockOption) provider.FactoryFunc {
 	m := &mock{
-		expectedOpts:          make(map[string]string),
-		authCodeExchangeFns:   make(map[MockClient]MockAuthCodeExchangeFunc),
-		clientCredentialsFns:  make(map[MockClient]MockClientCredentialsFunc),
-		deviceCodeAuthFns:     make(map[MockClient]MockDeviceCodeAuthFunc),
-		deviceCodeExchangeFns: make(map[MockClient]MockDeviceCodeExchangeFunc),
-		tokenExchangeFns:      make(map[MockClient]MockTokenExchangeFunc),
-		refresh:               make(map[string]string),
+		expectedOpts:               make(map[string]string),
+		authCodeExchangeFns:        make(map[MockClient]MockAuthCodeExchangeFunc),
+		clientCredentialsFns:       make(map[MockClient]MockClientCredentialsFunc),
+		deviceCodeAuthFns:          make(map[MockClient]MockDeviceCodeAuthFunc),
+		deviceCodeExchangeFns:      make(map[MockClient]MockDeviceCodeExchangeFunc),
+		tokenExchangeFns:           make(map[MockClient]MockTokenExchangeFunc),
+		jwtBearerFns:               make(map[MockClient]MockJWTBearerFunc),
+		saml2BearerFns:             make(map[MockClient]MockSAML2BearerFunc),
+		passwordCredentialsFns:     make(map[MockClient]MockPasswordCredentialsFunc),
+		backchannelAuthFns:         make(map[MockClient]MockBackchannelAuthFunc),
+		backchannelAuthExchangeFns: make(map[MockClient]MockBackchannelAuthExchangeFunc),
+		onBehalfOfFns:              make(map[MockClient]MockOnBehalfOfFunc),
+		revokeFns:                  make(map[MockClient]MockRevokeFunc),
+		introspectFns:              make(map[MockClient]MockIntrospectFunc),
+		verifyLogoutTokenFns:       make(map[string]MockVerifyLogoutTokenFunc),
+		refresh:                    make(map[string]string),
 	}
 
 	MockWithVersion(1)(m)
@@ -0,0 +1,41 @@
+package testutil
+
+import (
+	"net/http"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/ciba"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+)
+
+func StaticMockBackchannelAuth(auth *ciba.Auth) MockBackchannelAuthFunc {
+	return func(_ *provider.BackchannelAuthOptions) (*ciba.Auth, error) {
+		return auth, nil
+	}
+}
+
+func AmendTokenMockBackchannelAuthExchange(get MockBackchannelAuthExchangeFunc, amend func(token *provider.Token) error) MockBackchannelAuthExchangeFunc {
+	return func(candidate string, opts *provider.BackchannelAuthExchangeOptions) (*provider.Token, error) {
+		token, err := get(candidate, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := amend(token); err != nil {
+			return nil, err
+		}
+
+		return token, nil
+	}
+}
+
+func ErrorMockBackchannelAuthExchange(errType string) MockBackchannelAuthExchangeFunc {
+	return func(_ string, _ *provider.BackchannelAuthExchangeOptions) (*provider.Token, error) {
+		return nil, MockErrorResponse(http.StatusBadRequest, &interop.JSONError{Error: errType})
+	}
+}
+
+var (
+	AuthorizationPendingErrorMockBackchannelAuthExchange = ErrorMockBackchannelAuthExchange("authorization_pending")
+	AccessDeniedErrorMockBackchannelAuthExchange         = ErrorMockBackchannelAuthExchange("access_denied")
+)
@@ -12,6 +12,7 @@ import (
 const (
 	MockAuthCodeURL   = "http://localhost/authorize"
 	MockDeviceCodeURL = "http://localhost/device"
+	MockEndSessionURL = "http://localhost/logout"
 	MockTokenURL      = "http://localhost/token"
 )
 
@@ -39,5 +40,6 @@ var MockEndpoint = provider.Endpoint{
 		AuthURL:  MockAuthCodeURL,
 		TokenURL: MockTokenURL,
 	},
-	DeviceURL: MockDeviceCodeURL,
+	DeviceURL:     MockDeviceCodeURL,
+	EndSessionURL: MockEndSessionURL,
 }
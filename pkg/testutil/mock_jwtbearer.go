@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+func StaticMockJWTBearer(token *provider.Token) MockJWTBearerFunc {
+	return func(_ *provider.JWTBearerOptions) (*provider.Token, error) {
+		return token, nil
+	}
+}
+
+func AmendTokenMockJWTBearer(get MockJWTBearerFunc, amend func(token *provider.Token) error) MockJWTBearerFunc {
+	return func(opts *provider.JWTBearerOptions) (*provider.Token, error) {
+		token, err := get(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := amend(token); err != nil {
+			return nil, err
+		}
+
+		return token, nil
+	}
+}
+
+func ExpiringMockJWTBearer(fn MockJWTBearerFunc, duration time.Duration) MockJWTBearerFunc {
+	return AmendTokenMockJWTBearer(fn, func(t *provider.Token) error {
+		t.Expiry = time.Now().Add(duration)
+		return nil
+	})
+}
+
+func IncrementMockJWTBearer(prefix string) MockJWTBearerFunc {
+	var i int32
+
+	return func(_ *provider.JWTBearerOptions) (*provider.Token, error) {
+		t := &oauth2.Token{
+			AccessToken: fmt.Sprintf("%s%d", prefix, atomic.AddInt32(&i, 1)),
+		}
+		return &provider.Token{Token: t}, nil
+	}
+}
@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+func StaticMockOnBehalfOf(token *provider.Token) MockOnBehalfOfFunc {
+	return func(_ *provider.Token, _ *provider.OnBehalfOfOptions) (*provider.Token, error) {
+		return token, nil
+	}
+}
+
+func AmendTokenMockOnBehalfOf(get MockOnBehalfOfFunc, amend func(token *provider.Token) error) MockOnBehalfOfFunc {
+	return func(t *provider.Token, opts *provider.OnBehalfOfOptions) (*provider.Token, error) {
+		token, err := get(t, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := amend(token); err != nil {
+			return nil, err
+		}
+
+		return token, nil
+	}
+}
+
+func ExpiringMockOnBehalfOf(fn MockOnBehalfOfFunc, duration time.Duration) MockOnBehalfOfFunc {
+	return AmendTokenMockOnBehalfOf(fn, func(t *provider.Token) error {
+		t.Expiry = time.Now().Add(duration)
+		return nil
+	})
+}
+
+func IncrementMockOnBehalfOf(prefix string) MockOnBehalfOfFunc {
+	var i int32
+
+	return func(_ *provider.Token, _ *provider.OnBehalfOfOptions) (*provider.Token, error) {
+		t := &oauth2.Token{
+			AccessToken: fmt.Sprintf("%s%d", prefix, atomic.AddInt32(&i, 1)),
+		}
+		return &provider.Token{Token: t}, nil
+	}
+}
@@ -0,0 +1,19 @@
+package testutil
+
+import (
+	"net/http"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/oauth2ext/interop"
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+)
+
+func ErrorMockRevoke(status int, errType string) MockRevokeFunc {
+	return func(_ string, _ *provider.RevokeOptions) error {
+		return MockErrorResponse(status, &interop.JSONError{Error: errType})
+	}
+}
+
+var (
+	ServiceUnavailableErrorMockRevoke   = ErrorMockRevoke(http.StatusServiceUnavailable, "temporarily_unavailable")
+	UnsupportedTokenTypeErrorMockRevoke = ErrorMockRevoke(http.StatusBadRequest, "unsupported_token_type")
+)
@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/openbao/openbao-plugin-secrets-oauthapp/v3/pkg/provider"
+	"golang.org/x/oauth2"
+)
+
+func StaticMockSAML2Bearer(token *provider.Token) MockSAML2BearerFunc {
+	return func(_ string, _ *provider.SAML2BearerOptions) (*provider.Token, error) {
+		return token, nil
+	}
+}
+
+func AmendTokenMockSAML2Bearer(get MockSAML2BearerFunc, amend func(token *provider.Token) error) MockSAML2BearerFunc {
+	return func(assertion string, opts *provider.SAML2BearerOptions) (*provider.Token, error) {
+		token, err := get(assertion, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := amend(token); err != nil {
+			return nil, err
+		}
+
+		return token, nil
+	}
+}
+
+func ExpiringMockSAML2Bearer(fn MockSAML2BearerFunc, duration time.Duration) MockSAML2BearerFunc {
+	return AmendTokenMockSAML2Bearer(fn, func(t *provider.Token) error {
+		t.Expiry = time.Now().Add(duration)
+		return nil
+	})
+}
+
+func IncrementMockSAML2Bearer(prefix string) MockSAML2BearerFunc {
+	var i int32
+
+	return func(_ string, _ *provider.SAML2BearerOptions) (*provider.Token, error) {
+		t := &oauth2.Token{
+			AccessToken: fmt.Sprintf("%s%d", prefix, atomic.AddInt32(&i, 1)),
+		}
+		return &provider.Token{Token: t}, nil
+	}
+}